@@ -0,0 +1,63 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accessLog writes one line per request in the Combined Log Format, with
+// the duration and whether the cache answered appended:
+//
+//	host - - [time] "GET url HTTP/1.1" status bytes "referer" "user-agent" 12ms MISS
+//
+// Live fetches are marked MISS; cache hits, logged only if asked for, HIT.
+// Status and size are "-" for fetches that got no response.
+type accessLog struct {
+	mu   sync.Mutex
+	w    io.Writer
+	hits bool
+}
+
+func (l *accessLog) log(req *http.Request, entry *CacheEntry, d time.Duration, hit bool) {
+	if l == nil || (hit && !l.hits) {
+		return
+	}
+	status, size := "-", "-"
+	if entry.StatusCode != 0 {
+		status = strconv.Itoa(entry.StatusCode)
+		size = strconv.Itoa(len(entry.Data))
+	}
+	marker := "MISS"
+	if hit {
+		marker = "HIT"
+	}
+	line := fmt.Sprintf("%s - - [%s] %q %s %s %q %q %dms %s\n",
+		req.URL.Host,
+		time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method+" "+req.URL.String()+" "+req.Proto,
+		status, size,
+		orDash(req.Referer()), orDash(req.UserAgent()),
+		d.Milliseconds(), marker)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// hit records that entry, stored under key, answered req for url from the
+// cache.
+func (hc *HTTPClient) hit(key, url string, req *http.Request, entry *CacheEntry) {
+	hc.cache.events.publish(CacheEvent{Kind: EventHit, Key: key, URL: url})
+	hc.accessLog.log(req, entry, 0, true)
+}
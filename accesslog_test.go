@@ -0,0 +1,47 @@
+package httpcache
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	server, _ := countingServer(t)
+	var buf bytes.Buffer
+	client := newTestClient(t, WithAccessLog(&buf, true))
+
+	client.Do(server.URL+"/page", &RequestOptions{Referer: "http://example.com/"})
+	client.Do(server.URL+"/page", nil)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	miss := regexp.MustCompile(`^` + regexp.QuoteMeta(host) +
+		` - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET ` + regexp.QuoteMeta(server.URL) +
+		`/page HTTP/1.1" 200 10 "http://example.com/" "[^"]+" \d+ms MISS$`)
+	if !miss.MatchString(lines[0]) {
+		t.Errorf("fetch logged as\n%s", lines[0])
+	}
+	if !strings.Contains(lines[1], `" 200 10 "-" "`) || !strings.HasSuffix(lines[1], " 0ms HIT") {
+		t.Errorf("hit logged as\n%s", lines[1])
+	}
+
+	buf.Reset()
+	quiet := newTestClient(t, WithAccessLog(&buf, false))
+	quiet.Do(server.URL, nil)
+	quiet.Do(server.URL, nil)
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("logged %d lines without hits, want 1:\n%s", n, buf.String())
+	}
+	if _, err := quiet.Do("http://127.0.0.1:1/", nil); err == nil {
+		t.Fatal("fetch from a closed port succeeded")
+	}
+	failed := regexp.MustCompile(`"GET http://127.0.0.1:1/ HTTP/1.1" - - "-" "[^"]+" \d+ms MISS\n$`)
+	if !failed.MatchString(buf.String()) {
+		t.Errorf("failed fetch logged as\n%s", buf.String())
+	}
+}
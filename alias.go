@@ -0,0 +1,124 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// contentLocation returns the absolute URL named by the Content-Location
+// header of entry, or "" if there is none, it cannot be parsed, it names the
+// requested URL itself, or it lies on another origin than the one the
+// response came from. A server may only vouch for its own URLs.
+func contentLocation(entry *CacheEntry) string {
+	loc := entry.Header.Get("Content-Location")
+	if loc == "" {
+		return ""
+	}
+	base, err := url.Parse(entry.FinalURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(loc)
+	if err != nil {
+		return ""
+	}
+	target := base.ResolveReference(ref)
+	if target.Scheme != base.Scheme || target.Host != base.Host {
+		return ""
+	}
+	target.Fragment = ""
+	if alias := target.String(); alias != entry.URL {
+		return alias
+	}
+	return ""
+}
+
+// storeContentLocation caches entry, just fetched by req, a GET, under the
+// URL its Content-Location header names as well.
+func (hc *HTTPClient) storeContentLocation(req *http.Request, entry *CacheEntry) {
+	if req.Method != http.MethodGet {
+		return
+	}
+	if alias := contentLocation(entry); alias != "" {
+		hc.copyEntryTo(alias, req, entry, true)
+	}
+}
+
+// GetWithAliases is like GetWithValidator for primary, a URL known to serve
+// the same content as each of aliases, such as its variants with tracking
+// parameters or other scheme. Once primary is fetched, or found in the
+// cache, the entry cached for it is copied under every alias that has no
+// fresh entry of its own, so later requests for any of them are hits without
+// a fetch. Each copy is a full entry, with the TTL of the alias's policy or
+// the per-call TTL the original was stored with, and expires and is deleted
+// independently of the others. Nothing is copied if the body was not cached,
+// for example because validator rejected it.
+func (hc *HTTPClient) GetWithAliases(primary string, aliases []string, validator ContentValidator) ([]byte, string, error) {
+	r, err := hc.Do(primary, &RequestOptions{Validator: validator})
+	if err != nil {
+		return r.Data, r.FinalURL, err
+	}
+	if !hc.Enabled() {
+		return r.Data, r.FinalURL, nil
+	}
+	if entry, ok := hc.cache.GetEntry(hc.key(primary)); ok {
+		for _, alias := range aliases {
+			hc.copyEntryTo(alias, nil, entry, false)
+		}
+	}
+	return r.Data, r.FinalURL, nil
+}
+
+// copyEntryTo caches a copy of entry, fetched by req, under alias, keyed as
+// req would be had it asked for alias, so a response fetched with key-relevant
+// headers, such as credentials under WithAuthInKey, or with a Host override
+// is not copied where a plain request for alias finds it. A nil req stands
+// for a plain GET. The copy gets the TTL of alias's policy, or the per-call
+// TTL the entry was fetched with; without either nothing is copied. A fresh
+// entry already under alias is kept unless replace is set. Nothing is copied
+// while the cache is disabled with SetEnabled.
+func (hc *HTTPClient) copyEntryTo(alias string, req *http.Request, entry *CacheEntry, replace bool) {
+	if !hc.Enabled() {
+		return
+	}
+	key, ok := hc.aliasKey(alias, req)
+	if !ok {
+		return
+	}
+	if !replace {
+		if _, fresh := hc.cache.GetEntry(key); fresh {
+			return
+		}
+	}
+	ttl := hc.cache.GetTTL(alias)
+	if entry.TTL > 0 {
+		ttl = entry.TTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	copied := *entry
+	copied.URL = alias
+	hc.cache.SetEntry(key, &copied, ttl)
+	hc.cache.events.publish(CacheEvent{Kind: EventStore, Key: key, URL: alias})
+}
+
+// aliasKey returns the store key of alias for a request made like req, or
+// of a plain GET of alias if req is nil. It reports false if alias is not a
+// valid URL.
+func (hc *HTTPClient) aliasKey(alias string, req *http.Request) (string, bool) {
+	if req == nil {
+		return hc.key(alias), true
+	}
+	target, err := url.Parse(alias)
+	if err != nil {
+		return "", false
+	}
+	retargeted := req.Clone(req.Context())
+	retargeted.URL = target
+	if req.Host == req.URL.Host {
+		// Only an overridden Host carries over.
+		retargeted.Host = target.Host
+	}
+	return hc.requestKey(alias, retargeted, ""), true
+}
@@ -0,0 +1,114 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestContentLocation(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Location", "/articles/42?lang=en")
+		case "/evil":
+			w.Header().Set("Content-Location", "http://victim.example/")
+		}
+		fmt.Fprintf(w, "body of %s", r.URL.Path)
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithContentLocation())
+
+	if _, err := client.Do(server.URL+"/article", nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.Do(server.URL+"/articles/42?lang=en", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FromCache || string(r.Data) != "body of /article" {
+		t.Errorf("canonical URL: fromCache=%v data=%q, want the aliased body", r.FromCache, r.Data)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hits = %d, want 1", hits.Load())
+	}
+
+	if _, err := client.Do(server.URL+"/evil", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := client.cache.GetEntry(client.key("http://victim.example/")); found {
+		t.Error("cross-origin Content-Location was stored")
+	}
+
+	plain := newTestClient(t)
+	if _, err := plain.Do(server.URL+"/article", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := plain.cache.GetEntry(plain.key(server.URL + "/articles/42?lang=en")); found {
+		t.Error("Content-Location honored without WithContentLocation")
+	}
+}
+
+func TestContentLocationKeyedLikeRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Location", "/canonical")
+		fmt.Fprintf(w, "auth=%q host=%q", r.Header.Get("Authorization"), r.Host)
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithContentLocation(), WithAuthInKey())
+
+	auth := &RequestOptions{Header: http.Header{"Authorization": {"Bearer secret"}}}
+	if _, err := client.Do(server.URL+"/private", auth); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(server.URL+"/vhost", &RequestOptions{Host: "other.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := client.cache.GetEntry(client.key(server.URL + "/canonical")); found {
+		t.Error("a response fetched with credentials or a Host override was aliased under the plain key")
+	}
+	r, err := client.Do(server.URL+"/canonical", &RequestOptions{
+		Header:     http.Header{"Authorization": {"Bearer secret"}},
+		Preference: CacheOnly,
+	})
+	if err != nil || !r.FromCache || !strings.HasPrefix(string(r.Data), `auth="Bearer secret"`) {
+		t.Errorf("same credentials: %q, %v; want the aliased body", r.Data, err)
+	}
+}
+
+func TestGetWithAliases(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+	aliases := []string{server.URL + "/?utm_source=feed", server.URL + "/index.html"}
+
+	data, _, err := client.GetWithAliases(server.URL+"/", aliases, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alias := range aliases {
+		r, err := client.Do(alias, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.FromCache || string(r.Data) != string(data) {
+			t.Errorf("%s: fromCache=%v data=%q, want the primary's body from cache", alias, r.FromCache, r.Data)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hits = %d, want 1", hits.Load())
+	}
+
+	// Rejected bodies are not cached, so neither are their aliases.
+	other := server.URL + "/other"
+	if _, _, err := client.GetWithAliases(other, []string{other + "?a=1"}, func([]byte) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := client.cache.GetEntry(client.key(other + "?a=1")); found {
+		t.Error("alias of a rejected body was stored")
+	}
+}
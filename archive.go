@@ -0,0 +1,184 @@
+package httpcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// archiveRecord is one line of an archive written by Export.
+type archiveRecord struct {
+	Key   string     `json:"key"`
+	Entry CacheEntry `json:"entry"`
+}
+
+// Export writes every entry in the cache to w as JSON lines, one entry with
+// its store key per line, bodies decoded and deduplicated content resolved.
+// Entries are streamed in key order, so memory use does not grow with the
+// size of the cache.
+func (c *Cache) Export(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := c.forEachEntry(func(key string, entry *CacheEntry) error {
+		return enc.Encode(archiveRecord{Key: key, Entry: *entry})
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// metadataRecord is one line written by ExportMetadata.
+type metadataRecord struct {
+	URL        string    `json:"url"`
+	FinalURL   string    `json:"final_url,omitempty"`
+	StatusCode int       `json:"status_code"`
+	Size       *int      `json:"size,omitempty"`
+	CrawledAt  time.Time `json:"crawled_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Checksum   string    `json:"checksum,omitempty"`
+	// Error says why the body could not be decoded, in which case Size and
+	// Checksum are left out.
+	Error string `json:"error,omitempty"`
+}
+
+// ExportMetadata writes one JSON object per entry to w, one per line, with
+// the entry's URL, final URL, status code, body size, crawl and expiry times
+// and the hex SHA-256 checksum of its body, but not the body itself: the
+// input of a search index rather than an archive Import can read. Entries
+// are read as stored, and only those whose body is encoded, compressed or
+// deduplicated are decoded to measure and checksum it. An entry whose body
+// cannot be decoded still gets its line, with an error field in place of the
+// size and checksum.
+func (c *Cache) ExportMetadata(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := c.forEachStored(func(key string, entry *CacheEntry) error {
+		rec := metadataRecord{
+			URL:        entry.URL,
+			FinalURL:   entry.FinalURL,
+			StatusCode: entry.status(),
+			CrawledAt:  entry.CrawledAt,
+			ExpiresAt:  c.expiresAt(entry),
+		}
+		var err error
+		if entry.ContentHash != "" || entry.Codec != 0 || entry.Encoding != "" {
+			err = c.decodeBody(entry)
+		}
+		if err != nil {
+			rec.Error = err.Error()
+		} else {
+			size := len(entry.Data)
+			rec.Size, rec.Checksum = &size, contentHash(entry.Data)
+		}
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportMode decides what Import does with entries the cache already has.
+type ImportMode int
+
+const (
+	// ImportReplace overwrites existing entries with the archived ones.
+	ImportReplace ImportMode = iota
+	// ImportNewerWins keeps whichever of the existing and the archived entry
+	// was crawled later, so overlapping archives can be merged in any order.
+	ImportNewerWins
+)
+
+// ImportStats counts what Import did with the entries of an archive.
+type ImportStats struct {
+	// Inserted entries were new to the cache.
+	Inserted int
+	// Updated entries replaced an existing one.
+	Updated int
+	// Skipped entries lost to a newer existing one (ImportNewerWins).
+	Skipped int
+}
+
+// Import reads an archive written by Export and stores its entries under
+// their archived keys, keeping their crawl and expiry times. It reads one
+// entry at a time and writes them in batches, so archives of any size can be
+// imported quickly. On error, the entries imported so far stay and the
+// returned stats count them.
+func (c *Cache) Import(r io.Reader, mode ImportMode) (ImportStats, error) {
+	var stats ImportStats
+	var pending []BatchEntry
+	pendingKeys := make(map[string]bool)
+	flush := func() error {
+		err := c.writeBatch(pending)
+		pending = pending[:0]
+		clear(pendingKeys)
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var rec archiveRecord
+		if err := dec.Decode(&rec); err != nil {
+			if flushErr := flush(); flushErr != nil {
+				return stats, flushErr
+			}
+			if errors.Is(err, io.EOF) {
+				return stats, nil
+			}
+			return stats, fmt.Errorf("httpcache: archive record %d: %w", line, err)
+		}
+		if rec.Key == "" {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+			return stats, fmt.Errorf("httpcache: archive record %d has no key", line)
+		}
+		// A key repeated within the batch must see its earlier record.
+		if pendingKeys[rec.Key] {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+
+		existing := c.storedEntry(rec.Key)
+		switch {
+		case existing == nil:
+			stats.Inserted++
+		case mode == ImportNewerWins && !rec.Entry.CrawledAt.After(existing.CrawledAt):
+			stats.Skipped++
+			continue
+		default:
+			stats.Updated++
+		}
+		entry := rec.Entry
+		entry.ContentHash, entry.Codec = "", 0
+		pending = append(pending, BatchEntry{Key: rec.Key, Entry: &entry})
+		pendingKeys[rec.Key] = true
+		if len(pending) >= writeBatchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+}
+
+// Export writes the cache to w. See Cache.Export.
+func (hc *HTTPClient) Export(w io.Writer) error {
+	return hc.cache.Export(w)
+}
+
+// ExportMetadata writes the metadata of every entry to w. See
+// Cache.ExportMetadata.
+func (hc *HTTPClient) ExportMetadata(w io.Writer) error {
+	return hc.cache.ExportMetadata(w)
+}
+
+// Import reads an archive written by Export into the cache. See
+// Cache.Import.
+func (hc *HTTPClient) Import(r io.Reader, mode ImportMode) (ImportStats, error) {
+	return hc.cache.Import(r, mode)
+}
@@ -0,0 +1,142 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	older, newer := newTestClient(t, WithDedup()), newTestClient(t)
+	older.cache.Set(older.key("http://example.com/shared"), []byte("old"), "http://example.com/shared", "", time.Minute)
+	older.cache.Set(older.key("http://example.com/a"), []byte("a"), "http://example.com/a", "", time.Minute)
+	time.Sleep(time.Millisecond)
+	newer.cache.Set(newer.key("http://example.com/shared"), []byte("new"), "http://example.com/shared", "", time.Minute)
+	newer.cache.Set(newer.key("http://example.com/b"), []byte("b"), "http://example.com/b", "", time.Minute)
+
+	var oldArchive, newArchive bytes.Buffer
+	if err := older.Export(&oldArchive); err != nil {
+		t.Fatal(err)
+	}
+	if err := newer.Export(&newArchive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Newest wins whichever archive comes first.
+	for _, order := range [][]*bytes.Buffer{{&oldArchive, &newArchive}, {&newArchive, &oldArchive}} {
+		merged := newTestClient(t)
+		var total ImportStats
+		for _, archive := range order {
+			stats, err := merged.Import(bytes.NewReader(archive.Bytes()), ImportNewerWins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total.Inserted += stats.Inserted
+			total.Updated += stats.Updated
+			total.Skipped += stats.Skipped
+		}
+		if total.Inserted != 3 || total.Updated+total.Skipped != 1 {
+			t.Errorf("stats = %+v, want 3 inserted and the overlap updated or skipped", total)
+		}
+		for url, want := range map[string]string{
+			"http://example.com/shared": "new",
+			"http://example.com/a":      "a",
+			"http://example.com/b":      "b",
+		} {
+			entry, found := merged.cache.GetEntry(merged.key(url))
+			if !found || string(entry.Data) != want {
+				t.Errorf("%s = %v, want %q", url, entry, want)
+			}
+		}
+	}
+
+	// Replace mode lets the last archive win, keeping crawl times.
+	replaced := newTestClient(t)
+	replaced.Import(bytes.NewReader(newArchive.Bytes()), ImportReplace)
+	stats, err := replaced.Import(bytes.NewReader(oldArchive.Bytes()), ImportReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (ImportStats{Inserted: 1, Updated: 1}) {
+		t.Errorf("replace stats = %+v", stats)
+	}
+	entry, _ := replaced.cache.GetEntry(replaced.key("http://example.com/shared"))
+	original, _ := older.cache.GetEntry(older.key("http://example.com/shared"))
+	if string(entry.Data) != "old" || !entry.CrawledAt.Equal(original.CrawledAt) {
+		t.Errorf("replaced entry = %q crawled %v, want %q crawled %v", entry.Data, entry.CrawledAt, "old", original.CrawledAt)
+	}
+
+	if _, err := replaced.Import(bytes.NewReader([]byte("{not json")), ImportReplace); err == nil {
+		t.Error("malformed archive imported")
+	}
+}
+
+func TestExportMetadata(t *testing.T) {
+	client := newTestClient(t, WithDedup())
+	bodies := map[string]string{
+		"http://example.com/a": "first body",
+		"http://example.com/b": "second body",
+		"http://example.com/c": "first body",
+	}
+	for url, body := range bodies {
+		client.cache.Set(client.key(url), []byte(body), url, url+"?final", time.Minute)
+	}
+
+	var out bytes.Buffer
+	if err := client.ExportMetadata(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(bodies) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(bodies), out.String())
+	}
+	for _, line := range lines {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		if _, ok := rec["data"]; ok {
+			t.Errorf("line %q includes the body", line)
+		}
+		url, _ := rec["url"].(string)
+		body, ok := bodies[url]
+		if !ok {
+			t.Fatalf("unexpected URL in %q", line)
+		}
+		if rec["final_url"] != url+"?final" || rec["status_code"] != float64(200) || rec["size"] != float64(len(body)) {
+			t.Errorf("line %q does not describe %s", line, url)
+		}
+		if rec["checksum"] != contentHash([]byte(body)) {
+			t.Errorf("%s: checksum = %v, want the body's SHA-256", url, rec["checksum"])
+		}
+		for _, field := range []string{"crawled_at", "expires_at"} {
+			if _, err := time.Parse(time.RFC3339Nano, rec[field].(string)); err != nil {
+				t.Errorf("%s: %s: %v", url, field, err)
+			}
+		}
+	}
+
+	// An entry whose deduplicated body is gone still gets its line.
+	if err := client.cache.Store.Delete(contentKey(contentHash([]byte("second body")))); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := client.ExportMetadata(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(bodies) {
+		t.Fatalf("with a lost body: got %d lines, want %d:\n%s", len(lines), len(bodies), out.String())
+	}
+	for _, line := range lines {
+		var rec map[string]any
+		json.Unmarshal([]byte(line), &rec)
+		_, hasSize := rec["size"]
+		_, hasErr := rec["error"]
+		if lost := rec["url"] == "http://example.com/b"; hasErr != lost || hasSize == lost {
+			t.Errorf("line %q: error=%v size=%v", line, hasErr, hasSize)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// Credentials authenticate requests to one host. A non-empty Token is sent
+// as a bearer token; otherwise Username and Password are sent with basic
+// authentication.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// setAuth sets the Authorization header of req from the credentials
+// configured for its host, if any. A host given with a port takes precedence
+// over the bare host name.
+func (hc *HTTPClient) setAuth(req *http.Request) {
+	creds, ok := hc.hostAuth[req.URL.Host]
+	if !ok {
+		creds, ok = hc.hostAuth[req.URL.Hostname()]
+	}
+	if !ok {
+		return
+	}
+	if creds.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+		return
+	}
+	req.SetBasicAuth(creds.Username, creds.Password)
+}
+
+// authKey returns the part of a cache key identifying the credentials of
+// req, without revealing them, or "" if it carries none.
+func authKey(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(auth))
+	return "\nAuthorization: " + hex.EncodeToString(hash[:])
+}
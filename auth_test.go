@@ -0,0 +1,81 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// authServer echoes the Authorization header of each request.
+func authServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHostAuth(t *testing.T) {
+	basic := authServer(t)
+	bearer := authServer(t)
+	other := authServer(t)
+	basicURL, _ := url.Parse(basic.URL)
+	bearerURL, _ := url.Parse(bearer.URL)
+
+	client := newTestClient(t, WithHostAuth(map[string]Credentials{
+		basicURL.Host:  {Username: "user", Password: "pass"},
+		bearerURL.Host: {Token: "tok"},
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("user", "pass")
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{basic.URL, req.Header.Get("Authorization")},
+		{bearer.URL, "Bearer tok"},
+		{other.URL, ""},
+	}
+	for _, tt := range tests {
+		r, err := client.Do(tt.url, &RequestOptions{Preference: NetworkOnly})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Data) != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.url, r.Data, tt.want)
+		}
+	}
+
+	r, err := client.Do(bearer.URL, &RequestOptions{
+		Preference: NetworkOnly,
+		Header:     http.Header{"Authorization": {"Bearer override"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "Bearer override" {
+		t.Errorf("per-call Authorization = %q, want it to override host credentials", r.Data)
+	}
+}
+
+func TestAuthInKey(t *testing.T) {
+	server := authServer(t)
+	host, _ := url.Parse(server.URL)
+	plain := newTestClient(t, WithHostAuth(map[string]Credentials{host.Host: {Token: "a"}}))
+	keyed := newTestClient(t, WithHostAuth(map[string]Credentials{host.Host: {Token: "a"}}), WithAuthInKey())
+	keyedB := newTestClient(t, WithHostAuth(map[string]Credentials{host.Host: {Token: "b"}}), WithAuthInKey())
+
+	if plain.key(server.URL) != HashKey(server.URL) {
+		t.Error("credentials changed the key without WithAuthInKey")
+	}
+	if keyed.key(server.URL) == plain.key(server.URL) {
+		t.Error("WithAuthInKey left the key unchanged")
+	}
+	if keyed.key(server.URL) == keyedB.key(server.URL) {
+		t.Error("different credentials share a key")
+	}
+}
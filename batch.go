@@ -0,0 +1,85 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrBatchTimeout is the error of the GetBatch results whose URL was still
+// being fetched when the context ended.
+var ErrBatchTimeout = errors.New("httpcache: batch deadline exceeded")
+
+// BatchResult is the outcome of one URL of a GetBatch call.
+type BatchResult struct {
+	URL    string
+	Result *Result
+	Err    error
+}
+
+// GetBatch gets urls, running up to concurrency network fetches at once (one
+// if concurrency is not positive), and returns a result per URL in order.
+// Fresh cache hits are served first, without waiting for a worker, so they
+// are always included. When ctx ends before every fetch has finished,
+// GetBatch returns at once: the fetches still running are cancelled and
+// their URLs, like those not yet started, carry ErrBatchTimeout.
+func (hc *HTTPClient) GetBatch(ctx context.Context, urls []string, concurrency int) []BatchResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]BatchResult, len(urls))
+	var pending []int
+	for i, url := range urls {
+		results[i].URL = url
+		r, err := hc.Do(url, &RequestOptions{Preference: CacheOnly})
+		if err == nil {
+			results[i].Result = r
+		} else {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return results
+	}
+
+	type done struct {
+		i   int
+		r   *Result
+		err error
+	}
+	// Buffered so workers never block on a GetBatch that has returned.
+	finished := make(chan done, len(pending))
+	next := make(chan int, len(pending))
+	for _, i := range pending {
+		next <- i
+	}
+	close(next)
+	for w := 0; w < concurrency && w < len(pending); w++ {
+		go func() {
+			for i := range next {
+				if ctx.Err() != nil {
+					return
+				}
+				r, err := hc.Do(urls[i], &RequestOptions{Context: ctx})
+				finished <- done{i, r, err}
+			}
+		}()
+	}
+
+	for remaining := len(pending); remaining > 0; remaining-- {
+		select {
+		case d := <-finished:
+			if d.err != nil && ctx.Err() != nil {
+				d.err = ErrBatchTimeout
+			}
+			results[d.i].Result, results[d.i].Err = d.r, d.err
+		case <-ctx.Done():
+			for _, i := range pending {
+				if results[i].Result == nil && results[i].Err == nil {
+					results[i].Err = ErrBatchTimeout
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
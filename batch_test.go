@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetBatchDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	defer close(release)
+	client := newTestClient(t)
+
+	if _, err := client.Get(server.URL + "/cached"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	urls := []string{server.URL + "/slow", server.URL + "/cached", server.URL + "/fast"}
+	start := time.Now()
+	results := client.GetBatch(ctx, urls, 2)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("GetBatch took %v, want it to return at the deadline", elapsed)
+	}
+
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(results), len(urls))
+	}
+	if r := results[0]; !errors.Is(r.Err, ErrBatchTimeout) || r.URL != urls[0] {
+		t.Errorf("slow result = %+v, want ErrBatchTimeout", r)
+	}
+	if r := results[1]; r.Err != nil || !r.Result.FromCache || string(r.Result.Data) != "/cached" {
+		t.Errorf("cached result = %+v, %v", r.Result, r.Err)
+	}
+	if r := results[2]; r.Err != nil || string(r.Result.Data) != "/fast" {
+		t.Errorf("fast result = %+v, %v", r.Result, r.Err)
+	}
+}
+
+func TestGetBatchExpiredContext(t *testing.T) {
+	client := newTestClient(t)
+	client.cache.Set(client.key("http://example.com/a"), []byte("a"), "http://example.com/a", "http://example.com/a", time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := client.GetBatch(ctx, []string{"http://example.com/a", "http://example.invalid/b"}, 1)
+	if results[0].Err != nil || string(results[0].Result.Data) != "a" {
+		t.Errorf("hit = %+v, %v; want it served despite the cancelled context", results[0].Result, results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrBatchTimeout) {
+		t.Errorf("miss err = %v, want ErrBatchTimeout", results[1].Err)
+	}
+}
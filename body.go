@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+// requestBody is a request body prepared for sending, with the hash that
+// goes into the cache key of the request.
+type requestBody struct {
+	reader  io.Reader
+	length  int64
+	getBody func() (io.ReadCloser, error)
+	hash    string
+}
+
+// prepareBody hashes r for the cache key without holding it in memory twice.
+// A reader that can seek is hashed as it is read through once, then rewound
+// and streamed as the request body. Any other reader is buffered once, being
+// hashed as the buffer fills.
+func prepareBody(r io.Reader) (*requestBody, error) {
+	h := sha256.New()
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(h, seeker)
+		if err != nil {
+			return nil, err
+		}
+		rewind := func() (io.ReadCloser, error) {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(seeker), nil
+		}
+		if _, err := rewind(); err != nil {
+			return nil, err
+		}
+		return &requestBody{
+			reader:  seeker,
+			length:  n,
+			getBody: rewind,
+			hash:    hex.EncodeToString(h.Sum(nil)),
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.TeeReader(r, h)); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	return &requestBody{
+		reader: bytes.NewReader(data),
+		length: int64(len(data)),
+		getBody: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+		hash: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// attach makes b the body of req.
+func (b *requestBody) attach(req *http.Request) {
+	if b.length == 0 {
+		req.Body = http.NoBody
+	} else {
+		req.Body = io.NopCloser(b.reader)
+	}
+	req.ContentLength = b.length
+	req.GetBody = b.getBody
+}
@@ -0,0 +1,80 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// GetWithVersion is like Get from the cache alone, also returning the
+// version of the stored entry for a later SetIfVersion. The version is a
+// hash of the entry as stored, so it changes with every write, including
+// one that stores the same body again. With no fresh entry, it returns
+// ErrCacheMiss along with the version of the expired entry, if there is
+// one, or 0 if there is none, so either can be replaced with SetIfVersion.
+func (hc *HTTPClient) GetWithVersion(url string) (data []byte, version uint64, err error) {
+	key := hc.key(url)
+	value, err := hc.cache.Store.Get(key)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return nil, 0, err
+	}
+	if err != nil || value == nil {
+		return nil, 0, ErrCacheMiss
+	}
+	version = entryVersion(value)
+	entry, err := hc.cache.decodeEntry(value)
+	if err != nil || hc.cache.isExpired(entry, time.Now()) {
+		return nil, version, ErrCacheMiss
+	}
+	return entry.Data, version, nil
+}
+
+// SetIfVersion stores data as the entry for url, with the TTL of its policy,
+// only if the stored entry still has the given version, as returned by
+// GetWithVersion; version 0 stores it only if there is no entry. It reports
+// whether data was stored. A false result with a nil error means another
+// writer got there first: read the entry again and retry. A URL no policy
+// gives a TTL is not cacheable, and SetIfVersion returns an error for it
+// rather than store an entry that is already expired, as it does when the
+// write fails.
+//
+// The check and the write are atomic with respect to other SetIfVersion
+// calls on clients sharing the Cache. Writes by Get, Do or Set are not
+// serialized with them, and a store shared by several processes offers no
+// atomicity across them, so a write landing between the check and the write
+// of a SetIfVersion can still be lost.
+func (hc *HTTPClient) SetIfVersion(url string, data []byte, version uint64) (bool, error) {
+	ttl := hc.cache.GetTTL(url)
+	if ttl <= 0 {
+		return false, fmt.Errorf("httpcache: no cache policy for %s", url)
+	}
+	key := hc.key(url)
+	hc.cache.casMu.Lock()
+	defer hc.cache.casMu.Unlock()
+	value, err := hc.cache.Store.Get(key)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return false, err
+	}
+	var current uint64
+	if err == nil && value != nil {
+		current = entryVersion(value)
+	}
+	if current != version {
+		return false, nil
+	}
+	if err := hc.cache.storeEntry(key, &CacheEntry{Data: data, URL: url, FinalURL: url}, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// entryVersion returns the version of a stored value. It is never 0, which
+// stands for no entry.
+func entryVersion(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	return max(h.Sum64(), 1)
+}
@@ -0,0 +1,80 @@
+package httpcache
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	client := newTestClient(t)
+	url := "http://example.com/counter"
+
+	if _, version, err := client.GetWithVersion(url); !errors.Is(err, ErrCacheMiss) || version != 0 {
+		t.Fatalf("empty cache: version %d, %v; want 0 and ErrCacheMiss", version, err)
+	}
+	if ok, err := client.SetIfVersion(url, []byte("1"), 0); !ok || err != nil {
+		t.Fatalf("creating the entry: %v, %v", ok, err)
+	}
+	if ok, _ := client.SetIfVersion(url, []byte("other"), 0); ok {
+		t.Error("version 0 should not overwrite an existing entry")
+	}
+
+	// Two writers read the same version; only the first to write wins.
+	dataA, versionA, err := client.GetWithVersion(url)
+	if err != nil || string(dataA) != "1" {
+		t.Fatalf("got %q, %v", dataA, err)
+	}
+	_, versionB, _ := client.GetWithVersion(url)
+	if versionA != versionB {
+		t.Fatalf("versions of the same entry differ: %d, %d", versionA, versionB)
+	}
+	if ok, err := client.SetIfVersion(url, []byte("2"), versionA); !ok || err != nil {
+		t.Fatalf("first writer: %v, %v", ok, err)
+	}
+	if ok, err := client.SetIfVersion(url, []byte("2 from B"), versionB); ok || err != nil {
+		t.Fatalf("second writer: %v, %v; want its stale version rejected", ok, err)
+	}
+
+	// The loser reads again and retries.
+	data, version, err := client.GetWithVersion(url)
+	if err != nil || string(data) != "2" || version == versionB {
+		t.Fatalf("after the update: %q, version %d, %v", data, version, err)
+	}
+	if ok, _ := client.SetIfVersion(url, []byte("3"), version); !ok {
+		t.Error("retry with the current version failed")
+	}
+	if data, _, _ := client.GetWithVersion(url); string(data) != "3" {
+		t.Errorf("final value %q, want 3", data)
+	}
+}
+
+// failingPutStore is a MemoryStore whose writes fail.
+type failingPutStore struct {
+	*MemoryStore
+}
+
+func (failingPutStore) Put(key string, value []byte) error {
+	return errors.New("disk full")
+}
+
+func TestSetIfVersionErrors(t *testing.T) {
+	client := newTestClient(t, WithStore(failingPutStore{NewMemoryStore()}))
+	if ok, err := client.SetIfVersion("http://example.com/", []byte("x"), 0); ok || err == nil {
+		t.Errorf("failed write: %v, %v; want false and the error", ok, err)
+	}
+
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(`^http://cached\.example\.com/`), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if ok, err := client.SetIfVersion("http://uncached.example.com/", []byte("x"), 0); ok || err == nil {
+		t.Errorf("URL without a TTL: %v, %v; want false and an error", ok, err)
+	}
+	if value, _ := client.cache.Store.Get(client.key("http://uncached.example.com/")); value != nil {
+		t.Error("an entry was stored for a URL without a TTL")
+	}
+}
@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Server clock freshness
+//
+// With Cache.ServerClock set, an entry's age is measured the way RFC 7234
+// section 4.2.3 measures it: from the Date header of the response it was
+// stored from, plus the Age the response already had, against the current
+// time on the origin's clock rather than the local one. The local clock's
+// offset from each origin's is learned per host from the Date header of the
+// last response fetched from it, so entries written by a host whose clock
+// was off, or before the local clock was corrected, expire when the origin
+// would expect them to, and one skewed origin does not shift the entries of
+// others. The offsets are kept in memory, one per host fetched from. Until a
+// response from its host has been seen, an entry's Date is taken to be on
+// the local clock. Entries without a Date header fall back to the local
+// CrawledAt time.
+
+// observeDate records the offset of the clock of host, the server that sent
+// header, from the local clock at received.
+func (c *Cache) observeDate(host string, header http.Header, received time.Time) {
+	if !c.ServerClock {
+		return
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return
+	}
+	c.clockOffsets.Store(host, date.Sub(received))
+}
+
+// clockOffset returns the last offset observed for the host entry was
+// fetched from, or 0 if there is none.
+func (c *Cache) clockOffset(entry *CacheEntry) time.Duration {
+	raw := entry.FinalURL
+	if raw == "" {
+		raw = entry.URL
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0
+	}
+	if offset, ok := c.clockOffsets.Load(u.Host); ok {
+		return offset.(time.Duration)
+	}
+	return 0
+}
+
+// serverExpiresAt returns when entry expires on the local clock, judging its
+// age from its Date and Age headers. ok is false when it has no Date header.
+func (c *Cache) serverExpiresAt(entry *CacheEntry, ttl time.Duration) (t time.Time, ok bool) {
+	date, err := http.ParseTime(entry.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var age time.Duration
+	if seconds, err := strconv.ParseInt(entry.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	return date.Add(ttl - age - c.clockOffset(entry)), true
+}
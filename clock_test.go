@@ -0,0 +1,113 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerClockSkew(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		crawledAt     time.Time
+		header        http.Header
+		localExpired  bool
+		serverExpired bool
+	}{
+		{
+			// Written by a host whose clock was an hour behind.
+			name:          "writer behind",
+			crawledAt:     now.Add(-time.Hour),
+			header:        http.Header{"Date": {now.UTC().Format(http.TimeFormat)}},
+			localExpired:  true,
+			serverExpired: false,
+		},
+		{
+			// Written by a host whose clock was an hour ahead.
+			name:          "writer ahead",
+			crawledAt:     now.Add(time.Hour),
+			header:        http.Header{"Date": {now.Add(-20 * time.Minute).UTC().Format(http.TimeFormat)}},
+			localExpired:  false,
+			serverExpired: true,
+		},
+		{
+			name:          "aged response",
+			crawledAt:     now,
+			header:        http.Header{"Date": {now.UTC().Format(http.TimeFormat)}, "Age": {"900"}},
+			localExpired:  false,
+			serverExpired: true,
+		},
+		{
+			name:          "no date",
+			crawledAt:     now.Add(-time.Hour),
+			localExpired:  true,
+			serverExpired: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &CacheEntry{URL: "http://example.com/", Header: tt.header, TTL: 10 * time.Minute, CrawledAt: tt.crawledAt}
+			local := &Cache{}
+			if got := local.isExpired(entry, now); got != tt.localExpired {
+				t.Errorf("local clock: expired = %v, want %v", got, tt.localExpired)
+			}
+			server := &Cache{ServerClock: true}
+			if got := server.isExpired(entry, now); got != tt.serverExpired {
+				t.Errorf("server clock: expired = %v, want %v", got, tt.serverExpired)
+			}
+		})
+	}
+}
+
+func TestServerClockLearnsOffset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server's clock runs an hour ahead of ours.
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithServerClock())
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	entry, expired := client.cache.lookup(client.key(server.URL))
+	if entry == nil || expired {
+		t.Fatalf("entry = %v, expired = %v", entry, expired)
+	}
+	if d := time.Until(client.cache.expiresAt(entry)) - time.Minute; d < -2*time.Second || d > 2*time.Second {
+		t.Errorf("expiry is %v off the policy TTL", d)
+	}
+}
+
+func TestServerClockOffsetPerHost(t *testing.T) {
+	skewed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		w.Write([]byte("skewed"))
+	}))
+	defer skewed.Close()
+	accurate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte("accurate"))
+	}))
+	defer accurate.Close()
+	client := newTestClient(t, WithServerClock())
+
+	// The skewed origin, fetched last, must not shift the other's entry.
+	for _, url := range []string{accurate.URL, skewed.URL} {
+		if _, err := client.Get(url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, url := range []string{accurate.URL, skewed.URL} {
+		entry, expired := client.cache.lookup(client.key(url))
+		if entry == nil || expired {
+			t.Fatalf("%s: entry = %v, expired = %v", url, entry, expired)
+		}
+		if d := time.Until(client.cache.expiresAt(entry)) - time.Minute; d < -2*time.Second || d > 2*time.Second {
+			t.Errorf("%s: expiry is %v off the policy TTL", url, d)
+		}
+	}
+}
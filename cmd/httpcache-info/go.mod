@@ -2,10 +2,24 @@ module httpcache-info
 
 go 1.23.4
 
-require github.com/liuzl/store v0.0.0-20190530065605-e2dbcd3c77fc
+require (
+	github.com/crawlerclub/httpcache v0.0.0-00010101000000-000000000000
+	github.com/liuzl/store v0.0.0-20190530065605-e2dbcd3c77fc
+	github.com/syndtr/goleveldb v1.0.0
+)
 
 require (
-	github.com/crawlerclub/x v0.1.0 // indirect
-	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
-	github.com/syndtr/goleveldb v1.0.0 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	github.com/microcosm-cc/bluemonday v1.0.27 // indirect
+	github.com/projectdiscovery/blackrock v0.0.1 // indirect
+	github.com/projectdiscovery/useragent v0.0.78 // indirect
+	github.com/projectdiscovery/utils v0.2.17 // indirect
+	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d // indirect
+	golang.org/x/net v0.29.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
 )
+
+replace github.com/crawlerclub/httpcache => ../..
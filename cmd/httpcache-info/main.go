@@ -3,45 +3,141 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/crawlerclub/httpcache"
 	"github.com/liuzl/store"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 var (
 	cacheDir = flag.String("cache_dir", ".httpcache", "Directory for HTTP cache storage")
 	url      = flag.String("url", "", "URL to check in cache")
 	outfile  = flag.String("outfile", "", "Output file to save the cache content")
+	top      = flag.Int("top", 0, "List the N largest cache entries")
+	warm     = flag.String("warm", "", "File of URLs, one per line, to fetch into the cache")
+	policies = flag.String("policies_file", "", "Cache policies to warm with, in format regex=duration (default: .*=10m)")
+	workers  = flag.Int("concurrency", 4, "Number of concurrent fetches when warming")
+	compare  = flag.String("compare", "", "Another cache directory to compare -cache_dir with")
+	coverage = flag.String("policy_coverage", "", "File of sample URLs, one per line, to count against each policy of -policies_file")
+	keyEnc   = flag.String("key_encoding", "hex", "Encoding of the cache's keys: hex or base64url")
+	foldHTTP = flag.Bool("fold_scheme", false, "Look https URLs up under their http entries, for caches written with WithSchemeInKey(false)")
 )
 
-type CacheEntry struct {
-	Data      []byte    `json:"data"`
-	URL       string    `json:"url"`
-	FinalURL  string    `json:"final_url"`
-	ExpiresAt time.Time `json:"expires_at"`
+// keyOptions returns the client options that make its keys match those of
+// the cache being inspected, as the flags describe it.
+func keyOptions() []httpcache.Option {
+	var opts []httpcache.Option
+	switch *keyEnc {
+	case "hex":
+	case "base64url":
+		opts = append(opts, httpcache.WithKeyEncoding(httpcache.Base64URL))
+	default:
+		log.Fatalf("Unknown key encoding %q", *keyEnc)
+	}
+	if *foldHTTP {
+		opts = append(opts, httpcache.WithSchemeInKey(false))
+	}
+	return opts
 }
 
-func hashKey(url string) string {
-	hash := sha256.Sum256([]byte(url))
-	return hex.EncodeToString(hash[:])
+// printCacheEntry describes entry, stored under key, to w: every field that
+// is set and the start of its body. stored is the entry as stored, which
+// tells how its body is kept.
+func printCacheEntry(w io.Writer, key string, stored, entry *httpcache.CacheEntry) {
+	fmt.Fprintf(w, "Cache Key: %s\n", key)
+	fmt.Fprintf(w, "Original URL: %s\n", entry.URL)
+	if entry.FinalURL != "" && entry.FinalURL != entry.URL {
+		fmt.Fprintf(w, "Final URL: %s\n", entry.FinalURL)
+	}
+	for i, u := range entry.RedirectChain {
+		fmt.Fprintf(w, "Redirect %d: %s\n", i+1, u)
+	}
+	if entry.StatusCode != 0 {
+		fmt.Fprintf(w, "Status: %d %s\n", entry.StatusCode, http.StatusText(entry.StatusCode))
+	}
+	if len(entry.Header) > 0 {
+		fmt.Fprintln(w, "Header:")
+		for _, name := range slices.Sorted(maps.Keys(entry.Header)) {
+			for _, value := range entry.Header[name] {
+				fmt.Fprintf(w, "  %s: %s\n", name, value)
+			}
+		}
+	}
+	if entry.Class != "" {
+		fmt.Fprintf(w, "Class: %s\n", entry.Class)
+	}
+	if entry.Charset != "" {
+		fmt.Fprintf(w, "Charset: %s\n", entry.Charset)
+	}
+	for _, name := range slices.Sorted(maps.Keys(entry.Meta)) {
+		fmt.Fprintf(w, "Meta %s: %s\n", name, entry.Meta[name])
+	}
+	if !entry.CrawledAt.IsZero() {
+		fmt.Fprintf(w, "Crawled At: %s\n", entry.CrawledAt.Format(time.RFC3339))
+	}
+	if !entry.RevalidatedAt.IsZero() {
+		fmt.Fprintf(w, "Revalidated At: %s\n", entry.RevalidatedAt.Format(time.RFC3339))
+	}
+	if entry.TTL > 0 {
+		fmt.Fprintf(w, "TTL: %s\n", entry.TTL)
+	}
+	fmt.Fprintf(w, "Expires At: %s\n", entry.ExpiresAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Time Until Expiration: %s\n", time.Until(entry.ExpiresAt).Round(time.Second))
+	if stored.Codec != 0 {
+		fmt.Fprintf(w, "Codec: %d (%d bytes stored)\n", stored.Codec, len(stored.Data))
+	}
+	if entry.Encoding != "" {
+		fmt.Fprintf(w, "Stored Encoding: %s\n", entry.Encoding)
+	}
+	if entry.ContentHash != "" {
+		fmt.Fprintf(w, "Deduplicated Content: %s\n", entry.ContentHash)
+	}
+	sum := sha256.Sum256(entry.Data)
+	fmt.Fprintf(w, "Checksum: sha256:%s\n", hex.EncodeToString(sum[:]))
+	fmt.Fprintf(w, "Data Size: %d bytes\n", len(entry.Data))
+	fmt.Fprintf(w, "First 200 bytes of data: %s\n", truncateString(string(entry.Data), 200000))
+	fmt.Fprintln(w, strings.Repeat("-", 80))
 }
 
-func printCacheEntry(key string, entry *CacheEntry) {
-	fmt.Printf("Cache Key: %s\n", key)
-	fmt.Printf("Original URL: %s\n", entry.URL)
-	if entry.FinalURL != "" && entry.FinalURL != entry.URL {
-		fmt.Printf("Final URL: %s\n", entry.FinalURL)
+// describeURL prints the entry client has cached for url to w, and returns
+// it, or nil if there is none. The entry is looked up under the key client
+// derives for url and decoded as the package reads it, in any serializer,
+// codec or deduplicated form it writes.
+func describeURL(w io.Writer, client *httpcache.HTTPClient, url string) (*httpcache.CacheEntry, error) {
+	key := client.Key(url)
+	value, err := client.GetStore().Get(key)
+	if errors.Is(err, leveldb.ErrNotFound) || (err == nil && value == nil) {
+		return nil, nil
 	}
-	fmt.Printf("Expires At: %s\n", entry.ExpiresAt.Format(time.RFC3339))
-	fmt.Printf("Time Until Expiration: %s\n", time.Until(entry.ExpiresAt).Round(time.Second))
-	fmt.Printf("Data Size: %d bytes\n", len(entry.Data))
-	fmt.Printf("First 200 bytes of data: %s\n", truncateString(string(entry.Data), 200000))
-	fmt.Println(strings.Repeat("-", 80))
+	if err != nil {
+		return nil, fmt.Errorf("reading from cache: %w", err)
+	}
+	serializer, err := httpcache.DetectSerializer(value)
+	if err != nil {
+		return nil, fmt.Errorf("detecting cache entry format: %w", err)
+	}
+	var stored httpcache.CacheEntry
+	if err := httpcache.Unmarshal(value, &stored); err != nil {
+		return nil, fmt.Errorf("decoding cache entry: %w", err)
+	}
+	entry, err := client.DecodeEntry(value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding cache entry: %w", err)
+	}
+	printCacheEntry(w, key, &stored, entry)
+	fmt.Fprintf(w, "Format: %s\n", serializer.Name())
+	return entry, nil
 }
 
 func truncateString(s string, n int) string {
@@ -51,42 +147,131 @@ func truncateString(s string, n int) string {
 	return s[:n] + "..."
 }
 
+func printLargestEntries(db *store.LevelStore, n int) {
+	cache := &httpcache.Cache{Store: db}
+	entries, err := cache.LargestEntries(n)
+	if err != nil {
+		log.Fatalf("Error scanning cache: %v", err)
+	}
+	for i, entry := range entries {
+		fmt.Printf("%d. %d bytes  %s\n", i+1, entry.Size, entry.URL)
+	}
+}
+
+func warmFromFile(path string) {
+	urls, err := httpcache.ReadURLFile(path)
+	if err != nil {
+		log.Fatalf("Error reading URL file: %v", err)
+	}
+	policies, err := httpcache.LoadPoliciesFromFile(*policies)
+	if err != nil {
+		log.Fatalf("Error loading cache policies: %v", err)
+	}
+	client, err := httpcache.NewClient(*cacheDir, policies)
+	if err != nil {
+		log.Fatalf("Failed to initialize cache: %v", err)
+	}
+	defer client.Close()
+
+	report := client.Warm(urls, *workers)
+	for i, err := range report.Errors {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", urls[i], err)
+		} else {
+			fmt.Printf("ok   %s\n", urls[i])
+		}
+	}
+	fmt.Printf("Warmed %d of %d URLs (%d fetched, %d already fresh, %d bytes) in %s\n",
+		len(urls)-report.Failed, len(urls), report.Fetched, report.Skipped, report.Bytes, report.Elapsed.Round(time.Millisecond))
+}
+
+func compareWith(other string) {
+	onlyA, onlyB, differ, err := httpcache.CompareCaches(*cacheDir, other)
+	if err != nil {
+		log.Fatalf("Error comparing caches: %v", err)
+	}
+	for _, u := range onlyA {
+		fmt.Printf("only in %s: %s\n", *cacheDir, u)
+	}
+	for _, u := range onlyB {
+		fmt.Printf("only in %s: %s\n", other, u)
+	}
+	for _, u := range differ {
+		fmt.Printf("differs: %s\n", u)
+	}
+	fmt.Printf("%d only in %s, %d only in %s, %d differ\n", len(onlyA), *cacheDir, len(onlyB), other, len(differ))
+}
+
+func policyCoverage(path string) {
+	urls, err := httpcache.ReadURLFile(path)
+	if err != nil {
+		log.Fatalf("Error reading URL file: %v", err)
+	}
+	policies, err := httpcache.LoadPoliciesFromFile(*policies)
+	if err != nil {
+		log.Fatalf("Error loading cache policies: %v", err)
+	}
+	cache := &httpcache.Cache{Policies: policies}
+	counts := cache.ValidatePoliciesAgainst(urls)
+	unused := 0
+	for _, policy := range policies {
+		n := counts[policy.Pattern.String()]
+		note := ""
+		if n == 0 {
+			note = "  (never matched)"
+			unused++
+		}
+		fmt.Printf("%6d  %s%s\n", n, policy.Pattern, note)
+	}
+	fmt.Printf("%d of %d policies matched none of %d URLs\n", unused, len(policies), len(urls))
+}
+
 func main() {
 	flag.Parse()
 
-	if *url == "" {
+	if *warm != "" {
+		warmFromFile(*warm)
+		return
+	}
+
+	if *compare != "" {
+		compareWith(*compare)
+		return
+	}
+
+	if *coverage != "" {
+		policyCoverage(*coverage)
+		return
+	}
+
+	if *url == "" && *top <= 0 {
 		fmt.Println("Please provide a URL to check with -url flag")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// Initialize store
-	db, err := store.NewLevelStore(*cacheDir + "/data")
+	// Open the cache with the keys it was written with
+	client, err := httpcache.NewClient(*cacheDir, nil, keyOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to initialize cache store: %v", err)
 	}
-	defer db.Close()
+	defer client.Close()
+
+	if *top > 0 {
+		printLargestEntries(client.GetStore(), *top)
+		return
+	}
 
 	// Check specific URL
-	key := hashKey(*url)
-	value, err := db.Get(key)
+	entry, err := describeURL(os.Stdout, client, *url)
 	if err != nil {
-		log.Fatalf("Error reading from cache: %v", err)
+		log.Fatalf("Error %v", err)
 	}
-
-	if value == nil {
+	if entry == nil {
 		fmt.Printf("No cache entry found for URL: %s\n", *url)
 		return
 	}
 
-	var entry CacheEntry
-
-	if err := store.BytesToObject(value, &entry); err != nil {
-		log.Fatalf("Error decoding cache entry: %v", err)
-	}
-
-	printCacheEntry(key, &entry)
-
 	// Save to file if outfile is specified
 	if *outfile != "" {
 		if err := os.WriteFile(*outfile, entry.Data, 0644); err != nil {
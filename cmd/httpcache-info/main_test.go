@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crawlerclub/httpcache"
+)
+
+func TestDescribeURL(t *testing.T) {
+	body := strings.Repeat("compressible body ", 50)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/page", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	policies := []httpcache.CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Hour}}
+	client, err := httpcache.NewClient(dir, policies,
+		httpcache.WithCodec(httpcache.GzipCodec{}), httpcache.WithDedup(), httpcache.WithSerializer(httpcache.JSONSerializer{}),
+		httpcache.WithRedirectChains(), httpcache.WithClassifier(func([]byte, string) string { return "article" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := server.URL + "/start"
+	if _, err := client.Do(url, &httpcache.RequestOptions{Meta: map[string]string{"job": "42"}}); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	client, err = httpcache.NewClient(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var out bytes.Buffer
+	entry, err := describeURL(&out, client, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || string(entry.Data) != body {
+		t.Fatalf("entry = %+v, want the decoded body", entry)
+	}
+	for _, want := range []string{
+		"Original URL: " + url,
+		"Final URL: " + server.URL + "/page",
+		"Redirect 1: " + url,
+		"Status: 200 OK",
+		"  Content-Type: text/html",
+		"Crawled At: ",
+		"Class: article",
+		"Meta job: 42",
+		"Codec: 1",
+		"Deduplicated Content: ",
+		"Data Size: " + strconv.Itoa(len(body)) + " bytes",
+		"First 200 bytes of data: compressible body",
+		"Format: json",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	if entry, err := describeURL(&out, client, server.URL+"/missing"); entry != nil || err != nil {
+		t.Errorf("missing URL: %v, %v; want no entry", entry, err)
+	}
+}
+
+func TestDescribeHTTPSURL(t *testing.T) {
+	dir := t.TempDir()
+	policies := []httpcache.CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Hour}}
+	opts := []httpcache.Option{httpcache.WithSchemeInKey(false), httpcache.WithKeyEncoding(httpcache.Base64URL)}
+	client, err := httpcache.NewClient(dir, policies, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SetIfVersion("https://example.com/page", []byte("secure body"), 0); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	*keyEnc, *foldHTTP = "base64url", true
+	defer func() { *keyEnc, *foldHTTP = "hex", false }()
+	client, err = httpcache.NewClient(dir, nil, keyOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, url := range []string{"https://example.com/page", "http://example.com/page"} {
+		entry, err := describeURL(io.Discard, client, url)
+		if err != nil || entry == nil || string(entry.Data) != "secure body" {
+			t.Errorf("%s: %v, %v; want the entry stored for the https URL", url, entry, err)
+		}
+	}
+}
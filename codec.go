@@ -0,0 +1,141 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Codec encodes cache entry bodies for storage, for example to compress them.
+// Each codec has a marker byte that is recorded in the entries it encodes, so
+// the matching decoder can be found when they are read back.
+type Codec interface {
+	// Marker identifies the codec. 0 and 1 are taken by NoneCodec and
+	// GzipCodec.
+	Marker() byte
+	Encode(data []byte) []byte
+	Decode(data []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{}
+)
+
+func init() {
+	RegisterCodec(NoneCodec{})
+	RegisterCodec(GzipCodec{})
+}
+
+// RegisterCodec makes a codec available for decoding entries carrying its
+// marker. Codecs set on a Cache should be registered too, so entries written
+// with them stay readable after the Cache switches to another codec.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Marker()] = c
+}
+
+func decodeContent(entry *CacheEntry) error {
+	if entry.Codec == 0 {
+		return nil
+	}
+	codecsMu.RLock()
+	c, ok := codecs[entry.Codec]
+	codecsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no codec registered for marker %d", entry.Codec)
+	}
+	data, err := c.Decode(entry.Data)
+	if err != nil {
+		return err
+	}
+	entry.Data = data
+	entry.Codec = 0
+	return nil
+}
+
+// NoneCodec stores bodies unchanged.
+type NoneCodec struct{}
+
+func (NoneCodec) Marker() byte                       { return 0 }
+func (NoneCodec) Encode(data []byte) []byte          { return data }
+func (NoneCodec) Decode(data []byte) ([]byte, error) { return data, nil }
+
+// GzipCodec compresses bodies with gzip.
+type GzipCodec struct{}
+
+func (GzipCodec) Marker() byte { return 1 }
+
+func (GzipCodec) Encode(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (GzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// precompressedTypes and precompressedExts are the media types and file
+// extensions IsPrecompressed recognizes. Any video type counts as well.
+var (
+	precompressedTypes = map[string]bool{
+		"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true, "image/avif": true,
+		"audio/mpeg": true, "audio/aac": true, "audio/mp4": true, "audio/ogg": true, "audio/webm": true,
+		"application/zip": true, "application/gzip": true, "application/x-gzip": true,
+		"application/x-bzip2": true, "application/x-xz": true, "application/zstd": true,
+		"application/x-7z-compressed": true, "application/vnd.rar": true, "application/x-rar-compressed": true,
+		"font/woff": true, "font/woff2": true,
+	}
+	precompressedExts = map[string]bool{
+		".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+		".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".mp4": true, ".webm": true, ".mkv": true, ".mov": true,
+		".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+		".woff": true, ".woff2": true,
+	}
+)
+
+// IsPrecompressed reports whether a body of the given Content-Type, or
+// failing that one fetched from a URL with the given file extension, is in
+// a common compressed media or archive format that another round of
+// compression would not shrink.
+func IsPrecompressed(contentType, rawURL string) bool {
+	if contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+			return precompressedTypes[mediaType] || strings.HasPrefix(mediaType, "video/")
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return precompressedExts[strings.ToLower(path.Ext(u.Path))]
+}
+
+// precompressed reports whether the body of entry, about to be stored, is
+// to skip Codec: when it is kept in its Content-Encoding, or is compressed
+// media.
+func (c *Cache) precompressed(entry *CacheEntry) bool {
+	if entry.Encoding != "" {
+		return true
+	}
+	is := c.Precompressed
+	if is == nil {
+		is = IsPrecompressed
+	}
+	return is(entry.Header.Get("Content-Type"), entry.URL)
+}
@@ -0,0 +1,107 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// xorCodec stands in for a third-party codec such as zstd.
+type xorCodec struct{}
+
+func (xorCodec) Marker() byte { return 200 }
+func (xorCodec) Encode(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ 0x5a
+	}
+	return out
+}
+func (c xorCodec) Decode(data []byte) ([]byte, error) { return c.Encode(data), nil }
+
+func TestCodecsCoexist(t *testing.T) {
+	client := newTestClient(t)
+	c := client.cache
+	RegisterCodec(xorCodec{})
+
+	body := []byte(strings.Repeat("compressible text ", 200))
+	writes := []struct {
+		url   string
+		codec Codec
+	}{
+		{"http://example.com/plain", nil},
+		{"http://example.com/gzip", GzipCodec{}},
+		{"http://example.com/xor", xorCodec{}},
+	}
+	for _, w := range writes {
+		c.Codec = w.codec
+		c.Set(hashKey(w.url), body, w.url, w.url, time.Minute)
+	}
+
+	gz := c.storedEntry(hashKey("http://example.com/gzip"))
+	if gz.Codec != (GzipCodec{}).Marker() || len(gz.Data) >= len(body) {
+		t.Errorf("gzip entry stored with codec %d and %d bytes, want compressed", gz.Codec, len(gz.Data))
+	}
+	if plain := c.storedEntry(hashKey("http://example.com/plain")); plain.Codec != 0 || !bytes.Equal(plain.Data, body) {
+		t.Error("plain entry should be stored unencoded")
+	}
+
+	// Whatever codec the cache writes with now, all entries stay readable.
+	for _, current := range []Codec{nil, GzipCodec{}, xorCodec{}} {
+		c.Codec = current
+		for _, w := range writes {
+			data, _, found := c.Get(hashKey(w.url))
+			if !found || !bytes.Equal(data, body) {
+				t.Errorf("reading %s with current codec %v: found=%v, %d bytes", w.url, current, found, len(data))
+			}
+		}
+	}
+}
+
+func TestCodecBodyReturnedUnencoded(t *testing.T) {
+	client := newTestClient(t, WithCodec(GzipCodec{}))
+
+	url := "http://example.com/page"
+	entry := &CacheEntry{Data: []byte("hello"), URL: url}
+	client.cache.SetEntry(hashKey(url), entry, time.Minute)
+	if string(entry.Data) != "hello" {
+		t.Errorf("SetEntry modified the caller's body: %q", entry.Data)
+	}
+}
+
+func TestCodecSkipsPrecompressed(t *testing.T) {
+	client := newTestClient(t, WithCodec(GzipCodec{}))
+	c := client.cache
+	body := []byte(strings.Repeat("compressible text ", 200))
+	for _, tc := range []struct {
+		url, contentType string
+		encoded          bool
+	}{
+		{"http://example.com/page", "text/html; charset=utf-8", true},
+		{"http://example.com/photo", "image/jpeg", false},
+		{"http://example.com/photo.JPG", "", false},
+		{"http://example.com/clip", "video/mp4", false},
+		{"http://example.com/data.json", "", true},
+	} {
+		header := http.Header{}
+		if tc.contentType != "" {
+			header.Set("Content-Type", tc.contentType)
+		}
+		c.SetEntry(hashKey(tc.url), &CacheEntry{Data: body, URL: tc.url, Header: header}, time.Minute)
+		stored := c.storedEntry(hashKey(tc.url))
+		if encoded := stored.Codec != 0; encoded != tc.encoded {
+			t.Errorf("%s (%s): stored with codec %d, want encoded %v", tc.url, tc.contentType, stored.Codec, tc.encoded)
+		}
+		if data, _, found := c.Get(hashKey(tc.url)); !found || !bytes.Equal(data, body) {
+			t.Errorf("%s: read back %d bytes, found=%v", tc.url, len(data), found)
+		}
+	}
+
+	c.Precompressed = func(string, string) bool { return false }
+	c.SetEntry(hashKey("http://example.com/photo"), &CacheEntry{Data: body, URL: "http://example.com/photo", Header: http.Header{"Content-Type": {"image/jpeg"}}}, time.Minute)
+	if stored := c.storedEntry(hashKey("http://example.com/photo")); stored.Codec == 0 {
+		t.Error("a Precompressed hook returning false should encode every body")
+	}
+}
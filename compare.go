@@ -0,0 +1,89 @@
+package httpcache
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/liuzl/store"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// CompareCaches compares the caches in the directories dirA and dirB, as
+// passed to NewClient, for example to check that a migration preserved
+// everything. It returns the URLs of entries present only in A, only in B,
+// and present in both with different bodies. Entries are matched by store
+// key, so both caches must use the same key scheme. The stores are opened
+// read-only and walked side by side in key order, holding one entry of each
+// in memory at a time.
+func CompareCaches(dirA, dirB string) (onlyA, onlyB, differ []string, err error) {
+	a, err := store.ReadOnlyStore(dirA + "/data")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer a.Close()
+	b, err := store.ReadOnlyStore(dirB + "/data")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer b.Close()
+
+	cacheA, cacheB := &Cache{Store: a}, &Cache{Store: b}
+	itA, itB := a.DB().NewIterator(nil, nil), b.DB().NewIterator(nil, nil)
+	defer itA.Release()
+	defer itB.Release()
+
+	okA, okB := nextEntry(itA), nextEntry(itB)
+	for okA || okB {
+		cmp := 0
+		switch {
+		case !okB:
+			cmp = -1
+		case !okA:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(itA.Key(), itB.Key())
+		}
+		switch {
+		case cmp < 0:
+			onlyA = append(onlyA, storedURL(itA))
+			okA = nextEntry(itA)
+		case cmp > 0:
+			onlyB = append(onlyB, storedURL(itB))
+			okB = nextEntry(itB)
+		default:
+			entryA, errA := cacheA.decodeEntry(itA.Value())
+			entryB, errB := cacheB.decodeEntry(itB.Value())
+			if errA != nil || errB != nil || !bytes.Equal(entryA.Data, entryB.Data) {
+				differ = append(differ, storedURL(itA))
+			}
+			okA, okB = nextEntry(itA), nextEntry(itB)
+		}
+	}
+	if err := itA.Error(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := itB.Error(); err != nil {
+		return nil, nil, nil, err
+	}
+	return onlyA, onlyB, differ, nil
+}
+
+// nextEntry advances it past reserved keys to the next cache entry.
+func nextEntry(it iterator.Iterator) bool {
+	for it.Next() {
+		if !strings.HasPrefix(string(it.Key()), reservedKeyPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// storedURL returns the URL of the entry at it, or its key if the entry
+// cannot be decoded.
+func storedURL(it iterator.Iterator) string {
+	var entry CacheEntry
+	if err := Unmarshal(it.Value(), &entry); err != nil || entry.URL == "" {
+		return string(it.Key())
+	}
+	return entry.URL
+}
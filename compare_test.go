@@ -0,0 +1,48 @@
+package httpcache
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCompareCaches(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	fill := func(dir string, bodies map[string]string) {
+		t.Helper()
+		client, err := NewClient(dir, policies, WithDedup())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		for url, body := range bodies {
+			client.cache.Set(client.key(url), []byte(body), url, url, time.Minute)
+		}
+	}
+	fill(dirA, map[string]string{
+		"http://example.com/same":   "same",
+		"http://example.com/a":      "a",
+		"http://example.com/differ": "old",
+	})
+	fill(dirB, map[string]string{
+		"http://example.com/same":   "same",
+		"http://example.com/b":      "b",
+		"http://example.com/differ": "new",
+	})
+
+	onlyA, onlyB, differ, err := CompareCaches(dirA, dirB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://example.com/a"}; !reflect.DeepEqual(onlyA, want) {
+		t.Errorf("onlyA = %v, want %v", onlyA, want)
+	}
+	if want := []string{"http://example.com/b"}; !reflect.DeepEqual(onlyB, want) {
+		t.Errorf("onlyB = %v, want %v", onlyB, want)
+	}
+	if want := []string{"http://example.com/differ"}; !reflect.DeepEqual(differ, want) {
+		t.Errorf("differ = %v, want %v", differ, want)
+	}
+}
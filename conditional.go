@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Conditional requests
+//
+// With WithConditionalRequests, a request that would refetch an expired
+// entry carrying an ETag or Last-Modified header sends it back as
+// If-None-Match or If-Modified-Since. A 304 Not Modified answer refreshes the
+// entry in place: the headers of the 304 are merged into the stored ones and
+// RevalidatedAt is set, restarting the entry's freshness, while CrawledAt
+// keeps the time the body was actually fetched. Any other answer is handled
+// as an ordinary fetch.
+
+// setConditional adds the validators of entry to req, unless the caller set
+// conditional headers of its own.
+func setConditional(req *http.Request, entry *CacheEntry) {
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified := entry.Header.Get("Last-Modified"); modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+}
+
+// revalidated stores entry, confirmed unchanged by notModified, the 304
+// answer to a conditional request, and returns it as served from the cache.
+func (hc *HTTPClient) revalidated(key, url string, req *http.Request, entry, notModified *CacheEntry, ttl time.Duration, opts *RequestOptions) *Result {
+	refreshed := *entry
+	refreshed.Header = entry.Header.Clone()
+	if refreshed.Header == nil {
+		refreshed.Header = make(http.Header)
+	}
+	for name, values := range notModified.Header {
+		if name != "Content-Length" {
+			refreshed.Header[name] = values
+		}
+	}
+	if opts.TTL <= 0 && refreshed.TTL > 0 {
+		ttl = hc.cache.clampTTL(refreshed.TTL)
+	}
+	now := time.Now()
+	refreshed.RevalidatedAt = now
+	refreshed.ExpiresAt = now.Add(ttl)
+	if !opts.NoStore {
+		refreshed.ContentHash, refreshed.Codec = "", 0
+		if err := hc.cache.writeEntry(key, &refreshed); err != nil {
+			hc.cache.logf("Failed to store cache entry: %v", err)
+		}
+		hc.cache.events.publish(CacheEvent{Kind: EventStore, Key: key, URL: url})
+	}
+	hc.hit(key, url, req, &refreshed)
+	r := refreshed.result(true)
+	if !opts.NoStore {
+		r.TTL = hc.cache.freshFor(&refreshed, now)
+	}
+	return r
+}
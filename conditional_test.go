@@ -0,0 +1,88 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConditionalRevalidation(t *testing.T) {
+	var full, notModified atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.Header().Set("X-Checked", "yes")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("body v1"))
+	}))
+	defer server.Close()
+
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: 50 * time.Millisecond}}
+	client, err := NewClient(t.TempDir(), policies, WithConditionalRequests())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := client.cache.GetEntry(client.key(server.URL))
+	if first == nil || !first.RevalidatedAt.IsZero() {
+		t.Fatalf("first entry = %+v, want one never revalidated", first)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	r, err := client.Do(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "body v1" || !r.FromCache || r.StatusCode != http.StatusOK {
+		t.Errorf("revalidated result = %d %q (cached %v)", r.StatusCode, r.Data, r.FromCache)
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Errorf("full fetches = %d, 304s = %d; want 1 and 1", full.Load(), notModified.Load())
+	}
+	if r.RevalidatedAt.IsZero() {
+		t.Error("Result.RevalidatedAt not set")
+	}
+
+	entry, expired := client.cache.lookup(client.key(server.URL))
+	if entry == nil || expired {
+		t.Fatalf("entry after the 304 = %+v, expired %v; want it fresh again", entry, expired)
+	}
+	if !entry.CrawledAt.Equal(first.CrawledAt) {
+		t.Errorf("CrawledAt = %v, want the original fetch time %v", entry.CrawledAt, first.CrawledAt)
+	}
+	if !entry.RevalidatedAt.After(entry.CrawledAt) {
+		t.Errorf("RevalidatedAt = %v, want after CrawledAt %v", entry.RevalidatedAt, entry.CrawledAt)
+	}
+	if entry.Header.Get("X-Checked") != "yes" || entry.Header.Get("ETag") != `"v1"` {
+		t.Errorf("headers after the 304 = %v", entry.Header)
+	}
+
+	summaries, _, err := client.ListEntries("", 0)
+	if err != nil || len(summaries) != 1 || !summaries[0].RevalidatedAt.Equal(entry.RevalidatedAt) {
+		t.Errorf("ListEntries = %+v, %v", summaries, err)
+	}
+}
+
+func TestRevalidatedAtLegacyEntry(t *testing.T) {
+	client := newTestClient(t)
+	url := "http://example.com/legacy"
+	// Entries stored before RevalidatedAt existed decode with it zero and
+	// expire from CrawledAt.
+	legacy := `{"data":"b2xk","url":"` + url + `","crawled_at":"2020-01-01T00:00:00Z","expires_at":"2020-01-01T00:01:00Z"}`
+	client.cache.Store.Put(client.key(url), append([]byte{JSONSerializer{}.Marker()}, legacy...))
+	entry, expired := client.cache.lookup(client.key(url))
+	if entry == nil || !entry.RevalidatedAt.IsZero() || !expired {
+		t.Errorf("legacy entry = %+v, expired %v", entry, expired)
+	}
+}
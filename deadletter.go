@@ -0,0 +1,150 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeadLetterEntry describes a URL whose fetches keep failing, as recorded by
+// WithDeadLetters.
+type DeadLetterEntry struct {
+	URL         string
+	Failures    int       // consecutive failed fetches
+	LastError   string    // error or status of the last failure
+	LastFailure time.Time // when the last failure happened
+	Until       time.Time // fetches are skipped until then; zero if not yet dead-lettered
+}
+
+// DeadLetterError is returned instead of fetching a URL that is on the
+// dead-letter list. Err is the failure that put it there.
+type DeadLetterError struct {
+	URL      string
+	Failures int
+	Until    time.Time
+	Err      error
+}
+
+func (e *DeadLetterError) Error() string {
+	return fmt.Sprintf("httpcache: %s skipped after %d failures until %s: %v",
+		e.URL, e.Failures, e.Until.Format(time.RFC3339), e.Err)
+}
+
+func (e *DeadLetterError) Unwrap() error { return e.Err }
+
+// deadLetterList counts consecutive failures per URL.
+type deadLetterList struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*deadLetter
+}
+
+type deadLetter struct {
+	DeadLetterEntry
+	err error
+}
+
+// check returns the error to fail a fetch of url with, or nil if it may be
+// fetched. A nil list lets every URL through.
+func (l *deadLetterList) check(url string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d, ok := l.entries[url]
+	if !ok || !time.Now().Before(d.Until) {
+		return nil
+	}
+	return &DeadLetterError{URL: url, Failures: d.Failures, Until: d.Until, Err: d.err}
+}
+
+// record notes the outcome of a fetch of url that ended with entry and err.
+// A success takes the URL off the list; a failure counts towards dead-lettering
+// it, and every failure at or past the threshold starts a new cooldown.
+func (l *deadLetterList) record(url string, entry *CacheEntry, err error) {
+	if l == nil {
+		return
+	}
+	failure, ok := deadLetterFailure(entry, err)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if failure == nil {
+		delete(l.entries, url)
+		return
+	}
+	d := l.entries[url]
+	if d == nil {
+		d = &deadLetter{DeadLetterEntry: DeadLetterEntry{URL: url}}
+		l.entries[url] = d
+	}
+	now := time.Now()
+	d.Failures++
+	d.err = failure
+	d.LastError = failure.Error()
+	d.LastFailure = now
+	if d.Failures >= l.threshold {
+		d.Until = now.Add(l.cooldown)
+	}
+}
+
+// deadLetterFailure returns what makes a fetch that ended with entry and err
+// a failure for the dead-letter list: a transport error, or a 404, 410 or
+// 5xx response. It is nil for successes. ok is false for cancelled and busy
+// fetches, which say nothing about the URL.
+func deadLetterFailure(entry *CacheEntry, err error) (failure error, ok bool) {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrTooBusy):
+		return nil, false
+	case err != nil:
+		return err, true
+	case entry.StatusCode == http.StatusNotFound, entry.StatusCode == http.StatusGone, entry.StatusCode >= 500:
+		return fmt.Errorf("status %d", entry.StatusCode), true
+	}
+	return nil, true
+}
+
+// DeadLetters returns the URLs whose last fetches failed, sorted by URL,
+// including those that have not yet failed often enough to be skipped. It is
+// empty unless WithDeadLetters is used.
+func (hc *HTTPClient) DeadLetters() []DeadLetterEntry {
+	l := hc.deadLetters
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	list := make([]DeadLetterEntry, 0, len(l.entries))
+	for _, d := range l.entries {
+		list = append(list, d.DeadLetterEntry)
+	}
+	l.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	return list
+}
+
+// ClearDeadLetters forgets the failures of urls, or of every URL if none are
+// given, so they are fetched again at once.
+func (hc *HTTPClient) ClearDeadLetters(urls ...string) {
+	l := hc.deadLetters
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(urls) == 0 {
+		clear(l.entries)
+		return
+	}
+	for _, url := range urls {
+		delete(l.entries, url)
+	}
+}
@@ -0,0 +1,56 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeadLetters(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithDeadLetters(2, time.Hour))
+	gone := server.URL + "/gone"
+	opts := &RequestOptions{Bypass: true}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Do(gone, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := client.Do(gone, opts)
+	var dead *DeadLetterError
+	if !errors.As(err, &dead) {
+		t.Fatalf("err = %v, want *DeadLetterError", err)
+	}
+	if hits.Load() != 2 || dead.Failures != 2 {
+		t.Errorf("%d fetches, %d failures; want the third skipped after 2", hits.Load(), dead.Failures)
+	}
+
+	if _, err := client.Get(server.URL + "/ok"); err != nil {
+		t.Fatal(err)
+	}
+	letters := client.DeadLetters()
+	if len(letters) != 1 || letters[0].URL != gone || letters[0].LastError != "status 404" || letters[0].Until.IsZero() {
+		t.Fatalf("DeadLetters() = %+v, want just %s", letters, gone)
+	}
+
+	client.ClearDeadLetters()
+	if _, err := client.Do(gone, opts); err != nil {
+		t.Fatalf("after ClearDeadLetters: %v", err)
+	}
+	if hits.Load() != 4 {
+		t.Errorf("%d fetches, want the cleared URL fetched again", hits.Load())
+	}
+}
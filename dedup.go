@@ -0,0 +1,141 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Content deduplication
+//
+// With Cache.Dedup enabled, SetEntry hashes each body and stores it once under
+// a content key, next to a reference count. The entry itself is written with
+// an empty Data and its ContentHash set; GetEntry resolves the reference
+// transparently, so callers never see the indirection.
+//
+// Every entry pointing at a blob holds one reference. Overwriting an entry
+// with different content releases the reference held by the previous value,
+// and Delete releases the entry's reference, removing the blob once nothing
+// points at it. Reference updates are serialized by Cache.refMu, so counts
+// are only consistent while a single process writes to the store. Entries
+// written with Dedup enabled keep resolving after it is turned off, but
+// overwriting them then no longer releases their blob.
+
+type contentBlob struct {
+	Data []byte
+	Refs int
+}
+
+func contentKey(hash string) string {
+	return reservedKeyPrefix + "blob/" + hash
+}
+
+func contentHash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// storedEntry returns the entry under key as stored, without resolving
+// deduplicated content, or nil if there is none.
+func (c *Cache) storedEntry(key string) *CacheEntry {
+	value, err := c.Store.Get(key)
+	if err != nil || value == nil {
+		return nil
+	}
+	var entry CacheEntry
+	if err := Unmarshal(value, &entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+func (c *Cache) setDeduped(key string, entry *CacheEntry) error {
+	c.refMu.Lock()
+	defer c.refMu.Unlock()
+
+	hash := contentHash(entry.Data)
+	old := c.storedEntry(key)
+	if old == nil || old.ContentHash != hash {
+		if err := c.retainContent(hash, entry.Data); err != nil {
+			return fmt.Errorf("storing deduplicated content: %w", err)
+		}
+		if old != nil && old.ContentHash != "" {
+			if err := c.releaseHash(old.ContentHash); err != nil {
+				c.logf("Failed to release deduplicated content: %v", err)
+			}
+		}
+	}
+
+	entry.ContentHash = hash
+	stored := *entry
+	stored.Data = nil
+	return c.putEntry(key, &stored)
+}
+
+func (c *Cache) loadBlob(hash string) (*contentBlob, error) {
+	value, err := c.Store.Get(contentKey(hash))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var blob contentBlob
+	if err := Unmarshal(value, &blob); err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+func (c *Cache) putBlob(hash string, blob *contentBlob) error {
+	encoded, err := c.marshal(blob)
+	if err != nil {
+		return err
+	}
+	return c.Store.Put(contentKey(hash), encoded)
+}
+
+func (c *Cache) retainContent(hash string, data []byte) error {
+	blob, _ := c.loadBlob(hash)
+	if blob == nil {
+		blob = &contentBlob{Data: data}
+	}
+	blob.Refs++
+	return c.putBlob(hash, blob)
+}
+
+func (c *Cache) releaseHash(hash string) error {
+	blob, _ := c.loadBlob(hash)
+	if blob == nil {
+		return nil
+	}
+	blob.Refs--
+	if blob.Refs <= 0 {
+		return c.Store.Delete(contentKey(hash))
+	}
+	return c.putBlob(hash, blob)
+}
+
+// releaseContent drops the content reference held by the entry under key, if
+// any. The caller must hold refMu.
+func (c *Cache) releaseContent(key string) error {
+	old := c.storedEntry(key)
+	if old == nil || old.ContentHash == "" {
+		return nil
+	}
+	return c.releaseHash(old.ContentHash)
+}
+
+// resolveContent loads the body of an entry that references deduplicated
+// content.
+func (c *Cache) resolveContent(entry *CacheEntry) error {
+	if entry.ContentHash == "" || entry.Data != nil {
+		return nil
+	}
+	blob, err := c.loadBlob(entry.ContentHash)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		return fmt.Errorf("deduplicated content %s is missing", entry.ContentHash)
+	}
+	entry.Data = blob.Data
+	return nil
+}
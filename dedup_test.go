@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func countContentBlobs(t *testing.T, c *Cache) int {
+	t.Helper()
+	n := 0
+	err := c.Store.ForEach(nil, func(key, value []byte) (bool, error) {
+		if strings.HasPrefix(string(key), reservedKeyPrefix+"blob/") {
+			n++
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDedupSharesContent(t *testing.T) {
+	client := newTestClient(t, WithDedup())
+	c := client.cache
+
+	body := []byte(strings.Repeat("boilerplate ", 100))
+	urls := []string{"http://mirror-a.com/page", "http://mirror-b.com/page"}
+	for _, url := range urls {
+		c.Set(hashKey(url), body, url, url, time.Minute)
+	}
+
+	if n := countContentBlobs(t, c); n != 1 {
+		t.Fatalf("content blobs = %d, want 1", n)
+	}
+	for _, url := range urls {
+		data, _, found := c.Get(hashKey(url))
+		if !found || string(data) != string(body) {
+			t.Errorf("Get(%s) = %q, %v", url, data, found)
+		}
+	}
+
+	if err := c.Delete(hashKey(urls[0])); err != nil {
+		t.Fatal(err)
+	}
+	if n := countContentBlobs(t, c); n != 1 {
+		t.Fatalf("content blobs after first delete = %d, want 1", n)
+	}
+	if data, _, found := c.Get(hashKey(urls[1])); !found || string(data) != string(body) {
+		t.Errorf("remaining entry lost its content")
+	}
+
+	if err := c.Delete(hashKey(urls[1])); err != nil {
+		t.Fatal(err)
+	}
+	if n := countContentBlobs(t, c); n != 0 {
+		t.Errorf("content blobs after last delete = %d, want 0", n)
+	}
+}
+
+func TestDedupOverwriteReleasesOldContent(t *testing.T) {
+	client := newTestClient(t, WithDedup())
+	c := client.cache
+
+	url := "http://example.com/changing"
+	c.Set(hashKey(url), []byte("first"), url, url, time.Minute)
+	c.Set(hashKey(url), []byte("second"), url, url, time.Minute)
+
+	if n := countContentBlobs(t, c); n != 1 {
+		t.Errorf("content blobs = %d, want 1", n)
+	}
+	if data, _, _ := c.Get(hashKey(url)); string(data) != "second" {
+		t.Errorf("Get() = %q, want %q", data, "second")
+	}
+}
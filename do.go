@@ -0,0 +1,523 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"time"
+)
+
+// ErrCacheMiss is returned by CacheOnly requests when there is no usable
+// cached entry.
+var ErrCacheMiss = errors.New("httpcache: cache miss")
+
+// ErrBodyTooLarge is returned for responses whose body exceeds the size
+// limit set with WithMaxBodyBytes or RequestOptions.MaxBytes. Nothing is
+// cached for them.
+var ErrBodyTooLarge = errors.New("httpcache: response body too large")
+
+// ErrClosed is returned by requests made once the client's Shutdown or
+// Close has begun.
+var ErrClosed = errors.New("httpcache: client is shut down")
+
+// InvalidURLError is returned when a request cannot be built for a URL,
+// typically because it is malformed, as opposed to failing on the network.
+type InvalidURLError struct {
+	URL string
+	Err error
+}
+
+func (e *InvalidURLError) Error() string {
+	return fmt.Sprintf("httpcache: invalid URL %q: %v", e.URL, e.Err)
+}
+
+func (e *InvalidURLError) Unwrap() error { return e.Err }
+
+// DecompressionLimitError is returned when a response body the transport
+// decompressed grows past the limit set by WithMaxDecompressedBytes, as a
+// compression bomb would. The fetch is aborted at the limit.
+type DecompressionLimitError struct {
+	URL   string
+	Limit int64
+}
+
+func (e *DecompressionLimitError) Error() string {
+	return fmt.Sprintf("httpcache: decompressed body of %s exceeds %d bytes", e.URL, e.Limit)
+}
+
+// ShortBodyError is returned when a response body ends before the length its
+// Content-Length header announced, as when a download is cut off. The short
+// body is never cached.
+type ShortBodyError struct {
+	URL  string
+	Got  int64
+	Want int64
+}
+
+func (e *ShortBodyError) Error() string {
+	return fmt.Sprintf("httpcache: short body for %s: got %d of %d bytes", e.URL, e.Got, e.Want)
+}
+
+// Preference selects how a single request uses the cache.
+//
+//	Preference    reads cache          fetches                 writes cache
+//	CacheFirst    fresh entries        on a miss               yes
+//	NetworkFirst  only as a fallback   always                  yes
+//	CacheOnly     fresh entries        never (ErrCacheMiss)    no
+//	NetworkOnly   never                always                  yes
+//
+// NetworkFirst falls back to the cached entry, even an expired one, when the
+// fetch fails; so does CacheFirst with WithStaleOnError. Entries are only
+// read and written for requests with a cacheable method (GET, HEAD and
+// OPTIONS unless WithCacheableMethods adds more) to URLs with a positive
+// TTL, and RequestOptions.Bypass and NoStore turn off reading and writing on
+// top of the preference.
+type Preference int
+
+const (
+	// CacheFirst serves a fresh cached entry and fetches on a miss. It is
+	// the behavior of Get.
+	CacheFirst Preference = iota
+	// NetworkFirst always fetches and serves the cached entry only if the
+	// fetch fails.
+	NetworkFirst
+	// CacheOnly never touches the network.
+	CacheOnly
+	// NetworkOnly never reads the cache but still stores what it fetches.
+	NetworkOnly
+)
+
+// RequestOptions controls a single call to Do. The zero value behaves like
+// Get.
+//
+// Whether a call reads and writes the cache combines Preference, NoStore and
+// Bypass:
+//
+//   - by default it reads the cache and stores what it fetches;
+//   - with NetworkOnly it fetches without reading, and stores the result,
+//     as a forced refresh;
+//   - with NoStore it reads the cache, and on a miss fetches without
+//     storing, as a probe that must not pollute the cache;
+//   - with Bypass it neither reads nor stores.
+type RequestOptions struct {
+	Preference Preference
+	// Method is the HTTP method, GET by default. GET, HEAD and OPTIONS
+	// requests are cached; other methods are only cached when enabled with
+	// WithCacheableMethods, and are then keyed by method and body as well as
+	// URL.
+	Method string
+	// Body is the request body. It is hashed into the cache key before the
+	// request is sent: a body that implements io.Seeker is read twice and
+	// streamed, any other is buffered in memory once.
+	Body io.Reader
+	// Header is added to the request, overriding default and policy headers.
+	Header http.Header
+	// Validator, if set, must accept a body for it to be served from or
+	// written to the cache. It is shorthand for setting both ReadValidator
+	// and WriteValidator.
+	Validator ContentValidator
+	// ReadValidator and WriteValidator check the two directions separately.
+	// With neither set every body is served and cached. With only
+	// ReadValidator set, every fetched body is cached but only bodies it
+	// accepts are served from the cache; rejected entries are deleted and
+	// fetched again. With only WriteValidator set, cached bodies are served
+	// as they are and only fetched bodies it accepts are cached. With both
+	// set a body must pass each check in turn. Either one applies together
+	// with Validator, never instead of it.
+	ReadValidator  ContentValidator
+	WriteValidator ContentValidator
+	// URLValidator is like Validator but also sees the final URL the body
+	// was served from, for example to reject redirects to a login page.
+	URLValidator URLValidator
+	// AcceptStatus, if set, must accept a response's status code for it to
+	// be served from or written to the cache. Entries stored before status
+	// codes were recorded are treated as 200 OK.
+	AcceptStatus func(statusCode int) bool
+	// TTL, if positive, replaces the policy TTL, and any WithTTLFunc TTL,
+	// for the entry written by this call, and enables caching for URLs no
+	// policy covers.
+	TTL time.Duration
+	// Referer, if set, is sent as the Referer header, overriding Header and
+	// WithAutoReferer. Like other headers it is not part of the cache key.
+	Referer string
+	// Host, if set, is sent as the Host header in place of the URL's host,
+	// for example to reach a virtual host on an origin addressed by IP.
+	// The TLS server name and certificate verification of https requests
+	// still use the URL's host. Unlike other headers it is part of the cache
+	// key, as different hosts serve different content at the same address.
+	Host string
+	// MaxBytes, if positive, replaces the WithMaxBodyBytes limit for this
+	// call; if negative, the body may be of any size. Like that limit it
+	// applies to network fetches only: a cached body is served whatever its
+	// size.
+	MaxBytes int64
+	// CacheMaxBytes, if positive, caps the bytes of the body stored in the
+	// cache: a longer body is returned whole by this call but stored cut to
+	// its first CacheMaxBytes bytes, so that later calls served from the
+	// cache get only that prefix, for crawls needing no more than the start
+	// of large pages.
+	CacheMaxBytes int64
+	// Bypass skips the cache entirely: nothing is read or written.
+	Bypass bool
+	// NoStore keeps the fetched body out of the cache. Reads still happen as
+	// directed by Preference.
+	NoStore bool
+	// Context governs the network fetch. context.Background() is used when
+	// it is nil.
+	Context context.Context
+	// Meta is stored with the entry this call fetches, and returned with it
+	// in FetchInfo.Meta and EntrySummary.Meta whenever it is read. A call
+	// answered from the cache returns the metadata stored before instead.
+	Meta map[string]string
+
+	// redirectHops counts the redirects followed by WithRedirectPrefetch to
+	// reach the URL.
+	redirectHops int
+}
+
+// Result is the outcome of Do.
+type Result struct {
+	Data []byte
+	FetchInfo
+}
+
+// Do fetches url as directed by opts, which may be nil. The returned Result
+// is never nil; on error it carries whatever is known about the response,
+// such as the final URL. Once Shutdown has begun, Do returns ErrClosed.
+func (hc *HTTPClient) Do(url string, opts *RequestOptions) (*Result, error) {
+	if !hc.admit() {
+		return &Result{}, ErrClosed
+	}
+	defer hc.requestWG.Done()
+	return hc.run(url, opts)
+}
+
+// admit counts a request in for Shutdown to wait for, and reports false
+// without counting it if Shutdown has begun.
+func (hc *HTTPClient) admit() bool {
+	hc.closeMu.RLock()
+	defer hc.closeMu.RUnlock()
+	if hc.closing {
+		return false
+	}
+	hc.requestWG.Add(1)
+	return true
+}
+
+// run is Do without admission, for background work, which Shutdown waits
+// for separately and which may still be finishing once it has begun.
+func (hc *HTTPClient) run(url string, opts *RequestOptions) (*Result, error) {
+	r, err := hc.do(url, opts)
+	hc.countBytes(r)
+	if err == nil && hc.autoReferer {
+		hc.lastURL.Store(r.FinalURL)
+	}
+	return r, err
+}
+
+// SetEnabled turns the cache on or off for every request made from now on.
+// While it is off, requests behave as if RequestOptions.Bypass were set:
+// nothing is read from or written to the cache, and GetWithAliases and
+// GetWithFallbacks copy no entries to aliases. It is safe to call while
+// requests are in progress, which finish as they started.
+func (hc *HTTPClient) SetEnabled(enabled bool) {
+	hc.disabled.Store(!enabled)
+}
+
+// Enabled reports whether the cache is on. It is on unless SetEnabled turned
+// it off.
+func (hc *HTTPClient) Enabled() bool {
+	return !hc.disabled.Load()
+}
+
+func (hc *HTTPClient) do(url string, opts *RequestOptions) (*Result, error) {
+	if opts == nil {
+		opts = &RequestOptions{}
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := hc.newRequest(ctx, method, url, opts.Header, nil)
+	if err != nil {
+		return &Result{}, err
+	}
+	var bodyHash string
+	if opts.Body != nil {
+		body, err := prepareBody(opts.Body)
+		if err != nil {
+			return &Result{}, err
+		}
+		body.attach(req)
+		bodyHash = body.hash
+	}
+	hc.setReferer(req, opts.Referer)
+	if opts.Host != "" {
+		req.Host = opts.Host
+	}
+
+	input := hc.keyInput(url, req, bodyHash)
+	key := hc.inputKey(input, req)
+	ttl := hc.cache.GetTTL(url)
+	if opts.TTL > 0 {
+		ttl = hc.cache.clampTTL(opts.TTL)
+	}
+	mode := hc.Mode()
+	if mode == Replay {
+		return hc.replay(key, url, req, opts)
+	}
+	cacheable := ttl > 0 && hc.cacheableMethod(method) && !opts.Bypass && hc.Enabled()
+	readCache := cacheable && opts.Preference != NetworkOnly && (hc.bypassFunc == nil || !hc.bypassFunc(url))
+	writeCache := cacheable && !opts.NoStore
+	if mode == Record {
+		readCache, writeCache = false, hc.cacheableMethod(method)
+	}
+
+	var cached *CacheEntry
+	var expired bool
+	// An expired entry kept for WithStaleOnError is only served if the
+	// fetch fails, and deleted once it succeeds.
+	var keptStale bool
+	// An expired entry kept for WithConditionalRequests is refreshed in
+	// place if the server answers the fetch with 304 Not Modified.
+	var revalidating *CacheEntry
+	if readCache {
+		if opts.Preference == NetworkFirst {
+			cached, expired = hc.cache.lookup(key)
+		} else if opts.Preference == CacheFirst && hc.refreshOnExpiry {
+			cached, expired = hc.staleOrFresh(url, key, req, opts)
+		} else if opts.Preference == CacheFirst && hc.revalidationWindow > 0 {
+			cached, expired = hc.cache.lookup(key)
+			if cached != nil && expired && !hc.inRevalidationWindow(key) {
+				return hc.revalidate(url, key, req, opts, cached)
+			}
+		} else if opts.Preference == CacheFirst && hc.staleOnError {
+			cached, expired = hc.cache.lookup(key)
+			keptStale = expired
+		} else if hc.conditional {
+			cached, expired = hc.cache.lookup(key)
+			if expired {
+				revalidating, cached = cached, nil
+			}
+		} else {
+			cached, _ = hc.cache.GetEntry(key)
+		}
+		if cached != nil && !opts.validRead(cached) {
+			// invalid cache, delete it
+			_ = hc.cache.Delete(key)
+			cached = nil
+		}
+		if cached != nil && opts.AcceptStatus != nil && !opts.AcceptStatus(cached.status()) {
+			cached = nil
+		}
+		if cached != nil && hc.schemaValidator != nil {
+			if err := hc.schemaValidator(cached.Data); err != nil {
+				hc.cache.logf("Cached %s no longer matches its schema, refetching: %v", hc.keyLabel(input), err)
+				cached = nil
+			}
+		}
+		if cached == nil || keptStale {
+			hc.cache.events.publish(CacheEvent{Kind: EventMiss, Key: key, URL: url})
+		}
+		if cached != nil && opts.Preference != NetworkFirst && !keptStale {
+			hc.hit(key, url, req, cached)
+			if expired {
+				return hc.staleResult(cached, StaleRefreshing), nil
+			}
+			r := cached.result(true)
+			r.TTL = hc.cache.freshFor(cached, time.Now())
+			return r, nil
+		}
+	}
+
+	if opts.Preference == CacheOnly && mode != Record {
+		return &Result{}, ErrCacheMiss
+	}
+
+	maxBytes := hc.maxBodyBytes
+	if opts.MaxBytes != 0 {
+		maxBytes = opts.MaxBytes
+	}
+	if revalidating == nil && hc.conditional && cached != nil && (expired || opts.Preference == NetworkFirst) {
+		revalidating = cached
+	}
+	if revalidating != nil {
+		setConditional(req, revalidating)
+	}
+	entry, err := hc.fetch(url, req, maxBytes)
+	if err == nil && revalidating != nil && entry.StatusCode == http.StatusNotModified {
+		return hc.revalidated(key, url, req, revalidating, entry, ttl, opts), nil
+	}
+	if err != nil {
+		hc.cache.events.publish(CacheEvent{Kind: EventFetchError, Key: key, URL: url, Err: err})
+		if cached != nil {
+			hc.hit(key, url, req, cached)
+		}
+		if cached != nil && expired {
+			return hc.staleResult(cached, StaleOnError), nil
+		}
+		if cached != nil {
+			r := cached.result(true)
+			r.TTL = hc.cache.freshFor(cached, time.Now())
+			return r, nil
+		}
+		r := entry.result(false)
+		r.Data = nil
+		return r, err
+	}
+
+	if hc.classifier != nil {
+		entry.Class = hc.classifier(entry.Data, entry.FinalURL)
+	}
+	if opts.Meta != nil {
+		entry.Meta = maps.Clone(opts.Meta)
+	}
+
+	shouldCache := opts.validWrite(entry) && hc.cache.Policy(url).allowsBody(entry.Data)
+	if opts.AcceptStatus != nil && !opts.AcceptStatus(entry.status()) {
+		shouldCache = false
+	}
+	if method != http.MethodHead && hc.cacheableStatus != nil && mode != Record && !hc.cacheableStatus(entry.status(), entry.Data) {
+		shouldCache = false
+	}
+	if hc.maxCacheableDuration > 0 && entry.elapsed > hc.maxCacheableDuration {
+		shouldCache = false
+	}
+	if hc.suspectBody(url, method, entry, nil) {
+		shouldCache = false
+	}
+	if hc.bodyTransform != nil {
+		entry.Data = hc.bodyTransform(url, entry.Data)
+		// The body as received no longer matches Data, so store Data.
+		entry.Encoding, entry.raw = "", nil
+	}
+
+	r := entry.result(false)
+	if shouldCache && writeCache {
+		if opts.TTL > 0 {
+			entry.TTL = opts.TTL
+		} else if hc.ttlFunc != nil {
+			if computed := hc.ttlFunc(url, len(entry.Data), entry.Header); computed > 0 {
+				entry.TTL = computed
+				ttl = hc.cache.clampTTL(computed)
+			}
+		}
+		stored := entry
+		if max := opts.CacheMaxBytes; max > 0 && int64(len(entry.Data)) > max {
+			stored = entry.truncated(max)
+		}
+		hc.cache.SetEntry(key, stored, ttl)
+		r.TTL = hc.cache.freshFor(stored, stored.CrawledAt)
+		hc.cache.events.publish(CacheEvent{Kind: EventStore, Key: key, URL: url})
+		if hc.contentLocation {
+			hc.storeContentLocation(req, stored)
+		}
+	} else if ((keptStale && cached != nil) || (revalidating != nil && expired)) && !hc.cache.NoLazyDelete {
+		_ = hc.cache.Delete(key)
+	}
+	hc.prefetchRedirect(url, entry, opts)
+
+	return r, nil
+}
+
+// truncated returns a copy of e with its body cut to max bytes, stored
+// decoded.
+func (e *CacheEntry) truncated(max int64) *CacheEntry {
+	cut := *e
+	cut.Data = e.Data[:max]
+	cut.Encoding, cut.raw = "", nil
+	return &cut
+}
+
+// validRead reports whether a cached entry passes the validators in opts
+// and may be served.
+func (opts *RequestOptions) validRead(entry *CacheEntry) bool {
+	return opts.valid(entry, opts.ReadValidator)
+}
+
+// validWrite reports whether a fetched entry passes the validators in opts
+// and may be cached.
+func (opts *RequestOptions) validWrite(entry *CacheEntry) bool {
+	return opts.valid(entry, opts.WriteValidator)
+}
+
+// valid reports whether entry passes Validator, URLValidator and the
+// direction-specific validator v.
+func (opts *RequestOptions) valid(entry *CacheEntry, v ContentValidator) bool {
+	if opts.Validator != nil && !opts.Validator(entry.Data) {
+		return false
+	}
+	if v != nil && !v(entry.Data) {
+		return false
+	}
+	return opts.URLValidator == nil || opts.URLValidator(entry.Data, entry.FinalURL)
+}
+
+// DoWithMethod sends a method request for url with body, which may be nil,
+// and caches the response if the method is cacheable. See
+// RequestOptions.Method.
+func (hc *HTTPClient) DoWithMethod(method, url string, body io.Reader) (*Result, error) {
+	return hc.Do(url, &RequestOptions{Method: method, Body: body})
+}
+
+// defaultCacheableMethods are the methods cached unless
+// WithCacheableMethods adds others.
+var defaultCacheableMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+func (hc *HTTPClient) cacheableMethod(method string) bool {
+	return defaultCacheableMethods[method] || hc.cacheableMethods[method]
+}
+
+// CacheableStatusFunc decides from a fetched response's status code and body
+// whether the response may be cached at all.
+type CacheableStatusFunc func(statusCode int, body []byte) bool
+
+// DefaultCacheableStatus is the CacheableStatusFunc clients use unless
+// WithCacheableStatus replaces it. It refuses responses that carry nothing
+// worth caching:
+//
+//	204 No Content          never cached
+//	3xx with an empty body  not cached, e.g. redirects not followed
+//	3xx with a body         cached
+//	any other status        cached
+//
+// Responses to HEAD requests, which never have a body, are exempt.
+func DefaultCacheableStatus(statusCode int, body []byte) bool {
+	if statusCode == http.StatusNoContent {
+		return false
+	}
+	if statusCode >= 300 && statusCode < 400 && len(body) == 0 {
+		return false
+	}
+	return true
+}
+
+// GetCacheableStatus is like Get but only serves or caches responses whose
+// status code acceptable approves. A cached entry with an unacceptable status
+// counts as a miss even within its TTL, which also filters entries written
+// before the caller started checking status codes.
+func (hc *HTTPClient) GetCacheableStatus(url string, acceptable func(int) bool) ([]byte, error) {
+	r, err := hc.Do(url, &RequestOptions{AcceptStatus: acceptable})
+	return r.Data, err
+}
+
+// status returns the entry's status code, assuming 200 OK for entries stored
+// before status codes were recorded.
+func (e *CacheEntry) status() int {
+	if e.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return e.StatusCode
+}
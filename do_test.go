@@ -0,0 +1,1220 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer answers every request with "response N", N counting up
+// from 1.
+func countingServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "response %d", hits.Add(1))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestDoCacheFirst(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	for i := 0; i < 2; i++ {
+		r, err := client.Do(server.URL, &RequestOptions{Preference: CacheFirst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Data) != "response 1" || r.FromCache != (i == 1) {
+			t.Errorf("call %d: data=%q fromCache=%v", i+1, r.Data, r.FromCache)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestDoNetworkFirst(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+	opts := &RequestOptions{Preference: NetworkFirst}
+
+	for i := 1; i <= 2; i++ {
+		r, err := client.Do(server.URL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("response %d", i); string(r.Data) != want || r.FromCache {
+			t.Errorf("call %d: data=%q fromCache=%v, want %q from network", i, r.Data, r.FromCache, want)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+
+	url := server.URL
+	server.Close()
+	r, err := client.Do(url, opts)
+	if err != nil {
+		t.Fatalf("expected fallback to cache, got %v", err)
+	}
+	if string(r.Data) != "response 2" || !r.FromCache {
+		t.Errorf("fallback: data=%q fromCache=%v", r.Data, r.FromCache)
+	}
+	if r.Stale {
+		t.Error("fallback to a fresh entry should not be marked stale")
+	}
+}
+
+func TestDoNetworkFirstMarksStale(t *testing.T) {
+	server, _ := countingServer(t)
+	client := newTestClient(t)
+	opts := &RequestOptions{Preference: NetworkFirst, TTL: 20 * time.Millisecond}
+
+	if _, err := client.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	url := server.URL
+	server.Close()
+
+	r, err := client.Do(url, opts)
+	if err != nil {
+		t.Fatalf("expected fallback to cache, got %v", err)
+	}
+	if string(r.Data) != "response 1" || !r.Stale || r.StaleReason != StaleOnError {
+		t.Errorf("data=%q stale=%v reason=%q, want stale response 1 on error", r.Data, r.Stale, r.StaleReason)
+	}
+	if len(r.Header.Values("Warning")) != 0 {
+		t.Errorf("Warning headers without WithStaleWarning: %v", r.Header.Values("Warning"))
+	}
+}
+
+func TestStaleWarningHeader(t *testing.T) {
+	server, _ := countingServer(t)
+	client := newTestClient(t, WithStaleWarning())
+	opts := &RequestOptions{Preference: NetworkFirst, TTL: 20 * time.Millisecond}
+
+	if _, err := client.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	url := server.URL
+	server.Close()
+
+	r, err := client.Do(url, opts)
+	if err != nil {
+		t.Fatalf("expected fallback to cache, got %v", err)
+	}
+	warnings := r.Header.Values("Warning")
+	if len(warnings) != 2 || !strings.HasPrefix(warnings[0], "110 ") || !strings.HasPrefix(warnings[1], "111 ") {
+		t.Errorf("Warning = %q, want 110 and 111", warnings)
+	}
+	if r.Header.Get("Content-Type") == "" {
+		t.Error("the stored headers should be kept")
+	}
+}
+
+func TestDoCacheOnly(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+	opts := &RequestOptions{Preference: CacheOnly}
+
+	if _, err := client.Do(server.URL, opts); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("error = %v, want ErrCacheMiss", err)
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("CacheOnly hit the network")
+	}
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.Do(server.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 1" || !r.FromCache {
+		t.Errorf("data=%q fromCache=%v", r.Data, r.FromCache)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestDoNetworkOnly(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.Do(server.URL, &RequestOptions{Preference: NetworkOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 2" || r.FromCache {
+		t.Errorf("data=%q fromCache=%v, want fresh network response", r.Data, r.FromCache)
+	}
+
+	// The network result was written back for later readers.
+	cached, err := client.Do(server.URL, &RequestOptions{Preference: CacheOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cached.Data) != "response 2" {
+		t.Errorf("cached data = %q, want %q", cached.Data, "response 2")
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestDoOptions(t *testing.T) {
+	var lastHeader http.Header
+	var lastMethod string
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastHeader = r.Header.Clone()
+		lastMethod = r.Method
+		fmt.Fprintf(w, "response %d", hits.Add(1))
+	}))
+	defer server.Close()
+
+	// No policy matches, so only a per-call TTL enables caching.
+	client, err := NewClient(t.TempDir(), nil, WithHeader(http.Header{"X-Source": {"default"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	t.Run("header overrides default", func(t *testing.T) {
+		_, err := client.Do(server.URL+"/header", &RequestOptions{Header: http.Header{"X-Source": {"call"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lastHeader.Get("X-Source"); got != "call" {
+			t.Errorf("X-Source = %q, want %q", got, "call")
+		}
+	})
+
+	t.Run("TTL override enables caching", func(t *testing.T) {
+		url := server.URL + "/ttl"
+		opts := &RequestOptions{TTL: time.Minute}
+		first, err := client.Do(url, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := client.Do(url, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !second.FromCache || string(second.Data) != string(first.Data) {
+			t.Errorf("second call should be a cache hit, got %q fromCache=%v", second.Data, second.FromCache)
+		}
+		// The entry keeps its own TTL even though no policy covers it.
+		if _, found := client.cache.GetEntry(hashKey(url)); !found {
+			t.Error("entry written with a per-call TTL is not fresh")
+		}
+	})
+
+	t.Run("NoStore and Bypass", func(t *testing.T) {
+		url := server.URL + "/nostore"
+		if _, err := client.Do(url, &RequestOptions{TTL: time.Minute, NoStore: true}); err != nil {
+			t.Fatal(err)
+		}
+		if _, found := client.cache.GetEntry(hashKey(url)); found {
+			t.Error("NoStore call wrote to the cache")
+		}
+
+		url = server.URL + "/bypass"
+		if _, err := client.Do(url, &RequestOptions{TTL: time.Minute}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := client.Do(url, &RequestOptions{TTL: time.Minute, Bypass: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.FromCache {
+			t.Error("Bypass call was served from cache")
+		}
+	})
+
+	t.Run("non-GET is not cached", func(t *testing.T) {
+		url := server.URL + "/head"
+		r, err := client.Do(url, &RequestOptions{Method: http.MethodHead, TTL: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastMethod != http.MethodHead || r.StatusCode != http.StatusOK {
+			t.Errorf("method = %s, status = %d", lastMethod, r.StatusCode)
+		}
+		if _, found := client.cache.GetEntry(hashKey(url)); found {
+			t.Error("HEAD response was cached")
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := client.Do(server.URL+"/ctx", &RequestOptions{Context: ctx}); !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+func TestFetchUsesCache(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	first, err := client.Fetch(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.Fetch(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("hits = %d, want 1 (second Fetch should be a cache hit)", hits.Load())
+	}
+	if string(first) != string(second) {
+		t.Errorf("cached body %q differs from fetched body %q", second, first)
+	}
+
+	// A body the validator rejects is neither cached nor served from cache.
+	reject := func([]byte) bool { return false }
+	if _, err := client.Fetch(server.URL+"/rejected", reject); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Fetch(server.URL+"/rejected", reject); err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("hits = %d, want 3", hits.Load())
+	}
+}
+
+func TestGetCacheableStatusSkipsStoredError(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	// An entry cached by an older run that did not filter on status.
+	client.cache.SetEntry(hashKey(server.URL), &CacheEntry{
+		Data:       []byte("internal error"),
+		URL:        server.URL,
+		FinalURL:   server.URL,
+		StatusCode: http.StatusInternalServerError,
+	}, time.Minute)
+
+	if data, _ := client.Get(server.URL); string(data) != "internal error" {
+		t.Fatalf("Get should still serve the stored entry, got %q", data)
+	}
+
+	only2xx := func(code int) bool { return code >= 200 && code < 300 }
+	data, err := client.GetCacheableStatus(server.URL, only2xx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "response 1" || hits.Load() != 1 {
+		t.Errorf("got %q after %d hits, want a refetch", data, hits.Load())
+	}
+
+	// The good response replaced the bad one.
+	if _, err := client.GetCacheableStatus(server.URL, only2xx); err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestAcceptHeader(t *testing.T) {
+	var accepts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept")
+		accepts = append(accepts, accept)
+		if accept == "application/json" {
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		w.Write([]byte("<p>ok</p>"))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithAccept("application/json"), WithAcceptInKey())
+
+	data, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepts) != 1 || accepts[0] != "application/json" {
+		t.Fatalf("Accept headers sent = %v", accepts)
+	}
+	if string(data) != `{"ok":true}` {
+		t.Errorf("got %q", data)
+	}
+
+	html := &RequestOptions{Header: http.Header{"Accept": {"text/html"}}}
+	r, err := client.Do(server.URL, html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FromCache || string(r.Data) != "<p>ok</p>" {
+		t.Errorf("HTML variant: %q fromCache=%v, want a separate fetch", r.Data, r.FromCache)
+	}
+
+	// Both variants are now cached independently.
+	for _, opts := range []*RequestOptions{nil, html} {
+		r, err := client.Do(server.URL, opts)
+		if err != nil || !r.FromCache {
+			t.Errorf("variant not cached: %v fromCache=%v", err, r.FromCache)
+		}
+	}
+	if len(accepts) != 2 {
+		t.Errorf("fetches = %d, want 2", len(accepts))
+	}
+}
+
+func TestDoWithMethod(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %d", r.Method, body, hits.Add(1))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		opts   []Option
+		cached bool
+	}{
+		{http.MethodGet, nil, true},
+		{http.MethodHead, nil, true},
+		{http.MethodOptions, nil, true},
+		{http.MethodPost, nil, false},
+		{http.MethodPut, nil, false},
+		{http.MethodPost, []Option{WithCacheableMethods("post")}, true},
+		{http.MethodPut, []Option{WithCacheableMethods(http.MethodPut)}, true},
+	}
+	for _, tt := range tests {
+		client := newTestClient(t, tt.opts...)
+		hits.Store(0)
+		for i := 0; i < 2; i++ {
+			r, err := client.DoWithMethod(tt.method, server.URL, strings.NewReader("a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.FromCache != (tt.cached && i == 1) {
+				t.Errorf("%s call %d (options %d): fromCache = %v", tt.method, i+1, len(tt.opts), r.FromCache)
+			}
+		}
+		if want := map[bool]int64{true: 1, false: 2}[tt.cached]; hits.Load() != want {
+			t.Errorf("%s (options %d): hits = %d, want %d", tt.method, len(tt.opts), hits.Load(), want)
+		}
+	}
+}
+
+func TestMethodAwareKeys(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %d", r.Method, body, hits.Add(1))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithCacheableMethods(http.MethodPost))
+
+	calls := []struct{ method, body string }{
+		{http.MethodGet, ""},
+		{http.MethodOptions, ""},
+		{http.MethodPost, "a"},
+		{http.MethodPost, "b"},
+	}
+	want := map[string]string{}
+	for round := 0; round < 2; round++ {
+		for _, c := range calls {
+			r, err := client.DoWithMethod(c.method, server.URL, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := c.method + " " + c.body
+			if round == 0 {
+				want[id] = string(r.Data)
+			} else if string(r.Data) != want[id] {
+				t.Errorf("%s: got %q, want %q", id, r.Data, want[id])
+			}
+		}
+	}
+	if hits.Load() != int64(len(calls)) {
+		t.Errorf("hits = %d, want %d", hits.Load(), len(calls))
+	}
+	if _, found := client.cache.GetEntry(hashKey(server.URL)); !found {
+		t.Error("GET entry should keep the plain URL key")
+	}
+}
+
+func TestStreamingRequestBody(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d bytes, length %d, hit %d", len(body), r.ContentLength, hits.Add(1))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithCacheableMethods(http.MethodPost))
+
+	// streamed writes size bytes of fill through a pipe, which cannot seek.
+	streamed := func(fill byte, size int) io.Reader {
+		pr, pw := io.Pipe()
+		go func() {
+			chunk := bytes.Repeat([]byte{fill}, 1024)
+			for n := 0; n < size; n += len(chunk) {
+				pw.Write(chunk[:min(len(chunk), size-n)])
+			}
+			pw.Close()
+		}()
+		return pr
+	}
+	const size = 100 << 10
+	want := fmt.Sprintf("%d bytes, length %d, hit 1", size, size)
+
+	for i := 0; i < 2; i++ {
+		r, err := client.DoWithMethod(http.MethodPost, server.URL, streamed('a', size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Data) != want || r.FromCache != (i == 1) {
+			t.Errorf("pipe call %d: data=%q fromCache=%v", i+1, r.Data, r.FromCache)
+		}
+	}
+
+	// A file seeks, so it is hashed and sent without being buffered; its
+	// content matches the streamed body, and so does its key.
+	path := filepath.Join(t.TempDir(), "body")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{'a'}, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := client.DoWithMethod(http.MethodPost, server.URL, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != want || !r.FromCache {
+		t.Errorf("file: data=%q fromCache=%v, want the cached response", r.Data, r.FromCache)
+	}
+
+	r, err = client.Do(server.URL, &RequestOptions{Method: http.MethodPost, Body: f, Preference: NetworkOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%d bytes, length %d, hit 2", size, size); string(r.Data) != want {
+		t.Errorf("file sent: %q, want %q", r.Data, want)
+	}
+
+	r, err = client.DoWithMethod(http.MethodPost, server.URL, streamed('b', size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FromCache {
+		t.Error("a different body should miss the cache")
+	}
+}
+
+func TestInvalidURLError(t *testing.T) {
+	client := newTestClient(t)
+	const bad = "http://[::1]:namedport/"
+
+	check := func(name string, err error) {
+		t.Helper()
+		var invalid *InvalidURLError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s: err = %v (%T), want *InvalidURLError", name, err, err)
+			return
+		}
+		if invalid.URL != bad {
+			t.Errorf("%s: URL = %q", name, invalid.URL)
+		}
+	}
+	_, err := client.Get(bad)
+	check("Get", err)
+	_, err = client.Fetch(bad, nil)
+	check("Fetch", err)
+	_, _, err = client.FetchWithFinalURL(bad)
+	check("FetchWithFinalURL", err)
+	_, err = client.DoWithMethod(http.MethodPost, bad, nil)
+	check("DoWithMethod", err)
+}
+
+func TestReadWriteValidators(t *testing.T) {
+	reject := func([]byte) bool { return false }
+	tests := []struct {
+		name      string
+		opts      RequestOptions
+		seed      bool // start with an entry the validators reject
+		wantData  string
+		wantCache bool // whether the fetched or seeded body is cached after the call
+	}{
+		{"neither", RequestOptions{}, false, "response 1", true},
+		{"read only stores", RequestOptions{ReadValidator: reject}, false, "response 1", true},
+		{"read only refetches", RequestOptions{ReadValidator: reject}, true, "response 1", true},
+		{"write only skips store", RequestOptions{WriteValidator: reject}, false, "response 1", false},
+		{"write only serves cached", RequestOptions{WriteValidator: reject}, true, "seeded", true},
+		{"both", RequestOptions{ReadValidator: func([]byte) bool { return true }, WriteValidator: reject}, false, "response 1", false},
+		{"validator sets both", RequestOptions{Validator: reject}, true, "response 1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := countingServer(t)
+			client := newTestClient(t)
+			key := client.key(server.URL)
+			if tt.seed {
+				client.cache.Set(key, []byte("seeded"), server.URL, server.URL, time.Minute)
+			}
+
+			r, err := client.Do(server.URL, &tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(r.Data) != tt.wantData {
+				t.Errorf("data = %q, want %q", r.Data, tt.wantData)
+			}
+			entry, _ := client.cache.GetEntry(key)
+			if (entry != nil) != tt.wantCache {
+				t.Errorf("cached = %v, want %v", entry != nil, tt.wantCache)
+			}
+			if entry != nil && string(entry.Data) != tt.wantData {
+				t.Errorf("cached data = %q, want %q", entry.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	get := func() string {
+		t.Helper()
+		data, _, err := client.GetWithFinalURL(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := get(); got != "response 1" {
+		t.Fatalf("first get = %q", got)
+	}
+	client.SetEnabled(false)
+	if client.Enabled() {
+		t.Error("Enabled() = true after SetEnabled(false)")
+	}
+	if got := get(); got != "response 2" {
+		t.Errorf("disabled get = %q, want a fetch", got)
+	}
+	if got := get(); got != "response 3" {
+		t.Errorf("second disabled get = %q, want a fetch", got)
+	}
+	client.SetEnabled(true)
+	if got := get(); got != "response 1" {
+		t.Errorf("re-enabled get = %q, want the entry cached before disabling", got)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("server hits = %d, want 3", hits.Load())
+	}
+}
+
+func TestSetEnabledAliases(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	server, _ := countingServer(t)
+	client := newTestClient(t, WithFallbackAlias())
+
+	// Stored while enabled, so only the disabled check keeps it from being
+	// copied.
+	if _, err := client.Get(server.URL + "/page"); err != nil {
+		t.Fatal(err)
+	}
+	client.SetEnabled(false)
+	if _, _, err := client.GetWithAliases(server.URL+"/page", []string{server.URL + "/alias"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.GetWithFallbacks([]string{primary.URL, server.URL + "/mirror"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	client.SetEnabled(true)
+	for _, url := range []string{server.URL + "/alias", server.URL + "/mirror", primary.URL} {
+		if _, found := client.cache.GetEntry(client.key(url)); found {
+			t.Errorf("%s was written while the cache was disabled", url)
+		}
+	}
+}
+
+func TestSchemaValidator(t *testing.T) {
+	server, hits := countingServer(t)
+	errOldFormat := errors.New("old format")
+	client := newTestClient(t, WithSchemaValidator(func(body []byte) error {
+		if !bytes.HasPrefix(body, []byte("response")) {
+			return errOldFormat
+		}
+		return nil
+	}))
+	key := client.key(server.URL)
+	client.cache.Set(key, []byte(`{"v":1}`), server.URL, server.URL, time.Minute)
+
+	r, err := client.Do(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 1" || r.FromCache {
+		t.Errorf("got %q (from cache %v), want a refetch", r.Data, r.FromCache)
+	}
+	if r, _ := client.Do(server.URL, nil); !r.FromCache || string(r.Data) != "response 1" {
+		t.Errorf("refetched body not served from cache: %q", r.Data)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			w.(http.Flusher).Flush() // no Content-Length
+		}
+		w.Write(bytes.Repeat([]byte("x"), 100))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithMaxBodyBytes(50))
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		wantErr  bool
+	}{
+		{"global", 0, true},
+		{"larger override", 200, false},
+		{"smaller override", 10, true},
+		{"unlimited", -1, false},
+		{"exact", 100, false},
+	}
+	for _, tt := range tests {
+		for _, query := range []string{"", "&chunked=1"} {
+			url := server.URL + "/?case=" + strings.ReplaceAll(tt.name, " ", "-") + query
+			r, err := client.Do(url, &RequestOptions{MaxBytes: tt.maxBytes})
+			if tt.wantErr {
+				if !errors.Is(err, ErrBodyTooLarge) {
+					t.Errorf("%s%s: err = %v, want ErrBodyTooLarge", tt.name, query, err)
+				}
+				if _, cached := client.cache.GetEntry(client.key(url)); cached {
+					t.Errorf("%s%s: oversized body cached", tt.name, query)
+				}
+				continue
+			}
+			if err != nil || len(r.Data) != 100 {
+				t.Errorf("%s%s: got %d bytes, %v", tt.name, query, len(r.Data), err)
+			}
+			if _, cached := client.cache.GetEntry(client.key(url)); !cached {
+				t.Errorf("%s%s: body not cached", tt.name, query)
+			}
+		}
+	}
+}
+
+func TestMaxDecompressedBytes(t *testing.T) {
+	// 8 MiB of zeros compress to a few KiB.
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, 8<<20))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb.Bytes())
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithMaxDecompressedBytes(1<<20))
+	_, err := client.Get(server.URL)
+	var limitErr *DecompressionLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != 1<<20 {
+		t.Fatalf("err = %v, want *DecompressionLimitError at 1 MiB", err)
+	}
+	if _, cached := client.cache.GetEntry(client.key(server.URL)); cached {
+		t.Error("bomb cached")
+	}
+
+	// The limit applies to decompressed bytes only, so a bigger one lets the
+	// body through while the compressed size stays small.
+	client = newTestClient(t, WithMaxDecompressedBytes(16<<20))
+	data, err := client.Get(server.URL)
+	if err != nil || len(data) != 8<<20 {
+		t.Errorf("got %d bytes, %v; want the whole 8 MiB", len(data), err)
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	server, _ := countingServer(t)
+	var calls atomic.Int64
+	client := newTestClient(t, WithClassifier(func(body []byte, finalURL string) string {
+		calls.Add(1)
+		if strings.HasPrefix(finalURL, server.URL) && bytes.HasPrefix(body, []byte("response")) {
+			return "article"
+		}
+		return "other"
+	}))
+
+	for i := 0; i < 2; i++ {
+		r, err := client.Do(server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Class != "article" {
+			t.Errorf("request %d: Class = %q, want article", i, r.Class)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("classifier ran %d times, want once", calls.Load())
+	}
+	entries, _, err := client.ListEntries("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Class != "article" {
+		t.Errorf("ListEntries = %+v, want one article", entries)
+	}
+}
+
+func TestBodyTransform(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>hello</p><script>track()</script>"))
+	}))
+	defer server.Close()
+	var seen []byte
+	client := newTestClient(t, WithBodyTransform(func(url string, body []byte) []byte {
+		return []byte(strings.ReplaceAll(string(body), "<script>track()</script>", ""))
+	}))
+
+	r, err := client.Do(server.URL, &RequestOptions{Validator: func(body []byte) bool {
+		seen = body
+		return true
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "<p>hello</p>" {
+		t.Errorf("fetched body = %q, want the script stripped", r.Data)
+	}
+	if !bytes.Contains(seen, []byte("<script>")) {
+		t.Errorf("validator saw %q, want the body as served", seen)
+	}
+	r, err = client.Do(server.URL, &RequestOptions{Preference: CacheOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "<p>hello</p>" {
+		t.Errorf("cached body = %q, want the script stripped", r.Data)
+	}
+}
+
+func TestMaxCacheableDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprintf(w, "body of %s", r.URL.Path)
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithMaxCacheableDuration(50*time.Millisecond))
+
+	for _, path := range []string{"/slow", "/fast"} {
+		data, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "body of "+path {
+			t.Errorf("%s: got %q", path, data)
+		}
+	}
+	if _, found := client.cache.GetEntry(client.key(server.URL + "/slow")); found {
+		t.Error("slow response was cached")
+	}
+	if _, found := client.cache.GetEntry(client.key(server.URL + "/fast")); !found {
+		t.Error("fast response was not cached")
+	}
+}
+
+func TestEntryMeta(t *testing.T) {
+	server, _ := countingServer(t)
+	for _, serializer := range []Serializer{GobSerializer{}, JSONSerializer{}} {
+		client := newTestClient(t, WithSerializer(serializer))
+		meta := map[string]string{"job": "crawl-42", "source": "sitemap"}
+		if _, err := client.Do(server.URL+"/meta", &RequestOptions{Meta: meta}); err != nil {
+			t.Fatal(err)
+		}
+		meta["job"] = "changed after the call"
+
+		r, err := client.Do(server.URL+"/meta", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.FromCache || r.Meta["job"] != "crawl-42" || r.Meta["source"] != "sitemap" {
+			t.Errorf("%T: fromCache=%v Meta=%v, want the stored metadata", serializer, r.FromCache, r.Meta)
+		}
+		if _, err := client.Do(server.URL+"/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+		entries, _, err := client.ListEntries("", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.HasSuffix(e.URL, "/plain") && e.Meta != nil {
+				t.Errorf("%T: entry without metadata has Meta %v", serializer, e.Meta)
+			}
+			if strings.HasSuffix(e.URL, "/meta") && e.Meta["job"] != "crawl-42" {
+				t.Errorf("%T: ListEntries Meta = %v", serializer, e.Meta)
+			}
+		}
+	}
+}
+
+func TestDefaultCacheableStatus(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/moved":
+			w.Header().Set("Location", "/elsewhere")
+			w.WriteHeader(http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/empty", "/moved"} {
+		hits.Store(0)
+		client := newTestClient(t, WithFollowRedirects(false))
+		for i := 0; i < 2; i++ {
+			if _, err := client.Do(server.URL+path, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if hits.Load() != 2 {
+			t.Errorf("%s: server hits = %d, want 2 with nothing cached", path, hits.Load())
+		}
+
+		hits.Store(0)
+		client = newTestClient(t, WithFollowRedirects(false), WithCacheableStatus(nil))
+		for i := 0; i < 2; i++ {
+			if _, err := client.Do(server.URL+path, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if hits.Load() != 1 {
+			t.Errorf("%s: server hits = %d with every status cacheable, want 1", path, hits.Load())
+		}
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	var fail atomic.Bool
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			panic(http.ErrAbortHandler) // drop the connection
+		}
+		fmt.Fprintf(w, "response %d", hits.Add(1))
+	}))
+	defer server.Close()
+	opts := &RequestOptions{TTL: 20 * time.Millisecond}
+
+	for _, keep := range []bool{false, true} {
+		var options []Option
+		if keep {
+			options = append(options, WithStaleOnError())
+		}
+		client := newTestClient(t, options...)
+		fail.Store(false)
+		if _, err := client.Do(server.URL, opts); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		fail.Store(true)
+		r, err := client.Do(server.URL, opts)
+		if !keep {
+			if err == nil {
+				t.Errorf("without WithStaleOnError: got %q, want the fetch error", r.Data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expected the stale entry, got %v", err)
+		}
+		if !r.FromCache || !r.Stale || r.StaleReason != StaleOnError {
+			t.Errorf("fromCache=%v stale=%v reason=%q, want stale on error", r.FromCache, r.Stale, r.StaleReason)
+		}
+
+		// A successful refetch replaces the kept entry.
+		fail.Store(false)
+		r, err = client.Do(server.URL, opts)
+		if err != nil || r.FromCache || r.Stale {
+			t.Errorf("refetch: fromCache=%v stale=%v err=%v, want a live fetch", r.FromCache, r.Stale, err)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	data, finalURL, err := client.Refresh(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "response 2" || finalURL != server.URL {
+		t.Errorf("Refresh = %q, %q, want the network response", data, finalURL)
+	}
+	r, err := client.Do(server.URL, &RequestOptions{Preference: CacheOnly})
+	if err != nil || string(r.Data) != "response 2" {
+		t.Errorf("cache after Refresh = %q, %v, want the refreshed body", r.Data, err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("server hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestTTLFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write(bytes.Repeat([]byte("x"), 1<<20))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithTTLFunc(func(url string, size int, header http.Header) time.Duration {
+		if size > 1<<16 {
+			return time.Hour
+		}
+		return time.Second
+	}))
+
+	for path, want := range map[string]time.Duration{"/large": time.Hour, "/small": time.Second} {
+		if _, err := client.Do(server.URL+path, nil); err != nil {
+			t.Fatal(err)
+		}
+		entry, found := client.cache.GetEntry(client.key(server.URL + path))
+		if !found {
+			t.Fatalf("%s not cached", path)
+		}
+		if got := client.cache.expiresAt(entry).Sub(entry.CrawledAt); got != want {
+			t.Errorf("%s: TTL = %v, want %v", path, got, want)
+		}
+	}
+
+	r, err := client.Do(server.URL+"/override", &RequestOptions{TTL: 3 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := client.cache.GetEntry(client.key(server.URL + "/override"))
+	if got := client.cache.expiresAt(entry).Sub(entry.CrawledAt); got != 3*time.Minute {
+		t.Errorf("per-call TTL %v lost to TTLFunc (%q)", got, r.Data)
+	}
+}
+
+func TestDoHostOverride(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("site " + r.Host))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	for i := 0; i < 2; i++ {
+		for _, host := range []string{"a.example", "b.example"} {
+			result, err := client.Do(server.URL+"/", &RequestOptions{Host: host})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(result.Data) != "site "+host {
+				t.Errorf("Host %s: got %q", host, result.Data)
+			}
+			if result.FromCache != (i > 0) {
+				t.Errorf("Host %s, pass %d: FromCache = %v", host, i, result.FromCache)
+			}
+		}
+	}
+	if hits != 2 {
+		t.Errorf("hits = %d, want 2", hits)
+	}
+	if _, found := client.cache.GetEntry(client.key(server.URL + "/")); found {
+		t.Error("entries fetched with a Host override should not share the plain URL's key")
+	}
+}
+
+func TestResultTTL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithMaxTTL(time.Hour), WithTTLFunc(func(url string, size int, header http.Header) time.Duration {
+		if strings.HasSuffix(url, "/computed") {
+			return 10 * time.Minute
+		}
+		return 0
+	}))
+
+	for _, tc := range []struct {
+		path string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{"/policy", 0, time.Minute},
+		{"/override", 30 * time.Minute, 30 * time.Minute},
+		{"/clamped", 2 * time.Hour, time.Hour},
+		{"/computed", 0, 10 * time.Minute},
+	} {
+		result, err := client.Do(server.URL+tc.path, &RequestOptions{TTL: tc.ttl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.TTL != tc.want {
+			t.Errorf("%s: TTL = %v, want %v", tc.path, result.TTL, tc.want)
+		}
+	}
+
+	result, err := client.Do(server.URL+"/computed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.FromCache || result.TTL <= 9*time.Minute || result.TTL > 10*time.Minute {
+		t.Errorf("cached result: FromCache = %v, TTL = %v; want the remaining lifetime", result.FromCache, result.TTL)
+	}
+
+	result, err = client.Do(server.URL+"/unstored", &RequestOptions{NoStore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TTL != 0 {
+		t.Errorf("unstored result: TTL = %v, want 0", result.TTL)
+	}
+}
+
+func TestBypassFunc(t *testing.T) {
+	server, hits := countingServer(t)
+	var bypass atomic.Bool
+	client := newTestClient(t, WithBypassFunc(func(url string) bool {
+		return bypass.Load() && strings.Contains(url, "/live")
+	}))
+
+	get := func(path string) string {
+		t.Helper()
+		data, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	get("/live")
+	get("/static")
+
+	bypass.Store(true)
+	if got := get("/live"); got != "response 3" {
+		t.Errorf("bypassed read: got %q, want a fresh fetch", got)
+	}
+	if got := get("/static"); got != "response 2" {
+		t.Errorf("URL the func rejects: got %q, want the cached body", got)
+	}
+
+	bypass.Store(false)
+	if got := get("/live"); got != "response 3" {
+		t.Errorf("got %q, want the body stored by the bypassed fetch", got)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("hits = %d, want 3", hits.Load())
+	}
+}
+
+func TestNoStoreReadsCache(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	if _, err := client.Get(server.URL + "/cached"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.Do(server.URL+"/cached", &RequestOptions{NoStore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FromCache || string(r.Data) != "response 1" {
+		t.Errorf("NoStore hit: %q fromCache=%v, want the cached body", r.Data, r.FromCache)
+	}
+
+	for i := 0; i < 2; i++ {
+		r, err = client.Do(server.URL+"/probe", &RequestOptions{NoStore: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.FromCache {
+			t.Error("NoStore miss was served from the cache")
+		}
+	}
+	if _, found := client.cache.GetEntry(client.key(server.URL + "/probe")); found {
+		t.Error("NoStore miss wrote to the cache")
+	}
+	if hits.Load() != 3 {
+		t.Errorf("hits = %d, want 3", hits.Load())
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	body := strings.Repeat("<head>...</head>", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	r, err := client.Do(server.URL, &RequestOptions{CacheMaxBytes: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != body {
+		t.Errorf("live call returned %d bytes, want the full %d", len(r.Data), len(body))
+	}
+	entry, found := client.cache.GetEntry(client.key(server.URL))
+	if !found || string(entry.Data) != "<head>...</head>" {
+		t.Fatalf("stored %q, want the 16-byte prefix", entry.Data)
+	}
+	r, err = client.Do(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FromCache || string(r.Data) != "<head>...</head>" {
+		t.Errorf("cached read: %q fromCache=%v, want the stored prefix", r.Data, r.FromCache)
+	}
+}
@@ -0,0 +1,62 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// debugDump writes fetched responses matching a pattern to files, for
+// inspecting unexpected content offline.
+type debugDump struct {
+	dir      string
+	pattern  *regexp.Regexp
+	maxFiles int64
+	maxBytes int
+	written  atomic.Int64
+}
+
+// write dumps req and resp, whose body has already been read into body, if
+// url matches and the file budget is not spent. Failures are logged and
+// otherwise ignored; dumping never affects the fetch.
+func (d *debugDump) write(c *Cache, url string, req *http.Request, resp *http.Response, body []byte) {
+	if d == nil || !d.pattern.MatchString(url) {
+		return
+	}
+	n := d.written.Add(1)
+	if d.maxFiles > 0 && n > d.maxFiles {
+		return
+	}
+
+	var buf bytes.Buffer
+	if dump, err := httputil.DumpRequest(req, false); err == nil {
+		buf.Write(dump)
+	}
+	dump, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		c.logf("Failed to dump response for %s: %v", url, err)
+		return
+	}
+	buf.Write(dump)
+	if d.maxBytes > 0 && len(body) > d.maxBytes {
+		buf.Write(body[:d.maxBytes])
+		fmt.Fprintf(&buf, "\n[body truncated at %d of %d bytes]\n", d.maxBytes, len(body))
+	} else {
+		buf.Write(body)
+	}
+
+	name := fmt.Sprintf("%s-%d.http", time.Now().UTC().Format("20060102T150405.000000000"), n)
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		c.logf("Failed to dump response for %s: %v", url, err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(d.dir, name), buf.Bytes(), 0644); err != nil {
+		c.logf("Failed to dump response for %s: %v", url, err)
+	}
+}
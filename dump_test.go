@@ -0,0 +1,44 @@
+package httpcache
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDebugDump(t *testing.T) {
+	server, _ := countingServer(t)
+	dir := filepath.Join(t.TempDir(), "dumps")
+	client := newTestClient(t, WithDebugDump(dir, regexp.MustCompile(`/debug`), 2, 4))
+
+	for _, path := range []string{"/debug/1", "/other", "/debug/2", "/debug/3"} {
+		if _, err := client.Do(server.URL+path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d dump files, want 2 (the limit)", len(files))
+	}
+	dump, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"GET /debug/", "User-Agent:", "HTTP/1.1 200 OK", "Content-Length: 10", "\r\n\r\nresp\n[body truncated at 4 of 10 bytes]"} {
+		if !strings.Contains(string(dump), want) {
+			t.Errorf("dump lacks %q:\n%s", want, dump)
+		}
+	}
+	for _, f := range files {
+		data, _ := os.ReadFile(f)
+		if strings.Contains(string(data), "/other") {
+			t.Errorf("unmatched URL dumped in %s", f)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package httpcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind identifies what a CacheEvent reports.
+type EventKind int
+
+const (
+	// EventHit is a request answered from the cache, fresh or stale.
+	EventHit EventKind = iota
+	// EventMiss is a request that looked in the cache and found nothing
+	// usable there.
+	EventMiss
+	// EventStore is a fetched response written to the cache.
+	EventStore
+	// EventEvict is an entry deleted from the cache, whether it expired,
+	// failed validation or was deleted explicitly.
+	EventEvict
+	// EventFetchError is a network fetch that failed.
+	EventFetchError
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventStore:
+		return "store"
+	case EventEvict:
+		return "evict"
+	case EventFetchError:
+		return "fetch-error"
+	}
+	return "unknown"
+}
+
+// CacheEvent is published on the channel returned by Events.
+type CacheEvent struct {
+	Kind EventKind
+	// Key is the store key of the entry concerned.
+	Key string
+	// URL is the requested URL. It is empty for evictions.
+	URL  string
+	Time time.Time
+	// Err is the fetch error of an EventFetchError.
+	Err error
+}
+
+// eventBufferSize is the capacity of the channel returned by Events.
+const eventBufferSize = 256
+
+// eventBus publishes events to a single buffered channel, dropping them
+// rather than blocking when it is full.
+type eventBus struct {
+	mu      sync.RWMutex
+	ch      chan CacheEvent
+	closed  bool
+	dropped atomic.Int64
+}
+
+// channel returns the bus's channel, creating it on first use.
+func (b *eventBus) channel() <-chan CacheEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch == nil {
+		b.ch = make(chan CacheEvent, eventBufferSize)
+		if b.closed {
+			close(b.ch)
+		}
+	}
+	return b.ch
+}
+
+// publish sends e if anyone has asked for the channel. It never blocks.
+func (b *eventBus) publish(e CacheEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.ch == nil || b.closed {
+		return
+	}
+	e.Time = time.Now()
+	select {
+	case b.ch <- e:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	if b.ch != nil {
+		close(b.ch)
+	}
+}
+
+// Events returns a channel of cache hits, misses, stores, evictions and
+// fetch errors. Events are only published once Events has been called, and
+// every call returns the same channel, so there is a single stream to share.
+// The channel buffers 256 events; when it is full, new events are dropped
+// rather than slowing requests down, and counted in Stats.DroppedEvents. The
+// channel is closed when the client is closed.
+func (hc *HTTPClient) Events() <-chan CacheEvent {
+	return hc.cache.events.channel()
+}
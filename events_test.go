@@ -0,0 +1,59 @@
+package httpcache
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	server, _ := countingServer(t)
+	client := newTestClient(t)
+	events := client.Events()
+
+	client.Do(server.URL, nil)
+	client.Do(server.URL, nil)
+	client.DeleteURL(server.URL)
+
+	down := httptest.NewServer(nil)
+	down.Close()
+	client.Do(down.URL, nil)
+
+	want := []EventKind{EventMiss, EventStore, EventHit, EventEvict, EventMiss, EventFetchError}
+	for i, kind := range want {
+		e := <-events
+		if e.Kind != kind {
+			t.Fatalf("event %d = %v, want %v", i, e.Kind, kind)
+		}
+		if e.Key == "" || e.Time.IsZero() {
+			t.Errorf("event %d (%v) lacks a key or time: %+v", i, e.Kind, e)
+		}
+		if kind == EventFetchError && e.Err == nil {
+			t.Error("fetch error event has no error")
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e.Kind)
+	default:
+	}
+
+	client.Close()
+	if _, ok := <-events; ok {
+		t.Error("channel still open after Close")
+	}
+}
+
+func TestEventsDropWhenFull(t *testing.T) {
+	server, _ := countingServer(t)
+	client := newTestClient(t)
+	client.Events()
+
+	for i := 0; i < eventBufferSize; i++ {
+		client.Do(server.URL, nil)
+	}
+	// The first request published a miss and a store, so the buffer is
+	// full and the last hit was dropped.
+	if dropped := client.Stats().DroppedEvents; dropped != 1 {
+		t.Errorf("DroppedEvents = %d, want 1", dropped)
+	}
+}
@@ -0,0 +1,60 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNoURLs is returned by GetWithFallbacks when it is given no URLs.
+var ErrNoURLs = errors.New("httpcache: no URLs given")
+
+// GetWithFallbacks tries urls in order, typically a primary and its mirrors,
+// and returns the body and final URL of the first that succeeds. A URL fails
+// when fetching it returns an error, its status code is 400 or above, or
+// validator, if not nil, rejects its body. Each URL is read from and written
+// to the cache under its own key, so the successful result is cached under
+// the URL that worked; with WithFallbackAlias the entry stored for it is
+// also copied under the primary URL, as GetWithAliases copies entries, and
+// nothing is if the mirror's response was not cached. If every URL fails,
+// the error of the last one is returned.
+func (hc *HTTPClient) GetWithFallbacks(urls []string, validator ContentValidator) ([]byte, string, error) {
+	if len(urls) == 0 {
+		return nil, "", ErrNoURLs
+	}
+	opts := &RequestOptions{
+		Validator:    validator,
+		AcceptStatus: func(code int) bool { return code < http.StatusBadRequest },
+	}
+	var err error
+	for i, url := range urls {
+		var r *Result
+		r, err = hc.Do(url, opts)
+		if err == nil {
+			err = fallbackFailure(r, validator)
+		}
+		if err != nil {
+			continue
+		}
+		if i > 0 && hc.aliasFallbacks && hc.Enabled() {
+			// Copy what the mirror's fetch stored, if it stored anything.
+			if entry, ok := hc.cache.GetEntry(hc.key(url)); ok {
+				hc.copyEntryTo(urls[0], nil, entry, true)
+			}
+		}
+		return r.Data, r.FinalURL, nil
+	}
+	return nil, "", err
+}
+
+// fallbackFailure returns why r does not count as a success for
+// GetWithFallbacks, or nil if it does.
+func fallbackFailure(r *Result, validator ContentValidator) error {
+	if r.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("httpcache: %s: status %d", r.FinalURL, r.StatusCode)
+	}
+	if validator != nil && !validator(r.Data) {
+		return fmt.Errorf("httpcache: %s: body failed validation", r.FinalURL)
+	}
+	return nil
+}
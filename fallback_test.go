@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetWithFallbacks(t *testing.T) {
+	var primaryHits atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("captcha"))
+	}))
+	defer broken.Close()
+	mirror, mirrorHits := countingServer(t)
+
+	validator := func(body []byte) bool { return !bytes.Equal(body, []byte("captcha")) }
+	urls := []string{primary.URL, broken.URL, mirror.URL}
+
+	client := newTestClient(t)
+	for i := 0; i < 2; i++ {
+		data, finalURL, err := client.GetWithFallbacks(urls, validator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "response 1" || finalURL != mirror.URL {
+			t.Errorf("call %d: data=%q finalURL=%q", i+1, data, finalURL)
+		}
+	}
+	if mirrorHits.Load() != 1 {
+		t.Errorf("mirror hits = %d, want 1", mirrorHits.Load())
+	}
+	if primaryHits.Load() != 2 {
+		t.Errorf("primary hits = %d, want 2 without aliasing", primaryHits.Load())
+	}
+	if _, found := client.cache.GetEntry(client.key(primary.URL)); found {
+		t.Error("failed primary response should not be cached")
+	}
+
+	if _, _, err := client.GetWithFallbacks(urls[:2], validator); err == nil {
+		t.Error("expected an error when every URL fails")
+	}
+}
+
+func TestGetWithFallbacksAlias(t *testing.T) {
+	var primaryHits atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	mirror, _ := countingServer(t)
+
+	client := newTestClient(t, WithFallbackAlias())
+	for i := 0; i < 2; i++ {
+		data, _, err := client.GetWithFallbacks([]string{primary.URL, mirror.URL}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "response 1" {
+			t.Errorf("call %d: data=%q", i+1, data)
+		}
+	}
+	if primaryHits.Load() != 1 {
+		t.Errorf("primary hits = %d, want 1 once aliased", primaryHits.Load())
+	}
+}
+
+func TestGetWithFallbacksAliasCopiesStoredEntry(t *testing.T) {
+	var primaryHits atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("mirrored"))
+	}))
+	defer mirror.Close()
+	client := newTestClient(t, WithFallbackAlias(), WithClassifier(func([]byte, string) string { return "page" }))
+
+	// The primary gets the entry the mirror's fetch stored, as stored.
+	if _, _, err := client.GetWithFallbacks([]string{primary.URL + "/page", mirror.URL + "/page"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := client.cache.GetEntry(client.key(primary.URL + "/page"))
+	if !ok || string(entry.Data) != "mirrored" || entry.Class != "page" || entry.FinalURL != mirror.URL+"/page" {
+		t.Errorf("aliased entry = %+v, want the mirror's stored entry", entry)
+	}
+
+	// A mirror response the cache does not keep is not aliased either.
+	if _, _, err := client.GetWithFallbacks([]string{primary.URL + "/empty", mirror.URL + "/empty"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.cache.GetEntry(client.key(primary.URL + "/empty")); ok {
+		t.Error("an uncached 204 from the mirror was stored under the primary")
+	}
+}
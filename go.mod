@@ -5,6 +5,10 @@ go 1.23.1
 require (
 	github.com/liuzl/store v0.0.0-20190530065605-e2dbcd3c77fc
 	github.com/projectdiscovery/useragent v0.0.78
+	github.com/syndtr/goleveldb v1.0.0
+	golang.org/x/net v0.29.0
+	golang.org/x/sync v0.8.0
+	golang.org/x/text v0.18.0
 )
 
 require (
@@ -16,6 +20,4 @@ require (
 	github.com/projectdiscovery/blackrock v0.0.1 // indirect
 	github.com/projectdiscovery/utils v0.2.17 // indirect
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d // indirect
-	github.com/syndtr/goleveldb v1.0.0 // indirect
-	golang.org/x/net v0.29.0 // indirect
 )
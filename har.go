@@ -0,0 +1,113 @@
+package httpcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// harFile is the part of the HTTP Archive format ImportHAR reads.
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method   string `json:"method"`
+				URL      string `json:"url"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status  int         `json:"status"`
+				Headers []harHeader `json:"headers"`
+				Content struct {
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ImportHAR seeds the cache from an HTTP Archive, such as one saved from a
+// browser's developer tools, so a recorded session can be replayed without
+// network access. Each recorded response is stored under the key a request
+// for its URL would use, with the TTL set by WithHARTTL or else that of the
+// URL's policy; entries without a positive TTL, or whose method the client
+// does not cache, are skipped. Base64-encoded bodies are decoded. The entries
+// are stored together, once the whole archive has been read, with SetBatch.
+// ImportHAR returns the number of entries stored.
+func (hc *HTTPClient) ImportHAR(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return 0, fmt.Errorf("httpcache: parsing HAR %s: %w", path, err)
+	}
+
+	var batch []BatchEntry
+	for i, e := range har.Log.Entries {
+		method := e.Request.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		if !hc.cacheableMethod(method) {
+			continue
+		}
+		ttl := hc.harTTL
+		if ttl <= 0 {
+			ttl = hc.cache.GetTTL(e.Request.URL)
+		}
+		if ttl <= 0 {
+			continue
+		}
+
+		body := []byte(e.Response.Content.Text)
+		if e.Response.Content.Encoding == "base64" {
+			body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text)
+			if err != nil {
+				return 0, fmt.Errorf("httpcache: HAR entry %d: %w", i, err)
+			}
+		}
+		header := make(http.Header)
+		for _, h := range e.Response.Headers {
+			header.Add(h.Name, h.Value)
+		}
+		req, err := hc.newRequest(context.Background(), method, e.Request.URL, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		bodyHash := ""
+		if e.Request.PostData != nil && e.Request.PostData.Text != "" {
+			sum := sha256.Sum256([]byte(e.Request.PostData.Text))
+			bodyHash = hex.EncodeToString(sum[:])
+		}
+		batch = append(batch, BatchEntry{
+			Key: hc.requestKey(e.Request.URL, req, bodyHash),
+			Entry: &CacheEntry{
+				Data:       body,
+				URL:        e.Request.URL,
+				FinalURL:   e.Request.URL,
+				StatusCode: e.Response.Status,
+				Header:     header,
+			},
+			TTL: ttl,
+		})
+	}
+	if err := hc.cache.SetBatch(batch); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
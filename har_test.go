@@ -0,0 +1,72 @@
+package httpcache
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHAR = `{"log": {"version": "1.2", "entries": [
+  {"request": {"method": "GET", "url": "http://example.com/page"},
+   "response": {"status": 200,
+     "headers": [{"name": "Content-Type", "value": "text/html"}],
+     "content": {"mimeType": "text/html", "text": "<p>hello</p>"}}},
+  {"request": {"method": "GET", "url": "http://example.com/logo.png"},
+   "response": {"status": 200,
+     "headers": [{"name": "Content-Type", "value": "image/png"}],
+     "content": {"mimeType": "image/png", "text": "iVBORw==", "encoding": "base64"}}},
+  {"request": {"method": "POST", "url": "http://example.com/api",
+     "postData": {"mimeType": "application/json", "text": "{\"q\":1}"}},
+   "response": {"status": 201, "headers": [],
+     "content": {"mimeType": "application/json", "text": "{\"ok\":true}"}}}
+]}}`
+
+func TestImportHAR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.har")
+	if err := os.WriteFile(path, []byte(testHAR), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t)
+	n, err := client.ImportHAR(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("imported %d entries, want 2 with POST skipped", n)
+	}
+	opts := &RequestOptions{Preference: CacheOnly}
+	r, err := client.Do("http://example.com/page", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "<p>hello</p>" || r.Header.Get("Content-Type") != "text/html" {
+		t.Errorf("page = %q, %v", r.Data, r.Header)
+	}
+	r, err = client.Do("http://example.com/logo.png", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "\x89PNG" {
+		t.Errorf("base64 body = %q, want decoded PNG magic", r.Data)
+	}
+
+	client = newTestClient(t, WithCacheableMethods(http.MethodPost), WithHARTTL(time.Hour))
+	if n, err := client.ImportHAR(path); err != nil || n != 3 {
+		t.Fatalf("ImportHAR with POST caching = %d, %v; want 3", n, err)
+	}
+	r, err = client.Do("http://example.com/api", &RequestOptions{
+		Preference: CacheOnly,
+		Method:     http.MethodPost,
+		Body:       strings.NewReader(`{"q":1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusCreated || string(r.Data) != `{"ok":true}` {
+		t.Errorf("POST = %d %q", r.StatusCode, r.Data)
+	}
+}
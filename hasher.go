@@ -0,0 +1,63 @@
+package httpcache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Hasher turns the text identifying a request into a store key.
+type Hasher interface {
+	Hash(input string) string
+}
+
+// KeyEncoding is the text encoding of the digests used as store keys.
+type KeyEncoding int
+
+const (
+	// Hex encodes a SHA-256 digest in 64 characters. It is the default.
+	Hex KeyEncoding = iota
+	// Base64URL encodes it in 43, unpadded, saving key space in large
+	// stores.
+	Base64URL
+)
+
+func (e KeyEncoding) encode(sum []byte) string {
+	if e == Base64URL {
+		return base64.RawURLEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// SHA256Hasher is the default Hasher: the SHA-256 digest of the input, in
+// hex as returned by HashKey unless Encoding says otherwise.
+type SHA256Hasher struct {
+	Encoding KeyEncoding
+}
+
+// Hash implements Hasher.
+func (h SHA256Hasher) Hash(input string) string {
+	sum := sha256.Sum256([]byte(input))
+	return h.Encoding.encode(sum[:])
+}
+
+// HMACHasher derives keys with HMAC-SHA256 under a secret, so that someone
+// who can list the store but does not know the secret cannot tell whether a
+// given URL is cached. It does not hide the cached bodies, which are stored
+// as they are.
+type HMACHasher struct {
+	secret []byte
+}
+
+// NewHMACHasher returns an HMACHasher keyed by secret.
+func NewHMACHasher(secret []byte) *HMACHasher {
+	return &HMACHasher{secret: append([]byte(nil), secret...)}
+}
+
+// Hash implements Hasher.
+func (h *HMACHasher) Hash(input string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(input))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,71 @@
+package httpcache
+
+import "testing"
+
+func TestHashers(t *testing.T) {
+	const url = "http://example.com/a"
+	keys := map[string]string{
+		"sha256":   SHA256Hasher{}.Hash(url),
+		"hmac a":   NewHMACHasher([]byte("a")).Hash(url),
+		"hmac b":   NewHMACHasher([]byte("b")).Hash(url),
+		"hmac a 2": NewHMACHasher([]byte("a")).Hash(url),
+	}
+	if keys["sha256"] != HashKey(url) {
+		t.Errorf("SHA256Hasher = %q, want HashKey %q", keys["sha256"], HashKey(url))
+	}
+	if keys["hmac a"] != keys["hmac a 2"] {
+		t.Error("HMACHasher is not deterministic")
+	}
+	for _, pair := range [][2]string{{"sha256", "hmac a"}, {"sha256", "hmac b"}, {"hmac a", "hmac b"}} {
+		if keys[pair[0]] == keys[pair[1]] {
+			t.Errorf("%s and %s give the same key %q", pair[0], pair[1], keys[pair[0]])
+		}
+	}
+}
+
+func TestWithHasher(t *testing.T) {
+	server, hits := countingServer(t)
+	hasher := NewHMACHasher([]byte("secret"))
+	client := newTestClient(t, WithHasher(hasher))
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Do(server.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hits = %d, want 1", hits.Load())
+	}
+	if _, _, found := client.cache.Get(hasher.Hash(server.URL)); !found {
+		t.Error("entry not stored under the HMAC key")
+	}
+	if _, _, found := client.cache.Get(HashKey(server.URL)); found {
+		t.Error("entry stored under the default key")
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	const url = "http://example.com/a"
+	hex := SHA256Hasher{Encoding: Hex}.Hash(url)
+	b64 := SHA256Hasher{Encoding: Base64URL}.Hash(url)
+	if hex != HashKey(url) || len(hex) != 64 {
+		t.Errorf("hex key = %q, want HashKey's 64 characters", hex)
+	}
+	if len(b64) != 43 || b64 == hex || b64 != (SHA256Hasher{Encoding: Base64URL}).Hash(url) {
+		t.Errorf("base64url key = %q, want 43 stable characters unlike the hex key", b64)
+	}
+
+	server, hits := countingServer(t)
+	client := newTestClient(t, WithKeyEncoding(Base64URL))
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hits = %d, want the second Get served from cache", hits.Load())
+	}
+	if _, err := client.cache.Store.Get(SHA256Hasher{Encoding: Base64URL}.Hash(server.URL)); err != nil {
+		t.Errorf("no entry under the base64url key: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Header ordering
+//
+// Go's transport writes request headers in its own order, canonicalizing
+// their names, which anti-bot systems can fingerprint. With WithHeaderOrder
+// the client rewrites each request's header block on its way to the
+// connection: the named headers come first, in the order and casing given,
+// followed by the rest as the transport wrote them. Connections are not
+// reused, so every connection carries exactly one request and its header
+// block is the first thing written to it.
+
+// orderedConn is a connection whose first header block is written in order.
+type orderedConn struct {
+	net.Conn
+	order []string
+
+	head []byte // the header block buffered so far
+	done bool   // whether the header block has been written
+}
+
+func (c *orderedConn) Write(p []byte) (int, error) {
+	if c.done {
+		return c.Conn.Write(p)
+	}
+	c.head = append(c.head, p...)
+	end := bytes.Index(c.head, []byte("\r\n\r\n"))
+	if end < 0 {
+		return len(p), nil
+	}
+	c.done = true
+	out := append(orderHeaders(c.head[:end], c.order), c.head[end+2:]...)
+	c.head = nil
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// orderHeaders rewrites head, a request line and its header lines without
+// the blank line ending them, so that the headers named in order come first
+// with that casing. Each line of the result ends in CRLF.
+func orderHeaders(head []byte, order []string) []byte {
+	lines := strings.Split(string(head), "\r\n")
+	var out bytes.Buffer
+	out.WriteString(lines[0] + "\r\n")
+	rest := lines[1:]
+	for _, name := range order {
+		kept := rest[:0]
+		for _, line := range rest {
+			if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, name) {
+				out.WriteString(name + ":" + value + "\r\n")
+				continue
+			}
+			kept = append(kept, line)
+		}
+		rest = kept
+	}
+	for _, line := range rest {
+		out.WriteString(line + "\r\n")
+	}
+	return out.Bytes()
+}
+
+// orderHeaderTransport makes transport send requests with hc.headerOrder.
+// Plain connections are wrapped as they are dialed; TLS connections are
+// dialed here, offering only HTTP/1.1, so that the wrapper sits above the
+// encryption.
+func (hc *HTTPClient) orderHeaderTransport(transport *http.Transport) {
+	dial := transport.DialContext
+	order := hc.headerOrder
+	transport.DisableKeepAlives = true
+	transport.ForceAttemptHTTP2 = false
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &orderedConn{Conn: conn, order: order}, nil
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		config := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			config = transport.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		config.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &orderedConn{Conn: tlsConn, order: order}, nil
+	}
+}
@@ -0,0 +1,61 @@
+package httpcache
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeaderOrder(t *testing.T) {
+	// A raw server, since net/http would canonicalize what it reads.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var head []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			head = append(head, strings.TrimRight(line, "\r\n"))
+		}
+		lines <- head
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok"))
+	}()
+
+	client := newTestClient(t, WithHeaderOrder("accept-language", "User-Agent", "host"))
+	r, err := client.Do("http://"+ln.Addr().String()+"/", &RequestOptions{
+		Header: http.Header{"Accept-Language": {"en"}, "X-Extra": {"1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "ok" {
+		t.Errorf("body = %q, want ok", r.Data)
+	}
+
+	head := <-lines
+	var names []string
+	for _, line := range head[1:] {
+		name, _, _ := strings.Cut(line, ":")
+		names = append(names, name)
+	}
+	if len(names) < 4 || names[0] != "accept-language" || names[1] != "User-Agent" || names[2] != "host" {
+		t.Fatalf("headers sent as %q, want accept-language, User-Agent, host first", names)
+	}
+	if !strings.Contains(strings.Join(names[3:], ","), "X-Extra") {
+		t.Errorf("headers sent as %q, want X-Extra after the ordered ones", names)
+	}
+}
@@ -2,141 +2,483 @@ package httpcache
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/liuzl/store"
-	"github.com/projectdiscovery/useragent"
+	"golang.org/x/sync/singleflight"
 )
 
+// cacheDir and policiesFile configure the client returned by GetClient.
+// RegisterFlags exposes them as command-line flags.
 var (
-	cacheDir     = flag.String("cache_dir", ".httpcache", "Directory for HTTP cache storage")
-	policiesFile = flag.String("policies_file", ".httpcache/policies.txt", "File containing cache policies, one per line in format: regex=duration")
+	cacheDir     = defaultCacheDir
+	policiesFile = defaultPoliciesFile
 )
 
+const (
+	defaultCacheDir     = ".httpcache"
+	defaultPoliciesFile = ".httpcache/policies.txt"
+)
+
+// clientConfig returns the cache directory and policies file GetClient uses.
+// Each comes from its flag if that was changed from the default, otherwise
+// from the HTTPCACHE_DIR or HTTPCACHE_POLICIES environment variable if set,
+// and otherwise is the default.
+func clientConfig() (dir, policies string) {
+	dir, policies = cacheDir, policiesFile
+	if env := os.Getenv("HTTPCACHE_DIR"); env != "" && dir == defaultCacheDir {
+		dir = env
+	}
+	if env := os.Getenv("HTTPCACHE_POLICIES"); env != "" && policies == defaultPoliciesFile {
+		policies = env
+	}
+	return dir, policies
+}
+
+// RegisterFlags defines the -cache_dir and -policies_file flags configuring
+// the client returned by GetClient on fs, or on flag.CommandLine if fs is
+// nil. The package defines no flags unless asked to, so applications that
+// manage their own flags, or already use these names, are unaffected. Call
+// it before parsing fs and before the first GetClient. A flag left at its
+// default gives way to the HTTPCACHE_DIR or HTTPCACHE_POLICIES environment
+// variable.
+func RegisterFlags(fs *flag.FlagSet) {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	fs.StringVar(&cacheDir, "cache_dir", cacheDir, "Directory for HTTP cache storage")
+	fs.StringVar(&policiesFile, "policies_file", policiesFile, "File containing cache policies, one per line in format: regex=duration, or a directory of *.txt such files")
+}
+
 type CacheEntry struct {
-	Data      []byte    `json:"data"`
-	URL       string    `json:"url"`
-	FinalURL  string    `json:"final_url"`
+	Data       []byte      `json:"data"`
+	URL        string      `json:"url"`
+	FinalURL   string      `json:"final_url"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	// ContentHash is set when the body is stored separately as deduplicated
+	// content; Data is then empty in the stored entry.
+	ContentHash string `json:"content_hash,omitempty"`
+	// Codec is the marker of the codec the stored body was encoded with.
+	Codec byte `json:"codec,omitempty"`
+	// TTL is set when the entry was stored with a per-call TTL, which then
+	// takes the place of the policy TTL when judging its freshness.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Charset is the character set detected for a textual body when it was
+	// fetched, such as "utf-8" or "gbk". The body itself is stored as
+	// received.
+	Charset string `json:"charset,omitempty"`
+	// Class is the label WithClassifier gave the body when it was fetched.
+	Class     string    `json:"class,omitempty"`
 	CrawledAt time.Time `json:"crawled_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// RevalidatedAt is when a conditional request last confirmed the entry
+	// unchanged (see WithConditionalRequests). Freshness is counted from it
+	// while CrawledAt keeps the time the body was fetched; it is zero for
+	// entries never revalidated, including those stored before it existed.
+	RevalidatedAt time.Time `json:"revalidated_at,omitempty"`
+	// Encoding is the Content-Encoding the body is stored in, as it was
+	// received, when WithRawEncoding kept it compressed. Data is always
+	// decompressed once read back.
+	Encoding string `json:"encoding,omitempty"`
+	// RedirectChain lists the URLs requested on the way to FinalURL, from
+	// URL itself up to the last one that redirected, when
+	// WithRedirectChains records them. It is empty for responses that were
+	// not redirected.
+	RedirectChain []string `json:"redirect_chain,omitempty"`
+	// Meta is caller-defined metadata, such as a crawl job ID, stored with
+	// the entry as RequestOptions.Meta gave it. It is nil unless set.
+	Meta map[string]string `json:"meta,omitempty"`
+	// Size is the length of the body as set, recorded when the entry is
+	// stored so scans can rank entries by size without decoding bodies. It
+	// is zero in entries stored before it was recorded.
+	Size int `json:"size,omitempty"`
+
+	// raw is the body as received, still in Encoding, and is what gets
+	// stored in place of Data.
+	raw []byte
+
+	// proto and tls describe the connection of a live fetch, and elapsed
+	// is how long it took to receive the whole body. Being unexported,
+	// they are never stored.
+	proto   string
+	tls     *tls.ConnectionState
+	elapsed time.Duration
 }
 
 type CachePolicy struct {
 	Pattern *regexp.Regexp
 	TTL     time.Duration
+
+	// Header is added to requests for URLs matching this policy. Policy
+	// headers override the client's default headers (including User-Agent)
+	// and are overridden by headers given for a single call.
+	Header http.Header
+
+	// BodyMustMatch and BodyMustNotMatch, if set, keep fetched bodies that
+	// fail them out of the cache, for example pages carrying a rate-limit
+	// notice. They are checked in addition to any ContentValidator given
+	// for the call: a body is only cached if it passes both.
+	BodyMustMatch    *regexp.Regexp
+	BodyMustNotMatch *regexp.Regexp
+
+	// Rate caps the live fetches of URLs matching this policy at Rate per
+	// second, and MaxConcurrent caps how many run at once; zero means no
+	// limit. The limits are shared by every fetch the policy matches and
+	// apply on top of WithMaxConcurrentFetches. Like the TTL they come from
+	// the first matching policy only, so a URL covered by overlapping
+	// patterns is limited by the earliest one in the list.
+	Rate          float64
+	MaxConcurrent int
+
+	// KeyParams and IgnoreParams choose the query parameters of matching
+	// URLs that enter the cache key, replacing those of WithKeyParams; see
+	// there. Either list set makes the policy's lists apply.
+	KeyParams    []string
+	IgnoreParams []string
+
+	// AllowEmpty exempts matching URLs, whose bodies may legitimately be
+	// empty or short, from WithRetryOnEmptyBody.
+	AllowEmpty bool
 }
 
 type Cache struct {
-	Store    *store.LevelStore
+	Store    Store
 	Policies []CachePolicy
+
+	// Logger receives warnings and errors. The standard logger is used when
+	// it is nil.
+	Logger Logger
+
+	// MaxTTL caps the lifetime of every entry, whatever its policy says.
+	// Zero means no cap.
+	MaxTTL time.Duration
+
+	// GlobalMaxAge expires every entry crawled longer ago than that, whatever
+	// its policy, TTL, revalidations or ExpiryFunc say, bounding the age of
+	// anything served so that the whole store is crawled again
+	// periodically. Zero means no bound. Entries written before crawl times
+	// were recorded are not affected.
+	GlobalMaxAge time.Duration
+
+	// Codec encodes bodies before they are stored. Entries record which codec
+	// wrote them, so changing it leaves existing entries readable as long as
+	// their codec is registered. Bodies are stored as-is when it is nil.
+	Codec Codec
+
+	// Precompressed reports whether a body, of the given Content-Type and
+	// fetched from url, is compressed already, such as JPEG images or zip
+	// archives. Codec is skipped for such bodies, which are stored as they
+	// are. IsPrecompressed is used when it is nil; a function always
+	// returning false encodes every body.
+	Precompressed func(contentType, url string) bool
+
+	// Serializer encodes entries for the store. Values record the format
+	// they were written in, so existing entries stay readable after a
+	// change. Gob is used when it is nil.
+	Serializer Serializer
+
+	// ServerClock judges freshness by the Date and Age headers of stored
+	// responses and the servers' clock, guarding against local clock skew.
+	// See clock.go.
+	ServerClock bool
+
+	// ExpiryFunc, if set, decides alone whether an entry is expired, in place
+	// of its TTL and crawl time. It sees the entry as it was set, body
+	// included.
+	ExpiryFunc func(entry CacheEntry) bool
+
+	// DeleteGracePeriod keeps expired entries in the store until they have
+	// been expired for that long. Reads still treat them as misses, but
+	// NetworkFirst fallbacks and refresh on expiry can serve them, and
+	// concurrent readers of a just-expired entry do not race its deletion.
+	// PurgeExpired and the janitor honor it as well. The period is counted
+	// from the TTL expiry even when an ExpiryFunc decides freshness.
+	DeleteGracePeriod time.Duration
+
+	// NoLazyDelete leaves expired entries in the store when reads come
+	// across them, so a cache kept for analysis stays as it was captured
+	// while explicit writes still work. Expired entries are still misses,
+	// and a miss still fetches and stores the new body unless the request
+	// says otherwise; only PurgeExpired, the janitor and Delete remove
+	// entries then.
+	NoLazyDelete bool
+
+	// MaintenanceWorkers is the number of goroutines PurgeExpired,
+	// ReevaluatePolicies, DeleteMatching and the janitor decode, match and
+	// delete entries with; zero or one scans on the calling goroutine. More
+	// workers speed up cleaning large stores on multi-core machines, the
+	// store being traversed in order by a single iterator still. ExpiryFunc
+	// is then called concurrently.
+	MaintenanceWorkers int
+
+	// Dedup stores identical bodies once, keyed by their content hash, and
+	// has entries reference them. See dedup.go.
+	Dedup bool
+
+	// ParsedJSON keeps a gob encoding of the value GetJSON last decoded
+	// from each entry, so hits can skip parsing the JSON again. See json.go.
+	ParsedJSON bool
+
+	refMu        sync.Mutex
+	casMu        sync.Mutex
+	clockOffsets sync.Map // host -> time.Duration, see clock.go
+	events       eventBus
+	// codecIn and codecOut sum the body bytes given to Codec and those it
+	// produced, for Stats.CompressionRatio.
+	codecIn  atomic.Int64
+	codecOut atomic.Int64
 }
 
 type HTTPClient struct {
 	cache  *Cache
 	client *http.Client
+	store  Store
+
+	disabled atomic.Bool
+	mode     atomic.Int32
+
+	header                http.Header
+	hostAuth              map[string]Credentials
+	followRedirects       bool
+	redirectChains        bool
+	fallbackToPassthrough bool
+	aliasFallbacks        bool
+	contentLocation       bool
+	staleWarning          bool
+	autoReferer           bool
+	lastURL               atomic.Value
+	lockWait              time.Duration
+	storeOpTimeout        time.Duration
+	maxCacheableDuration  time.Duration
+	fallbackToTemp        bool
+	tempDir               string
+
+	keyFunc          func(url string) string
+	keyFromRequest   func(req *http.Request) string
+	userAgents       *userAgentRotation
+	keyLabelLen      int
+	cacheableMethods map[string]bool
+	acceptInKey      bool
+	authInKey        bool
+	schemaValidator  func([]byte) error
+	classifier       func(body []byte, finalURL string) string
+	bodyTransform    func(url string, body []byte) []byte
+	cacheableStatus  CacheableStatusFunc
+	ttlFunc          TTLFunc
+	conditional      bool
+	retries          int
+	retryDelay       time.Duration
+	maxRetryDelay    time.Duration
+	backoff          Backoff
+	minBodyBytes     int
+	deadLetters      *deadLetterList
+	harTTL           time.Duration
+	accessLog        *accessLog
+	debugDump        *debugDump
+	schemeInKey      bool
+	foldSlash        bool
+	keyParams        paramFilter
+	indexFiles       []indexRule
+	unixSockets      map[string]string
+	headerOrder      []string
+	dialTimeout      time.Duration
+	ssrfGuard        *ssrfGuard
+	bypassFunc       func(url string) bool
+	proxy            *url.URL
+	proxyUser        *url.Userinfo
+
+	slowFetchThreshold time.Duration
+
+	maxBodyBytes     int64
+	maxDecompressed  int64
+	rawEncoding      bool
+	fetchSem         chan struct{}
+	policyLimiters   sync.Map // *CachePolicy -> *policyLimiter
+	failFastWhenBusy bool
+	inFlight         atomic.Int64
+	latency          latencyHistogram
+	bytesFromCache   atomic.Int64
+	bytesFromNetwork atomic.Int64
+
+	janitor    *janitor
+	statsSaver *statsSaver
+
+	refreshOnExpiry    bool
+	staleOnError       bool
+	maxStale           time.Duration
+	background         singleflight.Group
+	revalidationWindow time.Duration
+	revalidations      singleflight.Group
+	revalidating       sync.Map // key -> time.Time the revalidation started
+	backgroundWG       sync.WaitGroup
+	requestWG          sync.WaitGroup
+	closeMu            sync.RWMutex // held for writing to begin Shutdown
+	closing            bool
+	onPrefetchError    func(url string, err error)
+
+	redirectPrefetchSem chan struct{}
+
+	backgroundSem        chan struct{}
+	backgroundTimeout    time.Duration
+	backgroundRefreshing atomic.Int64
+	backgroundSkipped    atomic.Int64
+	backgroundTimedOut   atomic.Int64
+	refreshLockTTL       time.Duration
+	refreshLockOwner     string
+}
+
+// FetchInfo describes the response a body was served from.
+type FetchInfo struct {
+	FinalURL   string
+	StatusCode int
+	Header     http.Header
+	FromCache  bool
+	// Stale is set when the body came from an expired cache entry, and
+	// StaleReason says why it was served anyway.
+	Stale       bool
+	StaleReason StaleReason
+	// Proto and TLS describe the connection a live fetch used, e.g.
+	// "HTTP/2.0" and the negotiated TLS version and cipher suite (nil
+	// without TLS). They are empty for responses served from the cache.
+	Proto string
+	TLS   *tls.ConnectionState
+	// Charset is the character set detected for a textual body, or empty
+	// if the body is not text or was cached before detection was added.
+	Charset string
+	// Class is the label WithClassifier gave the body, or empty if there is
+	// no classifier.
+	Class string
+	// CrawledAt is when a body served from the cache was fetched; it is
+	// zero for bodies fetched by the call.
+	CrawledAt time.Time
+	// RevalidatedAt is when the cached entry was last confirmed unchanged
+	// by a conditional request, or zero if it never was.
+	RevalidatedAt time.Time
+	// RedirectChain is the redirect chain recorded with WithRedirectChains;
+	// see CacheEntry.RedirectChain.
+	RedirectChain []string
+	// Meta is the metadata stored with the entry; see RequestOptions.Meta.
+	Meta map[string]string
+	// TTL is how long the body stays fresh: the lifetime of the entry a
+	// fetch has just stored or revalidated, after per-call TTLs, WithTTLFunc,
+	// MaxTTL, ServerClock and GlobalMaxAge are applied, or the lifetime left
+	// to a fresh entry served from the cache. It is zero when nothing was
+	// stored and for stale bodies.
+	TTL time.Duration
 }
 
+// StaleReason explains why an expired entry was served.
+type StaleReason string
+
+const (
+	// StaleOnError means the fetch that should have replaced the entry
+	// failed (NetworkFirst).
+	StaleOnError StaleReason = "fetch failed"
+	// StaleRefreshing means the entry is being refreshed in the background
+	// (WithRefreshOnExpiry).
+	StaleRefreshing StaleReason = "refreshing"
+)
+
 var (
-	instance *HTTPClient
-	once     sync.Once
+	instanceMu sync.Mutex // guards instance and once
+	instance   *HTTPClient
+	once       sync.Once
 )
 
+// LoadPoliciesFromFile reads cache policies from filename as ParsePolicies
+// does. A file compressed with gzip, recognized by its .gz extension or its
+// magic bytes, is decompressed first. A missing file yields just the
+// catch-all policy ParsePolicies appends. filename may also name a directory
+// of policy fragments, such as one per team: its *.txt files are then read
+// in sorted filename order and parsed as one file, which gets a single
+// catch-all policy.
 func LoadPoliciesFromFile(filename string) ([]CachePolicy, error) {
-	defaultPolicy := CachePolicy{
-		Pattern: regexp.MustCompile(".*"),
-		TTL:     10 * time.Minute,
-	}
-
 	if filename == "" {
-		return []CachePolicy{defaultPolicy}, nil
+		return []CachePolicy{catchAllPolicy()}, nil
+	}
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		return loadPolicyFragments(filename)
 	}
 
 	file, err := os.Open(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return []CachePolicy{defaultPolicy}, nil
+			return []CachePolicy{catchAllPolicy()}, nil
 		}
 		return nil, fmt.Errorf("failed to open policies file: %v", err)
 	}
 	defer file.Close()
 
-	policies := []CachePolicy{}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// Skip empty lines and comment-only lines
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Remove inline comments
-		if idx := strings.Index(line, "#"); idx != -1 {
-			line = strings.TrimSpace(line[:idx])
-		}
-
-		// Split on last = character
-		idx := strings.LastIndex(line, "=")
-		if idx == -1 {
-			return nil, fmt.Errorf("invalid policy format: %s", line)
-		}
-		pattern := strings.TrimSpace(line[:idx])
-		duration := strings.TrimSpace(line[idx+1:])
-
-		// Compile pattern
-		compiledPattern, err := regexp.Compile(pattern)
+	r := bufio.NewReader(file)
+	if magic, _ := r.Peek(2); strings.HasSuffix(filename, ".gz") || bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(r)
 		if err != nil {
-			return nil, fmt.Errorf("invalid regex pattern: %s", err)
+			return nil, fmt.Errorf("failed to decompress policies file: %v", err)
 		}
+		defer zr.Close()
+		return ParsePolicies(zr)
+	}
+	return ParsePolicies(r)
+}
 
-		// Parse duration
-		parsedDuration, err := time.ParseDuration(duration)
+// loadPolicyFragments parses the *.txt files in dir, in sorted order, as one
+// policies file.
+func loadPolicyFragments(dir string) ([]CachePolicy, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var all bytes.Buffer
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("invalid duration: %s", err)
+			return nil, fmt.Errorf("failed to read policies fragment: %v", err)
 		}
-
-		policies = append(policies, CachePolicy{
-			Pattern: compiledPattern,
-			TTL:     parsedDuration,
-		})
+		all.Write(data)
+		all.WriteByte('\n')
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading policies file: %v", err)
-	}
-
-	policies = append(policies, defaultPolicy)
-	return policies, nil
+	return ParsePolicies(&all)
 }
 
+// GetClient returns the process-wide client, creating it on the first call
+// from the cache directory and policies file set through RegisterFlags or
+// the HTTPCACHE_DIR and HTTPCACHE_POLICIES environment variables.
 func GetClient() *HTTPClient {
+	instanceMu.Lock()
+	defer instanceMu.Unlock()
 	once.Do(func() {
-		policies, err := LoadPoliciesFromFile(*policiesFile)
+		dir, policiesPath := clientConfig()
+		policies, err := LoadPoliciesFromFile(policiesPath)
 		if err != nil {
 			log.Fatalf("Failed to load cache policies: %v", err)
 		}
 
-		store, err := store.NewLevelStore(*cacheDir + "/data")
-		if err != nil {
+		hc := newHTTPClient(policies)
+		if err := hc.openStore(dir); err != nil {
 			log.Fatalf("Failed to initialize cache: %v", err)
 		}
-		instance = &HTTPClient{
-			cache: &Cache{
-				Store:    store,
-				Policies: policies,
-			},
-			client: &http.Client{},
-		}
+		instance = hc
 	})
 
 	if instance == nil {
@@ -146,14 +488,39 @@ func GetClient() *HTTPClient {
 }
 
 func (c *Cache) GetTTL(url string) time.Duration {
-	for _, policy := range c.Policies {
-		if policy.Pattern.MatchString(url) {
-			return policy.TTL
-		}
+	if policy := c.Policy(url); policy != nil {
+		return c.clampTTL(policy.TTL)
 	}
 	return 0
 }
 
+// clampTTL caps ttl at MaxTTL, if one is set.
+func (c *Cache) clampTTL(ttl time.Duration) time.Duration {
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		return c.MaxTTL
+	}
+	return ttl
+}
+
+// Policy returns the first policy matching url, or nil if none does.
+func (c *Cache) Policy(url string) *CachePolicy {
+	for i := range c.Policies {
+		if c.Policies[i].Pattern.MatchString(url) {
+			return &c.Policies[i]
+		}
+	}
+	return nil
+}
+
+// reservedKeyPrefix marks store keys used internally rather than for cache
+// entries. Entry keys are hex or base64url digests and never start with it.
+const reservedKeyPrefix = "\x00httpcache/"
+
+// HashKey is the default key function: the hex SHA-256 digest of url.
+func HashKey(url string) string {
+	return hashKey(url)
+}
+
 func hashKey(url string) string {
 	hash := sha256.Sum256([]byte(url))
 	return hex.EncodeToString(hash[:])
@@ -161,214 +528,621 @@ func hashKey(url string) string {
 
 type ContentValidator func([]byte) bool
 
+// URLValidator decides whether a body is acceptable given the final URL it
+// was served from, after any redirects.
+type URLValidator func(body []byte, finalURL string) bool
+
 func (hc *HTTPClient) GetWithValidator(url string, validator ContentValidator) ([]byte, string, error) {
-	key := hashKey(url)
+	r, err := hc.Do(url, &RequestOptions{Validator: validator})
+	return r.Data, r.FinalURL, err
+}
 
-	ttl := hc.cache.GetTTL(url)
-	if ttl > 0 {
-		if value, finalURL, found := hc.cache.Get(key); found {
-			if validator == nil || validator(value) {
-				return value, finalURL, nil
-			}
-			// invalid cache, delete it
-			_ = hc.cache.Delete(key)
-		}
+// GetWithURLValidator is like GetWithValidator for validators that need the
+// final URL, such as ones refusing responses redirected off the expected
+// host. Rejected bodies are still returned but not cached.
+func (hc *HTTPClient) GetWithURLValidator(url string, validator URLValidator) ([]byte, string, error) {
+	r, err := hc.Do(url, &RequestOptions{URLValidator: validator})
+	return r.Data, r.FinalURL, err
+}
+
+// GetWithInfo is like Get but also reports the status code, headers and final
+// URL of the response the body came from, and whether it was served from cache.
+func (hc *HTTPClient) GetWithInfo(url string) ([]byte, *FetchInfo, error) {
+	r, err := hc.Do(url, nil)
+	return r.Data, &r.FetchInfo, err
+}
+
+// logSlowFetch logs a fetch of url that took longer than the
+// WithSlowFetchThreshold threshold, with the status it got, or 0 if it got
+// no response.
+func (hc *HTTPClient) logSlowFetch(url string, entry *CacheEntry, elapsed time.Duration) {
+	if hc.slowFetchThreshold > 0 && elapsed > hc.slowFetchThreshold {
+		hc.cache.logf("Slow fetch of %s: %v, status %d", url, elapsed.Round(time.Millisecond), entry.StatusCode)
 	}
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, "", err
+// fetchOnce performs req, a live request for url, once. On failure the
+// returned entry is still non-nil and carries whatever is known about the
+// response so far.
+func (hc *HTTPClient) fetchOnce(url string, req *http.Request, maxBytes int64) (*CacheEntry, error) {
+	entry := &CacheEntry{URL: url}
+
+	limiter := hc.policyLimiter(hc.cache.Policy(url))
+	if err := limiter.acquire(req.Context(), hc.failFastWhenBusy); err != nil {
+		return entry, err
+	}
+	defer limiter.release()
+	if err := hc.acquireFetch(req.Context()); err != nil {
+		return entry, err
 	}
-	req.Header.Set("User-Agent", useragent.UserAgents[0].String())
+	defer hc.releaseFetch()
+	start := time.Now()
+	defer hc.observeFetch(start)
+	defer func() { hc.accessLog.log(req, entry, time.Since(start), false) }()
+	defer func() { hc.logSlowFetch(url, entry, time.Since(start)) }()
+
 	resp, err := hc.client.Do(req)
 	if err != nil {
-		return nil, "", err
+		return entry, err
 	}
 	defer resp.Body.Close()
+	hc.cache.observeDate(resp.Request.URL.Host, resp.Header, time.Now())
 
-	finalURL := resp.Request.URL.String()
+	entry.FinalURL = resp.Request.URL.String()
+	entry.StatusCode = resp.StatusCode
+	entry.proto = resp.Proto
+	entry.tls = resp.TLS
+	entry.Header = resp.Header
+	if hc.redirectChains {
+		entry.RedirectChain = redirectChain(resp)
+	}
+	if !hc.followRedirects {
+		// Not following redirects: the redirect target is the final URL.
+		if location, err := resp.Location(); err == nil {
+			entry.FinalURL = location.String()
+		}
+	}
 
-	body, err := io.ReadAll(resp.Body)
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return entry, ErrBodyTooLarge
+	}
+	// A body the transport decompressed may be far larger than the bytes
+	// sent, so it is cut off at the decompression limit as well.
+	var decompressedLimit int64
+	if resp.Uncompressed {
+		decompressedLimit = hc.maxDecompressed
+	}
+	readLimit := maxBytes
+	if decompressedLimit > 0 && (readLimit <= 0 || decompressedLimit < readLimit) {
+		readLimit = decompressedLimit
+	}
+	var r io.Reader = resp.Body
+	if readLimit > 0 {
+		r = io.LimitReader(resp.Body, readLimit+1)
+	}
+	body, err := io.ReadAll(r)
+	entry.elapsed = time.Since(start)
+	if want := resp.ContentLength; want > int64(len(body)) && req.Method != http.MethodHead &&
+		(err == nil || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return entry, &ShortBodyError{URL: url, Got: int64(len(body)), Want: want}
+	}
 	if err != nil {
-		return nil, finalURL, err
+		return entry, err
 	}
+	hc.debugDump.write(hc.cache, url, req, resp, body)
+	if decompressedLimit > 0 && int64(len(body)) > decompressedLimit {
+		return entry, &DecompressionLimitError{URL: url, Limit: decompressedLimit}
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return entry, ErrBodyTooLarge
+	}
+	entry.Data = body
+	if hc.rawEncoding && !resp.Uncompressed && resp.Header.Get("Content-Encoding") == "gzip" && req.Method != http.MethodHead {
+		if err := decodeRaw(url, entry, body, hc.maxDecompressed); err != nil {
+			return entry, err
+		}
+		body = entry.Data
+	}
+	if contentType := resp.Header.Get("Content-Type"); isText(contentType) {
+		entry.Charset = detectCharset(body, contentType)
+	}
+
+	return entry, nil
+}
 
-	shouldCache := true
-	if validator != nil {
-		shouldCache = validator(body)
+// redirectChain returns the URLs of the requests that led to resp, oldest
+// first, leaving out the request resp answers. Each request after a redirect
+// carries the response that caused it.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		chain = append(chain, req.Response.Request.URL.String())
 	}
+	slices.Reverse(chain)
+	return chain
+}
 
-	if shouldCache && ttl > 0 {
-		hc.cache.Set(key, body, url, finalURL, ttl)
+// newRequest builds a request for url. Headers are layered from the built-in
+// User-Agent, the client's default headers, the headers of the policy
+// matching url and finally the per-call header, each overriding the last.
+func (hc *HTTPClient) newRequest(ctx context.Context, method, url string, header http.Header, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, &InvalidURLError{URL: url, Err: err}
 	}
+	req.Header.Set("User-Agent", hc.userAgents.agent(req.URL.Host))
+	mergeHeader(req.Header, hc.header)
+	if policy := hc.cache.Policy(url); policy != nil {
+		mergeHeader(req.Header, policy.Header)
+	}
+	hc.setAuth(req)
+	mergeHeader(req.Header, header)
+	hc.setRawEncoding(req)
+	return req, nil
+}
+
+// mergeHeader copies src into dst, replacing any values dst has for the same
+// keys.
+func mergeHeader(dst, src http.Header) {
+	for key, values := range src {
+		dst[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+}
 
-	return body, finalURL, nil
+// setReferer sets the Referer of req to referer, or with WithAutoReferer to
+// the last URL fetched if req has none yet.
+func (hc *HTTPClient) setReferer(req *http.Request, referer string) {
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+		return
+	}
+	if hc.autoReferer && req.Header.Get("Referer") == "" {
+		if last, _ := hc.lastURL.Load().(string); last != "" {
+			req.Header.Set("Referer", last)
+		}
+	}
+}
+
+func (e *CacheEntry) result(fromCache bool) *Result {
+	return &Result{
+		Data: e.Data,
+		FetchInfo: FetchInfo{
+			FinalURL:   e.FinalURL,
+			StatusCode: e.StatusCode,
+			Header:     e.Header,
+			FromCache:  fromCache,
+			Proto:      e.proto,
+			TLS:        e.tls,
+			Charset:    e.Charset,
+			Class:      e.Class,
+			CrawledAt:  e.CrawledAt,
+
+			RevalidatedAt: e.RevalidatedAt,
+			RedirectChain: e.RedirectChain,
+			Meta:          e.Meta,
+		},
+	}
+}
+
+// staleResult is like result for an expired entry served from the cache for
+// reason. With WithStaleWarning, the headers gain the RFC 7234 warnings for
+// stale responses.
+func (hc *HTTPClient) staleResult(e *CacheEntry, reason StaleReason) *Result {
+	r := e.result(true)
+	r.Stale = true
+	r.StaleReason = reason
+	if hc.staleWarning {
+		r.Header = r.Header.Clone()
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+		r.Header.Add("Warning", `110 - "Response is Stale"`)
+		if reason == StaleOnError {
+			r.Header.Add("Warning", `111 - "Revalidation Failed"`)
+		}
+	}
+	return r
 }
 
 func (hc *HTTPClient) Get(url string) ([]byte, error) {
-	data, _, err := hc.GetWithValidator(url, nil)
-	return data, err
+	r, err := hc.Do(url, nil)
+	return r.Data, err
 }
 
 func (hc *HTTPClient) GetWithFinalURL(url string) ([]byte, string, error) {
-	return hc.GetWithValidator(url, nil)
+	r, err := hc.Do(url, nil)
+	return r.Data, r.FinalURL, err
 }
 
+// Get returns the fresh body and final URL stored under key. The body is
+// decoded anew on every call and belongs to the caller, who may modify it
+// without affecting the cache.
 func (c *Cache) Get(key string) ([]byte, string, bool) {
+	entry, found := c.GetEntry(key)
+	if !found {
+		return nil, "", false
+	}
+	return entry.Data, entry.FinalURL, true
+}
+
+// GetEntry returns the full cache entry stored under key, or false if there is
+// no fresh entry. Expired entries are deleted once past DeleteGracePeriod.
+func (c *Cache) GetEntry(key string) (*CacheEntry, bool) {
+	entry, expired := c.lookup(key)
+	if entry == nil {
+		return nil, false
+	}
+	if expired {
+		c.deleteExpired(key, entry)
+		return nil, false
+	}
+	return entry, true
+}
+
+// freshFor returns how long entry stays fresh after now, or zero if it does
+// not.
+func (c *Cache) freshFor(entry *CacheEntry, now time.Time) time.Duration {
+	return max(c.expiresAt(entry).Sub(now), 0)
+}
+
+// deleteExpired deletes entry, found expired under key, unless it is still
+// within DeleteGracePeriod or NoLazyDelete is set.
+func (c *Cache) deleteExpired(key string, entry *CacheEntry) {
+	if c.NoLazyDelete || c.inGracePeriod(entry, time.Now()) {
+		return
+	}
+	_ = c.Delete(key)
+}
+
+// inGracePeriod reports whether entry, which has expired, expired less than
+// DeleteGracePeriod before now.
+func (c *Cache) inGracePeriod(entry *CacheEntry, now time.Time) bool {
+	return c.DeleteGracePeriod > 0 && now.Sub(c.expiresAt(entry)) < c.DeleteGracePeriod
+}
+
+// lookup returns the entry stored under key, fresh or not, and whether it has
+// expired. It returns nil if there is no readable entry. Unlike GetEntry it
+// never deletes anything.
+func (c *Cache) lookup(key string) (*CacheEntry, bool) {
 	value, err := c.Store.Get(key)
 	if err != nil || value == nil {
-		return nil, "", false
+		return nil, false
 	}
 
-	var entry CacheEntry
-	if err := store.BytesToObject(value, &entry); err != nil {
-		return nil, "", false
+	entry, err := c.decodeEntry(value)
+	if err != nil {
+		return nil, false
+	}
+	return entry, c.isExpired(entry, time.Now())
+}
+
+// isExpired reports whether entry is no longer fresh at now.
+func (c *Cache) isExpired(entry *CacheEntry, now time.Time) bool {
+	if c.ExpiryFunc != nil {
+		return c.ExpiryFunc(*entry) || c.pastMaxAge(entry, now)
 	}
+	return now.After(c.expiresAt(entry))
+}
 
-	// Check if entry has expired
-	now := time.Now()
-	isExpired := false
+// pastMaxAge reports whether entry is older than GlobalMaxAge at now.
+func (c *Cache) pastMaxAge(entry *CacheEntry, now time.Time) bool {
+	return c.GlobalMaxAge > 0 && !entry.CrawledAt.IsZero() && now.Sub(entry.CrawledAt) > c.GlobalMaxAge
+}
 
-	// If CrawledAt is set (not zero time), use it with the matching policy TTL
+// expiresAt returns the time entry stops being fresh, GlobalMaxAge included.
+func (c *Cache) expiresAt(entry *CacheEntry) time.Time {
+	t := c.ttlExpiresAt(entry)
+	if c.GlobalMaxAge > 0 && !entry.CrawledAt.IsZero() {
+		if bound := entry.CrawledAt.Add(c.GlobalMaxAge); bound.Before(t) {
+			return bound
+		}
+	}
+	return t
+}
+
+// ttlExpiresAt returns the time entry stops being fresh under its TTL.
+func (c *Cache) ttlExpiresAt(entry *CacheEntry) time.Time {
+	// If CrawledAt is set (not zero time), count the matching policy TTL
+	// from it, or from the last revalidation if there was one.
 	if !entry.CrawledAt.IsZero() {
 		ttl := c.GetTTL(entry.URL)
-		if now.Sub(entry.CrawledAt) > ttl {
-			isExpired = true
+		if entry.TTL > 0 {
+			ttl = c.clampTTL(entry.TTL)
+		}
+		if c.ServerClock {
+			if t, ok := c.serverExpiresAt(entry, ttl); ok {
+				return t
+			}
 		}
-	} else {
-		// Backward compatibility: use ExpiresAt for older entries
-		isExpired = now.After(entry.ExpiresAt)
+		return entry.freshFrom().Add(ttl)
 	}
+	// Backward compatibility: use ExpiresAt for older entries
+	return entry.ExpiresAt
+}
 
-	if isExpired {
-		_ = c.Store.Delete(key)
-		return nil, "", false
+// freshFrom returns the time entry's freshness is counted from.
+func (e *CacheEntry) freshFrom() time.Time {
+	if e.RevalidatedAt.After(e.CrawledAt) {
+		return e.RevalidatedAt
 	}
+	return e.CrawledAt
+}
 
-	return entry.Data, entry.FinalURL, true
+// decodeEntry turns a stored value back into the entry that was set,
+// resolving deduplicated content and decoding the body.
+func (c *Cache) decodeEntry(value []byte) (*CacheEntry, error) {
+	var entry CacheEntry
+	if err := Unmarshal(value, &entry); err != nil {
+		return nil, err
+	}
+	if err := c.decodeBody(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// decodeBody turns the body of entry, as stored, back into the body that
+// was set.
+func (c *Cache) decodeBody(entry *CacheEntry) error {
+	if err := c.resolveContent(entry); err != nil {
+		return err
+	}
+	if err := decodeContent(entry); err != nil {
+		return err
+	}
+	return decodeEncoding(entry)
 }
 
 func (c *Cache) Set(key string, data []byte, url string, finalURL string, ttl time.Duration) {
+	c.SetEntry(key, &CacheEntry{
+		Data:     data,
+		URL:      url,
+		FinalURL: finalURL,
+	}, ttl)
+}
+
+// SetEntry stores entry under key, stamping its crawl and expiry times.
+// A failed write is logged.
+func (c *Cache) SetEntry(key string, entry *CacheEntry, ttl time.Duration) {
+	if err := c.storeEntry(key, entry, ttl); err != nil {
+		c.logf("Failed to store cache entry: %v", err)
+	}
+}
+
+// storeEntry is SetEntry, returning the error of a failed write.
+func (c *Cache) storeEntry(key string, entry *CacheEntry, ttl time.Duration) error {
 	now := time.Now()
-	entry := CacheEntry{
-		Data:      data,
-		URL:       url,
-		FinalURL:  finalURL,
-		CrawledAt: now,
-		ExpiresAt: now.Add(ttl),
+	entry.CrawledAt = now
+	entry.ExpiresAt = now.Add(c.clampTTL(ttl))
+	return c.writeEntry(key, entry)
+}
+
+// writeEntry stores entry under key as it is, encoding and deduplicating
+// its body as the cache is configured to.
+func (c *Cache) writeEntry(key string, entry *CacheEntry) error {
+	stored := c.encodeBody(entry)
+	if c.Dedup {
+		err := c.setDeduped(key, &stored)
+		entry.ContentHash = stored.ContentHash
+		return err
 	}
+	return c.putEntry(key, &stored)
+}
 
-	encoded, err := store.ObjectToBytes(entry)
-	if err != nil {
-		log.Printf("Failed to encode cache entry: %v", err)
-		return
+// encodeBody returns a copy of entry with its body encoded by the cache's
+// codec, if it has one. A body kept as received (see WithRawEncoding) is
+// stored in that form.
+func (c *Cache) encodeBody(entry *CacheEntry) CacheEntry {
+	stored := *entry
+	stored.Size = len(entry.Data)
+	if stored.Encoding != "" {
+		if entry.raw != nil {
+			stored.Data = entry.raw
+		} else {
+			stored.Encoding = ""
+		}
 	}
+	if c.Codec != nil && !c.precompressed(&stored) {
+		stored.Data = c.Codec.Encode(stored.Data)
+		stored.Codec = c.Codec.Marker()
+		c.codecIn.Add(int64(len(entry.Data)))
+		c.codecOut.Add(int64(len(stored.Data)))
+	}
+	return stored
+}
 
-	if err := c.Store.Put(key, encoded); err != nil {
-		log.Printf("Failed to store cache entry: %v", err)
+func (c *Cache) putEntry(key string, entry *CacheEntry) error {
+	encoded, err := c.marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding cache entry: %w", err)
 	}
+	return c.Store.Put(key, encoded)
 }
 
+// Close waits for requests in flight and background work to finish, then
+// shuts the client down. See Shutdown.
 func (hc *HTTPClient) Close() {
+	_ = hc.Shutdown(context.Background())
+}
+
+// Shutdown shuts the client down gracefully, for example on SIGTERM: it
+// waits for requests in flight, including their cache writes, and for
+// background refreshes and prefetches to finish, stops the janitor once its
+// current purge is done, saves persistent stats and closes the store. If ctx
+// ends before requests and background work have drained, Shutdown stops
+// waiting, shuts down all the same, and returns an error wrapping ctx's;
+// writes of requests still running are then lost. Requests made once
+// Shutdown has begun fail with ErrClosed, and calling it again does nothing.
+func (hc *HTTPClient) Shutdown(ctx context.Context) error {
+	hc.closeMu.Lock()
+	closing := hc.closing
+	hc.closing = true
+	hc.closeMu.Unlock()
+	if closing {
+		return nil
+	}
+	drained := make(chan struct{})
+	go func() {
+		hc.requestWG.Wait()
+		hc.backgroundWG.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = fmt.Errorf("httpcache: shutdown before requests drained: %w", ctx.Err())
+	}
+	hc.stopJanitor()
+	hc.stopStats()
+	hc.cache.events.close()
 	if err := hc.cache.Store.Close(); err != nil {
-		log.Printf("Failed to close cache: %v", err)
+		hc.cache.logf("Failed to close cache: %v", err)
+	}
+	if hc.tempDir != "" {
+		os.RemoveAll(hc.tempDir)
+	}
+	// Closing the process-wide client lets GetClient create a new one.
+	instanceMu.Lock()
+	if instance == hc {
+		instance = nil
+		once = sync.Once{}
 	}
-	instance = nil
-	once = sync.Once{}
+	instanceMu.Unlock()
+	return err
 }
 
 // NewClient creates a new HTTPClient instance with custom policies and cache directory
-func NewClient(cacheDir string, policies []CachePolicy) (*HTTPClient, error) {
-	if cacheDir == "" {
+func NewClient(cacheDir string, policies []CachePolicy, opts ...Option) (*HTTPClient, error) {
+	hc := newHTTPClient(policies, opts...)
+	if cacheDir == "" && hc.store == nil {
 		return nil, fmt.Errorf("cache directory is required")
 	}
-
-	store, err := store.NewLevelStore(cacheDir + "/data")
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize cache: %+v", err)
+	if err := hc.openStore(cacheDir); err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
 	}
+	hc.loadStats()
+	hc.startJanitor()
+	return hc, nil
+}
 
-	return &HTTPClient{
+func newHTTPClient(policies []CachePolicy, opts ...Option) *HTTPClient {
+	hc := &HTTPClient{
 		cache: &Cache{
-			Store:    store,
 			Policies: policies,
 		},
-		client: &http.Client{},
-	}, nil
-}
-
-func (hc *HTTPClient) Fetch(url string, validator ContentValidator) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+		client:          &http.Client{},
+		keyFunc:         hashKey,
+		followRedirects: true,
+		schemeInKey:     true,
+		cacheableStatus: DefaultCacheableStatus,
 	}
-	req.Header.Set("User-Agent", useragent.UserAgents[0].String())
-	resp, err := hc.client.Do(req)
-	if err != nil {
-		return nil, err
+	for _, opt := range opts {
+		opt(hc)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if max := hc.cache.MaxTTL; max > 0 {
+		for _, policy := range policies {
+			if policy.TTL > max {
+				hc.cache.logf("Policy %s TTL %v exceeds MaxTTL, capping at %v", policy.Pattern, policy.TTL, max)
+			}
+		}
 	}
-
-	ttl := hc.cache.GetTTL(url)
-	if ttl > 0 {
-		shouldCache := true
-		if validator != nil {
-			shouldCache = validator(body)
+	if !hc.followRedirects {
+		hc.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
 		}
+	}
+	hc.client.Transport = hc.newTransport()
+	return hc
+}
 
-		if shouldCache {
-			key := hashKey(url)
-			hc.cache.Set(key, body, url, "", ttl)
+// openStore opens the LevelDB store under cacheDir, unless a store was given
+// with WithStore. If another process holds it and the client was configured
+// to fall back to a temporary cache, one is opened instead. If opening fails
+// otherwise and the client was configured to fall back to pass-through mode,
+// a NopStore is used instead. Either fallback is logged.
+func (hc *HTTPClient) openStore(cacheDir string) error {
+	if hc.store != nil {
+		hc.setStore(hc.store)
+		return nil
+	}
+	db, err := hc.openLevelStore(cacheDir)
+	var locked *LockedError
+	if errors.As(err, &locked) && hc.fallbackToTemp {
+		hc.cache.logf("%v; falling back to a temporary cache", err)
+		db, err = hc.openTempStore()
+	}
+	if err != nil {
+		if !hc.fallbackToPassthrough {
+			return err
 		}
+		hc.cache.logf("Failed to initialize cache, falling back to pass-through mode: %v", err)
+		hc.cache.Store = NopStore{}
+		return nil
 	}
+	hc.setStore(db)
+	return nil
+}
 
-	return body, nil
+// Fetch returns a fresh cached body for url if there is one and validator
+// accepts it, and otherwise fetches url, caching the body if validator
+// accepts it. To always fetch but still cache the result, use Do with
+// NetworkOnly.
+func (hc *HTTPClient) Fetch(url string, validator ContentValidator) ([]byte, error) {
+	r, err := hc.Do(url, &RequestOptions{Validator: validator})
+	return r.Data, err
 }
 
-// Delete removes an entry from the cache
+// Delete removes an entry from the cache. If the entry references deduplicated
+// content, the content's reference count is released as well.
 func (c *Cache) Delete(key string) error {
-	return c.Store.Delete(key)
+	c.refMu.Lock()
+	defer c.refMu.Unlock()
+	if err := c.releaseContent(key); err != nil {
+		return err
+	}
+	if err := c.Store.Delete(key); err != nil {
+		return err
+	}
+	if c.ParsedJSON {
+		if err := c.Store.Delete(parsedKey(key)); err != nil {
+			return err
+		}
+	}
+	c.events.publish(CacheEvent{Kind: EventEvict, Key: key})
+	return nil
 }
 
-// DeleteURL removes the cached entry for the given URL
+// DeleteURL removes the cached entry for the given URL. With a
+// WithKeyFromRequest hook it removes the entry of a plain GET built for url;
+// use DeleteRequest for entries keyed by other request details.
 func (hc *HTTPClient) DeleteURL(url string) error {
-	key := hashKey(url)
+	key := hc.key(url)
 	return hc.cache.Delete(key)
 }
 
+// FetchWithFinalURL always fetches url from the network and caches the body.
 func (hc *HTTPClient) FetchWithFinalURL(url string) ([]byte, string, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, "", err
-	}
-	req.Header.Set("User-Agent", useragent.UserAgents[0].String())
-	resp, err := hc.client.Do(req)
-	if err != nil {
-		return nil, "", err
-	}
-	defer resp.Body.Close()
-
-	finalURL := resp.Request.URL.String()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, finalURL, err
-	}
-
-	ttl := hc.cache.GetTTL(url)
-	if ttl > 0 {
-		key := hashKey(url)
-		hc.cache.Set(key, body, url, finalURL, ttl)
-	}
+	r, err := hc.Do(url, &RequestOptions{Preference: NetworkOnly})
+	return r.Data, r.FinalURL, err
+}
 
-	return body, finalURL, nil
+// Refresh fetches url from the network, ignoring any cached entry, and
+// writes the result to the cache for later readers. It returns the fresh
+// body and final URL. It is the NetworkOnly preference of Do under a name
+// that says why a scheduler would call it.
+func (hc *HTTPClient) Refresh(url string) ([]byte, string, error) {
+	r, err := hc.Do(url, &RequestOptions{Preference: NetworkOnly})
+	return r.Data, r.FinalURL, err
 }
 
+// GetStore returns the underlying LevelDB store, or nil if the cache is backed
+// by a different Store implementation.
 func (hc *HTTPClient) GetStore() *store.LevelStore {
-	return hc.cache.Store
+	s := hc.cache.Store
+	if ts, ok := s.(*timeoutStore); ok {
+		s = ts.Store
+	}
+	db, _ := s.(*store.LevelStore)
+	return db
 }
@@ -1,17 +1,25 @@
 package httpcache
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
 func TestMain(m *testing.M) {
-	*cacheDir = ".httpcache_test"
-	*policiesFile = ".httpcache_test/policies.txt"
+	cacheDir = ".httpcache_test"
+	policiesFile = ".httpcache_test/policies.txt"
 
 	code := m.Run()
 	os.RemoveAll(".httpcache_test")
@@ -196,3 +204,543 @@ func TestHTTPClientRedirect(t *testing.T) {
 		t.Error("Cached response differs from original response")
 	}
 }
+
+func TestNoFollowRedirectCached(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Redirect(w, r, "/target", http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies, WithFollowRedirects(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	wantLocation := server.URL + "/target"
+	for i := 0; i < 2; i++ {
+		_, info, err := client.GetWithInfo(server.URL + "/source")
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i+1, err)
+		}
+		if info.StatusCode != http.StatusMovedPermanently {
+			t.Errorf("request %d: status = %d, want %d", i+1, info.StatusCode, http.StatusMovedPermanently)
+		}
+		if got := info.Header.Get("Location"); got != "/target" {
+			t.Errorf("request %d: Location = %q, want %q", i+1, got, "/target")
+		}
+		if info.FinalURL != wantLocation {
+			t.Errorf("request %d: final URL = %q, want %q", i+1, info.FinalURL, wantLocation)
+		}
+		if info.FromCache != (i == 1) {
+			t.Errorf("request %d: FromCache = %v", i+1, info.FromCache)
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("server hits = %d, want 1", hits)
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		default:
+			w.Write([]byte("end of the chain"))
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithRedirectChains())
+
+	want := []string{server.URL + "/a", server.URL + "/b"}
+	for i := 0; i < 2; i++ {
+		_, info, err := client.GetWithInfo(server.URL + "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(info.RedirectChain, want) || info.FinalURL != server.URL+"/c" {
+			t.Errorf("request %d: chain %q to %s, want %q to /c", i+1, info.RedirectChain, info.FinalURL, want)
+		}
+	}
+	entries, _, err := client.ListEntries("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !slices.Equal(entries[0].RedirectChain, want) {
+		t.Errorf("ListEntries = %+v, want the chain stored", entries)
+	}
+
+	_, info, err := client.GetWithInfo(server.URL + "/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.RedirectChain) != 0 {
+		t.Errorf("unredirected chain = %q, want empty", info.RedirectChain)
+	}
+}
+
+func TestFetchRecordsFinalURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("moved here"))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	if _, err := client.Fetch(server.URL+"/old", nil); err != nil {
+		t.Fatal(err)
+	}
+	_, info, err := client.GetWithInfo(server.URL + "/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.FromCache || info.FinalURL != server.URL+"/new" {
+		t.Errorf("fromCache=%v finalURL=%q, want the redirect target from the entry Fetch wrote", info.FromCache, info.FinalURL)
+	}
+	if _, finalURL, err := client.GetWithFinalURL(server.URL + "/old"); err != nil || finalURL != server.URL+"/new" {
+		t.Errorf("GetWithFinalURL = %q, %v; want %s/new", finalURL, err, server.URL)
+	}
+}
+
+func TestPolicyHeaders(t *testing.T) {
+	received := make(map[string]http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received[r.URL.Path] = r.Header.Clone()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	policies := []CachePolicy{
+		{
+			Pattern: regexp.MustCompile(`/api/`),
+			TTL:     time.Minute,
+			Header:  http.Header{"X-Api-Key": {"secret"}, "Referer": {"https://policy.example/"}},
+		},
+		{Pattern: regexp.MustCompile(".*"), TTL: time.Minute},
+	}
+	client, err := NewClient(t.TempDir(), policies, WithHeader(http.Header{"Referer": {"https://default.example/"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Get(server.URL + "/api/items"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL + "/page"); err != nil {
+		t.Fatal(err)
+	}
+
+	api := received["/api/items"]
+	if got := api.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("matching URL: X-Api-Key = %q, want %q", got, "secret")
+	}
+	if got := api.Get("Referer"); got != "https://policy.example/" {
+		t.Errorf("matching URL: Referer = %q, want policy value", got)
+	}
+
+	page := received["/page"]
+	if got := page.Get("X-Api-Key"); got != "" {
+		t.Errorf("non-matching URL: X-Api-Key = %q, want none", got)
+	}
+	if got := page.Get("Referer"); got != "https://default.example/" {
+		t.Errorf("non-matching URL: Referer = %q, want default value", got)
+	}
+}
+
+func TestMaxTTLClampsPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("test response"))
+	}))
+	defer server.Close()
+
+	logger := &recordingLogger{}
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: 100000 * time.Hour}}
+	client, err := NewClient(t.TempDir(), policies, WithMaxTTL(time.Hour), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if !logger.contains("exceeds MaxTTL") {
+		t.Errorf("expected a warning about the over-cap policy, got %v", logger.messages)
+	}
+	if ttl := client.cache.GetTTL(server.URL); ttl != time.Hour {
+		t.Errorf("GetTTL() = %v, want %v", ttl, time.Hour)
+	}
+
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	entry, found := client.cache.GetEntry(hashKey(server.URL))
+	if !found {
+		t.Fatal("entry was not cached")
+	}
+	if got := entry.ExpiresAt.Sub(entry.CrawledAt); got != time.Hour {
+		t.Errorf("stored lifetime = %v, want %v", got, time.Hour)
+	}
+}
+
+func TestURLValidatorRejectsOffHostRedirect(t *testing.T) {
+	login := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("please log in"))
+	}))
+	defer login.Close()
+	var hits int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/private" {
+			http.Redirect(w, r, login.URL+"/login", http.StatusFound)
+			return
+		}
+		w.Write([]byte("public page"))
+	}))
+	defer origin.Close()
+
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	sameHost := func(body []byte, finalURL string) bool {
+		u, err := url.Parse(finalURL)
+		return err == nil && u.Host == strings.TrimPrefix(origin.URL, "http://")
+	}
+	for i := 0; i < 2; i++ {
+		data, finalURL, err := client.GetWithURLValidator(origin.URL+"/private", sameHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "please log in" || finalURL != login.URL+"/login" {
+			t.Errorf("request %d: data=%q finalURL=%q", i+1, data, finalURL)
+		}
+		if _, _, err := client.GetWithURLValidator(origin.URL+"/public", sameHost); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != 3 {
+		t.Errorf("origin hits = %d, want 3 (the redirect must not be cached)", hits)
+	}
+}
+
+func TestExpiryFunc(t *testing.T) {
+	// Bodies carry the time they stop being valid.
+	validUntil := func(entry CacheEntry) bool {
+		until, err := time.Parse(time.RFC3339Nano, string(entry.Data))
+		return err != nil || time.Now().After(until)
+	}
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Hour}}
+	client, err := NewClient(t.TempDir(), policies, WithExpiryFunc(validUntil), WithCodec(GzipCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	future := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	past := time.Now().Add(-time.Second).Format(time.RFC3339Nano)
+	// The policy TTL would keep both fresh; the ExpiryFunc decides.
+	client.cache.Set("future", []byte(future), "http://example.com/future", "", time.Hour)
+	client.cache.Set("past", []byte(past), "http://example.com/past", "", time.Hour)
+
+	if _, _, ok := client.cache.Get("future"); !ok {
+		t.Error("entry valid for another hour should be fresh")
+	}
+	if _, _, ok := client.cache.Get("past"); ok {
+		t.Error("entry past its content timestamp should be expired")
+	}
+
+	client.cache.Set("past", []byte(past), "http://example.com/past", "", time.Hour)
+	removed, _, err := client.PurgeExpired("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("PurgeExpired removed %d entries, want 1", removed)
+	}
+}
+
+func TestDeleteGracePeriod(t *testing.T) {
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies, WithDeleteGracePeriod(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c := client.cache
+
+	c.SetEntry("key", &CacheEntry{Data: []byte("body"), URL: "http://example.com/", TTL: 20 * time.Millisecond}, time.Minute)
+	time.Sleep(50 * time.Millisecond)
+
+	if _, found := c.GetEntry("key"); found {
+		t.Error("expired entry should be a miss during the grace period")
+	}
+	if entry, _ := c.lookup("key"); entry == nil {
+		t.Fatal("expired entry was deleted during the grace period")
+	}
+	if n, _, _ := c.PurgeExpired("", 0); n != 0 {
+		t.Errorf("PurgeExpired removed %d entries during the grace period", n)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if _, found := c.GetEntry("key"); found {
+		t.Error("expired entry should still be a miss")
+	}
+	if entry, _ := c.lookup("key"); entry != nil {
+		t.Error("entry past the grace period should be deleted on read")
+	}
+}
+
+func TestNoLazyDelete(t *testing.T) {
+	client := newTestClient(t, WithNoLazyDelete())
+	c := client.cache
+	url := "http://example.com/old"
+	key := client.key(url)
+	c.writeEntry(key, &CacheEntry{Data: []byte("captured"), URL: url, CrawledAt: time.Now().Add(-time.Hour)})
+
+	if _, _, found := c.Get(key); found {
+		t.Error("expired entry should be a miss")
+	}
+	if _, err := client.Do(url, &RequestOptions{Preference: CacheOnly}); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("CacheOnly err = %v, want ErrCacheMiss", err)
+	}
+	if entry, expired := c.lookup(key); entry == nil || !expired || string(entry.Data) != "captured" {
+		t.Fatalf("lookup = %v, %v; want the expired entry left in place", entry, expired)
+	}
+	if n, _, _ := c.PurgeExpired("", 0); n != 1 {
+		t.Errorf("PurgeExpired removed %d entries, want 1", n)
+	}
+}
+
+func TestGlobalMaxAge(t *testing.T) {
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: 30 * 24 * time.Hour}}
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c := client.cache
+	old := &CacheEntry{Data: []byte("old"), URL: "http://example.com/old", CrawledAt: time.Now().Add(-8 * 24 * time.Hour)}
+	recent := &CacheEntry{Data: []byte("recent"), URL: "http://example.com/recent", CrawledAt: time.Now().Add(-time.Hour)}
+	c.writeEntry("old", old)
+	c.writeEntry("recent", recent)
+
+	if _, _, found := c.Get("old"); !found {
+		t.Fatal("entry within its policy TTL should be fresh without GlobalMaxAge")
+	}
+
+	c.GlobalMaxAge = 7 * 24 * time.Hour
+	if _, _, found := c.Get("old"); found {
+		t.Error("entry older than GlobalMaxAge should be a miss")
+	}
+	if _, _, found := c.Get("recent"); !found {
+		t.Error("entry younger than GlobalMaxAge should be fresh")
+	}
+
+	c.writeEntry("old", old)
+	c.ExpiryFunc = func(CacheEntry) bool { return false }
+	if n, _, _ := c.PurgeExpired("", 0); n != 1 {
+		t.Errorf("PurgeExpired removed %d entries, want 1 despite ExpiryFunc", n)
+	}
+	if _, _, found := c.Get("recent"); !found {
+		t.Error("PurgeExpired should keep the recent entry")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("slow " + r.URL.Path))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	dir := t.TempDir()
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	open := func() *HTTPClient {
+		client, err := NewClient(dir, policies, WithJanitor(time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	// Shutdown waits for the request in flight and its cache write.
+	client := open()
+	go client.Get(server.URL + "/drained")
+	<-started
+	done := make(chan error)
+	go func() { done <- client.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Requests arriving once Shutdown has begun are turned away.
+	if _, err := client.Do(server.URL+"/late", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("request during Shutdown: %v, want ErrClosed", err)
+	}
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown = %v, want nil", err)
+	}
+	if client.janitor.stop != nil {
+		t.Error("janitor still running after Shutdown")
+	}
+
+	client = open()
+	if _, _, found := client.cache.Get(client.key(server.URL + "/drained")); !found {
+		t.Error("write of the drained request was lost")
+	}
+
+	// A request outlasting the deadline makes Shutdown fail but still shut
+	// down.
+	go client.Get(server.URL + "/stuck")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want a deadline error", err)
+	}
+	if client.janitor.stop != nil {
+		t.Error("janitor still running after a failed Shutdown")
+	}
+}
+
+func TestSlowFetchLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusAccepted)
+		}
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+	logger := &recordingLogger{}
+	client := newTestClient(t, WithLogger(logger), WithSlowFetchThreshold(50*time.Millisecond))
+
+	if _, err := client.Get(server.URL + "/fast"); err != nil {
+		t.Fatal(err)
+	}
+	if logger.contains("Slow fetch") {
+		t.Errorf("fast fetch was logged: %q", logger.messages)
+	}
+	if _, err := client.Get(server.URL + "/slow"); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.contains("Slow fetch of "+server.URL+"/slow") || !logger.contains("status 202") {
+		t.Errorf("slow fetch was not logged with its URL and status: %q", logger.messages)
+	}
+}
+
+func TestReferer(t *testing.T) {
+	var mu sync.Mutex
+	referers := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		referers[r.URL.Path] = r.Header.Get("Referer")
+		mu.Unlock()
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies, WithAutoReferer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	steps := []struct {
+		path, referer, want string
+	}{
+		{"/start", "", ""},
+		{"/auto", "", server.URL + "/start"},
+		{"/explicit", "http://search.example.com/", "http://search.example.com/"},
+		{"/after", "", server.URL + "/explicit"},
+	}
+	for _, step := range steps {
+		if _, err := client.Do(server.URL+step.path, &RequestOptions{Referer: step.referer}); err != nil {
+			t.Fatal(err)
+		}
+		if got := referers[step.path]; got != step.want {
+			t.Errorf("%s: Referer = %q, want %q", step.path, got, step.want)
+		}
+	}
+
+	// The Referer does not split the cache.
+	r, err := client.Do(server.URL+"/auto", &RequestOptions{Referer: "http://elsewhere.example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FromCache {
+		t.Error("a different Referer should still hit the cache")
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	savedDir, savedPolicies := cacheDir, policiesFile
+	defer func() { cacheDir, policiesFile = savedDir, savedPolicies }()
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-cache_dir", "/tmp/custom", "-policies_file", "/tmp/p.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if cacheDir != "/tmp/custom" || policiesFile != "/tmp/p.txt" {
+		t.Errorf("cacheDir, policiesFile = %q, %q after parsing", cacheDir, policiesFile)
+	}
+	if f := fs.Lookup("cache_dir"); f == nil || f.DefValue != savedDir {
+		t.Errorf("cache_dir flag = %+v, want default %q", f, savedDir)
+	}
+	if flag.CommandLine.Lookup("cache_dir") != nil {
+		t.Error("cache_dir registered on the global flag set")
+	}
+}
+
+func TestGetClientEnv(t *testing.T) {
+	savedDir, savedPolicies := cacheDir, policiesFile
+	defer func() { cacheDir, policiesFile = savedDir, savedPolicies }()
+	cacheDir, policiesFile = defaultCacheDir, defaultPoliciesFile
+
+	dir := t.TempDir()
+	policies := filepath.Join(dir, "env-policies.txt")
+	if err := os.WriteFile(policies, []byte("default=never\n/env=42m\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HTTPCACHE_DIR", filepath.Join(dir, "cache"))
+	t.Setenv("HTTPCACHE_POLICIES", policies)
+
+	once = sync.Once{}
+	client := GetClient()
+	defer client.Close()
+	if ttl := client.cache.GetTTL("http://example.com/env"); ttl != 42*time.Minute {
+		t.Errorf("TTL = %v, want the 42m policy from HTTPCACHE_POLICIES", ttl)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache", "data")); err != nil {
+		t.Errorf("store not opened in HTTPCACHE_DIR: %v", err)
+	}
+
+	// An explicitly set flag wins over the environment.
+	cacheDir = "/flag/dir"
+	if got, _ := clientConfig(); got != "/flag/dir" {
+		t.Errorf("clientConfig dir = %q, want the flag value", got)
+	}
+}
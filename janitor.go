@@ -0,0 +1,69 @@
+package httpcache
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// janitor periodically deletes expired entries in the background. Each tick
+// purges one batch and picks up where the previous tick stopped, so a large
+// store is cleaned over several ticks rather than in one long pause.
+type janitor struct {
+	interval  time.Duration
+	jitter    time.Duration
+	batchSize int
+
+	stop chan struct{}
+	done chan struct{}
+	runs atomic.Int64
+}
+
+// startJanitor starts the janitor if the client was configured with one.
+func (hc *HTTPClient) startJanitor() {
+	j := hc.janitor
+	if j == nil || j.interval <= 0 {
+		return
+	}
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	go j.run(hc.cache)
+}
+
+// stopJanitor stops the janitor and waits for a purge in progress to finish.
+func (hc *HTTPClient) stopJanitor() {
+	if j := hc.janitor; j != nil && j.stop != nil {
+		close(j.stop)
+		<-j.done
+		j.stop = nil
+	}
+}
+
+func (j *janitor) run(c *Cache) {
+	defer close(j.done)
+	cursor := ""
+	for {
+		timer := time.NewTimer(j.delay())
+		select {
+		case <-j.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		j.runs.Add(1)
+		_, next, err := c.PurgeExpired(cursor, j.batchSize)
+		if err != nil {
+			c.logf("Janitor failed to purge expired entries: %v", err)
+		}
+		cursor = next
+	}
+}
+
+// delay returns the time until the next tick: the interval plus a random
+// part of the jitter, so that instances started together drift apart.
+func (j *janitor) delay() time.Duration {
+	if j.jitter <= 0 {
+		return j.interval
+	}
+	return j.interval + time.Duration(rand.Int63n(int64(j.jitter)))
+}
@@ -0,0 +1,36 @@
+package httpcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestJanitorBatches(t *testing.T) {
+	client := newTestClient(t, WithJanitor(5*time.Millisecond), WithJanitorJitter(5*time.Millisecond), WithJanitorBatchSize(5))
+	for i := 0; i < 20; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		client.cache.SetEntry(hashKey(url), &CacheEntry{Data: []byte("body"), URL: url, TTL: time.Nanosecond}, time.Minute)
+	}
+	client.cache.Set(hashKey("http://example.com/fresh"), []byte("body"), "http://example.com/fresh", "", time.Minute)
+
+	// Each tick scans at most 5 of the 21 entries, so cleaning up takes a
+	// few of them.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left, _, err := client.ListEntries("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries left after %d ticks", len(left), client.janitor.runs.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if runs := client.janitor.runs.Load(); runs < 4 {
+		t.Errorf("cleaned 20 entries in %d ticks with a batch size of 5", runs)
+	}
+}
@@ -0,0 +1,85 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Parsed JSON
+//
+// GetJSON always caches the raw body, so the entry serves Get as well. With
+// Cache.ParsedJSON set it also stores, under a reserved key next to the
+// entry, a gob encoding of the value it decoded, tagged with the Go type of
+// that value and the hash of the body it came from. A later GetJSON hit into
+// the same type decodes the gob instead of parsing the JSON; a different
+// type or a changed body falls back to parsing and replaces the record.
+// Values gob cannot encode, such as interface{} trees, are simply not kept.
+
+type parsedJSON struct {
+	Type     string
+	BodyHash string
+	Gob      []byte
+}
+
+func parsedKey(key string) string {
+	return reservedKeyPrefix + "json/" + key
+}
+
+// GetJSON gets url like Get and unmarshals the JSON body into v.
+func (hc *HTTPClient) GetJSON(url string, v interface{}) error {
+	r, err := hc.Do(url, nil)
+	if err != nil {
+		return err
+	}
+	if !hc.cache.ParsedJSON {
+		return json.Unmarshal(r.Data, v)
+	}
+
+	key := hc.key(url)
+	typ := fmt.Sprintf("%T", v)
+	hash := contentHash(r.Data)
+	if r.FromCache && hc.cache.loadParsed(key, typ, hash, v) {
+		return nil
+	}
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		return err
+	}
+	if hc.cache.storedEntry(key) != nil {
+		hc.cache.storeParsed(key, typ, hash, v)
+	}
+	return nil
+}
+
+// loadParsed decodes the parsed value stored for key into v and reports
+// whether it could, which requires it to be of type typ and to have come
+// from a body hashing to hash.
+func (c *Cache) loadParsed(key, typ, hash string, v interface{}) bool {
+	value, err := c.Store.Get(parsedKey(key))
+	if err != nil || value == nil {
+		return false
+	}
+	var p parsedJSON
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&p); err != nil {
+		return false
+	}
+	if p.Type != typ || p.BodyHash != hash {
+		return false
+	}
+	return gob.NewDecoder(bytes.NewReader(p.Gob)).Decode(v) == nil
+}
+
+func (c *Cache) storeParsed(key, typ, hash string, v interface{}) {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(v); err != nil {
+		return
+	}
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(parsedJSON{Type: typ, BodyHash: hash, Gob: encoded.Bytes()}); err != nil {
+		return
+	}
+	if err := c.Store.Put(parsedKey(key), value.Bytes()); err != nil {
+		c.logf("Failed to store parsed JSON: %v", err)
+	}
+}
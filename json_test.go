@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+type testProduct struct {
+	Name  string   `json:"name"`
+	Price float64  `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+func TestGetJSON(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"lamp","price":12.5,"tags":["home","light"]}`))
+	}))
+	defer server.Close()
+
+	for _, parsed := range []bool{false, true} {
+		var opts []Option
+		if parsed {
+			opts = append(opts, WithParsedJSON())
+		}
+		client := newTestClient(t, opts...)
+		hits.Store(0)
+		for i := 0; i < 2; i++ {
+			var p testProduct
+			if err := client.GetJSON(server.URL, &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != "lamp" || p.Price != 12.5 || len(p.Tags) != 2 {
+				t.Errorf("parsed %v: call %d decoded %+v", parsed, i+1, p)
+			}
+		}
+		if hits.Load() != 1 {
+			t.Errorf("parsed %v: hits = %d, want the second call served from cache", parsed, hits.Load())
+		}
+		_, err := client.cache.Store.Get(parsedKey(client.key(server.URL)))
+		if (err == nil) != parsed {
+			t.Errorf("parsed %v: reading the parsed record: %v", parsed, err)
+		}
+		if data, err := client.Get(server.URL); err != nil || len(data) == 0 {
+			t.Errorf("parsed %v: raw body not cached: %q, %v", parsed, data, err)
+		}
+	}
+}
+
+func TestGetJSONParsedRecord(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"lamp","price":12.5}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithParsedJSON())
+
+	var p testProduct
+	if err := client.GetJSON(server.URL, &p); err != nil {
+		t.Fatal(err)
+	}
+	// A hit decodes the stored record: replace it to prove the JSON is not
+	// parsed again.
+	key := client.key(server.URL)
+	data, _ := client.Get(server.URL)
+	client.cache.storeParsed(key, "*httpcache.testProduct", contentHash(data), &testProduct{Name: "from gob"})
+	p = testProduct{}
+	if err := client.GetJSON(server.URL, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "from gob" {
+		t.Errorf("hit decoded %+v, want the stored parsed value", p)
+	}
+
+	// Another type misses the record and parses the body.
+	var m struct{ Name string }
+	if err := client.GetJSON(server.URL, &m); err != nil || m.Name != "lamp" {
+		t.Errorf("GetJSON into another type = %+v, %v", m, err)
+	}
+
+	if err := client.DeleteURL(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.cache.Store.Get(parsedKey(key)); err == nil {
+		t.Error("parsed record left behind after DeleteURL")
+	}
+}
@@ -0,0 +1,126 @@
+package httpcache
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyStats summarizes the durations of network fetches.
+type LatencyStats struct {
+	Count         int64
+	Min, Max, Avg time.Duration
+	// Percentiles are approximate: each is accurate to within 1/8 of its
+	// value.
+	P50, P90, P99 time.Duration
+}
+
+// Durations are counted in log-linear buckets: every power of two is split
+// into latencySubBuckets equal parts, in the manner of an HDR histogram with
+// three significant bits. Recording is a handful of atomic operations.
+const (
+	latencySubBits    = 3
+	latencySubBuckets = 1 << latencySubBits
+	latencyBuckets    = (64 - latencySubBits + 1) * latencySubBuckets
+)
+
+type latencyHistogram struct {
+	buckets  [latencyBuckets]atomic.Int64
+	sum      atomic.Int64
+	min, max atomic.Int64
+}
+
+func latencyBucket(v uint64) int {
+	if v < latencySubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - latencySubBits - 1
+	return (shift+1)*latencySubBuckets + int(v>>shift) - latencySubBuckets
+}
+
+// latencyBucketRange returns the smallest and largest values counted in
+// bucket i.
+func latencyBucketRange(i int) (lo, hi uint64) {
+	if i < latencySubBuckets {
+		return uint64(i), uint64(i)
+	}
+	shift := i/latencySubBuckets - 1
+	top := uint64(latencySubBuckets + i%latencySubBuckets)
+	return top << shift, (top+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	v := int64(d)
+	h.buckets[latencyBucket(uint64(v))].Add(1)
+	h.sum.Add(v)
+	// min is stored plus one so that the zero value means unset.
+	for {
+		m := h.min.Load()
+		if m != 0 && m-1 <= v || h.min.CompareAndSwap(m, v+1) {
+			break
+		}
+	}
+	for {
+		m := h.max.Load()
+		if v <= m || h.max.CompareAndSwap(m, v) {
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) reset() {
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+	h.sum.Store(0)
+	h.min.Store(0)
+	h.max.Store(0)
+}
+
+func (h *latencyHistogram) snapshot() LatencyStats {
+	var counts [latencyBuckets]int64
+	var total int64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return LatencyStats{}
+	}
+	s := LatencyStats{
+		Count: total,
+		Min:   time.Duration(h.min.Load() - 1),
+		Max:   time.Duration(h.max.Load()),
+		Avg:   time.Duration(h.sum.Load() / total),
+	}
+	s.P50 = s.percentile(&counts, 0.50)
+	s.P90 = s.percentile(&counts, 0.90)
+	s.P99 = s.percentile(&counts, 0.99)
+	return s
+}
+
+// percentile returns the midpoint of the bucket holding quantile q, kept
+// within the observed minimum and maximum.
+func (s *LatencyStats) percentile(counts *[latencyBuckets]int64, q float64) time.Duration {
+	rank := int64(q*float64(s.Count-1)) + 1
+	var seen int64
+	for i, n := range counts {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		lo, hi := latencyBucketRange(i)
+		d := time.Duration(lo + (hi-lo)/2)
+		if d < s.Min {
+			d = s.Min
+		}
+		if d > s.Max {
+			d = s.Max
+		}
+		return d
+	}
+	return s.Max
+}
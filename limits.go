@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// policyLimiter enforces the Rate and MaxConcurrent of one policy. It is
+// created on the first fetch the policy matches and shared by all later ones.
+type policyLimiter struct {
+	sem      chan struct{}
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next fetch
+}
+
+// policyLimiter returns the limiter of policy, or nil if policy sets no
+// limits.
+func (hc *HTTPClient) policyLimiter(policy *CachePolicy) *policyLimiter {
+	if policy == nil || (policy.Rate <= 0 && policy.MaxConcurrent <= 0) {
+		return nil
+	}
+	if l, ok := hc.policyLimiters.Load(policy); ok {
+		return l.(*policyLimiter)
+	}
+	l := &policyLimiter{}
+	if policy.MaxConcurrent > 0 {
+		l.sem = make(chan struct{}, policy.MaxConcurrent)
+	}
+	if policy.Rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / policy.Rate)
+	}
+	actual, _ := hc.policyLimiters.LoadOrStore(policy, l)
+	return actual.(*policyLimiter)
+}
+
+// acquire waits for a concurrency slot and then for the fetch's turn under
+// the rate, or fails with ErrTooBusy when the slots are taken and failFast
+// is set. A nil limiter never waits.
+func (l *policyLimiter) acquire(ctx context.Context, failFast bool) error {
+	if l == nil {
+		return nil
+	}
+	if l.sem != nil {
+		if failFast {
+			select {
+			case l.sem <- struct{}{}:
+			default:
+				return ErrTooBusy
+			}
+		} else {
+			select {
+			case l.sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if l.interval <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	wait := start.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.release()
+		return ctx.Err()
+	}
+}
+
+func (l *policyLimiter) release() {
+	if l != nil && l.sem != nil {
+		<-l.sem
+	}
+}
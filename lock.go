@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/liuzl/store"
+)
+
+// LockedError is returned by NewClient when another process holds the lock
+// on the cache directory. LevelDB admits a single process per directory.
+type LockedError struct {
+	Dir string
+	Err error
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("httpcache: cache directory %s is locked by another process: %v", e.Dir, e.Err)
+}
+
+func (e *LockedError) Unwrap() error { return e.Err }
+
+// lockRetryInterval is how often a locked store is retried while waiting.
+const lockRetryInterval = 50 * time.Millisecond
+
+// openLevelStore opens the store under cacheDir, waiting up to the client's
+// lock timeout while another process holds it. A held lock is reported as a
+// *LockedError.
+func (hc *HTTPClient) openLevelStore(cacheDir string) (*store.LevelStore, error) {
+	deadline := time.Now().Add(hc.lockWait)
+	for {
+		db, err := store.NewLevelStore(cacheDir + "/data")
+		if err == nil {
+			return db, nil
+		}
+		if !isLockHeld(err) {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Dir: cacheDir, Err: err}
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func isLockHeld(err error) bool {
+	return errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EAGAIN)
+}
+
+// openTempStore opens a store in a new temporary directory, private to this
+// client and removed by Close.
+func (hc *HTTPClient) openTempStore() (*store.LevelStore, error) {
+	dir, err := os.MkdirTemp("", "httpcache-")
+	if err != nil {
+		return nil, err
+	}
+	db, err := store.NewLevelStore(dir + "/data")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	hc.tempDir = dir
+	return db, nil
+}
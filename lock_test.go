@@ -0,0 +1,48 @@
+package httpcache
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCacheDirLocked(t *testing.T) {
+	dir := t.TempDir()
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	first, err := NewClient(dir, policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewClient(dir, policies)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("second NewClient: err = %v, want *LockedError", err)
+	}
+	if locked.Dir != dir {
+		t.Errorf("LockedError.Dir = %q, want %q", locked.Dir, dir)
+	}
+
+	temp, err := NewClient(dir, policies, WithLockFallbackToTemp(), WithLogger(&recordingLogger{}))
+	if err != nil {
+		t.Fatalf("NewClient with temp fallback: %v", err)
+	}
+	temp.cache.Set("key", []byte("body"), "http://example.com/", "", time.Minute)
+	if _, _, ok := temp.cache.Get("key"); !ok {
+		t.Error("temporary cache should store entries")
+	}
+	tempDir := temp.tempDir
+	temp.Close()
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("temporary cache %s not removed: %v", tempDir, err)
+	}
+
+	time.AfterFunc(100*time.Millisecond, first.Close)
+	waited, err := NewClient(dir, policies, WithLockWait(5*time.Second))
+	if err != nil {
+		t.Fatalf("NewClient with lock wait: %v", err)
+	}
+	waited.Close()
+}
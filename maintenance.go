@@ -0,0 +1,566 @@
+package httpcache
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// EntrySummary describes a cache entry without its body.
+type EntrySummary struct {
+	Key        string
+	URL        string
+	FinalURL   string
+	StatusCode int
+	Size       int
+	Class      string
+	CrawledAt  time.Time
+	ExpiresAt  time.Time
+	// RevalidatedAt is the last time a conditional request confirmed the
+	// entry unchanged, or zero.
+	RevalidatedAt time.Time
+	// RedirectChain is the entry's recorded redirect chain, if any.
+	RedirectChain []string
+	// Meta is the metadata stored with the entry, if any.
+	Meta map[string]string
+}
+
+func (e *CacheEntry) summary(key string) EntrySummary {
+	return EntrySummary{
+		Key:        key,
+		URL:        e.URL,
+		FinalURL:   e.FinalURL,
+		StatusCode: e.StatusCode,
+		Size:       len(e.Data),
+		Class:      e.Class,
+		CrawledAt:  e.CrawledAt,
+		ExpiresAt:  e.ExpiresAt,
+
+		RevalidatedAt: e.RevalidatedAt,
+		RedirectChain: e.RedirectChain,
+		Meta:          e.Meta,
+	}
+}
+
+// forEachEntry calls fn for every decodable entry in the store, in key order.
+// Values that fail to decode are skipped. Returning an error from fn stops the
+// iteration and is returned.
+func (c *Cache) forEachEntry(fn func(key string, entry *CacheEntry) error) error {
+	return c.Store.ForEach(nil, func(key, value []byte) (bool, error) {
+		if strings.HasPrefix(string(key), reservedKeyPrefix) {
+			return true, nil
+		}
+		entry, err := c.decodeEntry(value)
+		if err != nil {
+			return true, nil
+		}
+		if err := fn(string(key), entry); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// ForEachWhere calls fn with every entry pred accepts, in key order. A nil
+// pred accepts every entry. Entries are decoded in full, body included,
+// before pred sees them. Iteration stops at the first error returned by fn,
+// which ForEachWhere returns.
+func (c *Cache) ForEachWhere(pred func(CacheEntry) bool, fn func(key string, entry CacheEntry) error) error {
+	return c.forEachEntry(func(key string, entry *CacheEntry) error {
+		if pred != nil && !pred(*entry) {
+			return nil
+		}
+		return fn(key, *entry)
+	})
+}
+
+// ForEachWhere calls fn with every cached entry pred accepts. See
+// Cache.ForEachWhere.
+func (hc *HTTPClient) ForEachWhere(pred func(CacheEntry) bool, fn func(key string, entry CacheEntry) error) error {
+	return hc.cache.ForEachWhere(pred, fn)
+}
+
+// Expired returns a predicate for ForEachWhere accepting entries that are
+// expired now.
+func (c *Cache) Expired() func(CacheEntry) bool {
+	now := time.Now()
+	return func(entry CacheEntry) bool {
+		return c.isExpired(&entry, now)
+	}
+}
+
+// forEachStored is like forEachEntry but hands fn the entries exactly as
+// stored: deduplicated content is not resolved and bodies are not decoded.
+// It is the cheap path for scans that only need entry metadata.
+func (c *Cache) forEachStored(fn func(key string, entry *CacheEntry) error) error {
+	return c.Store.ForEach(nil, func(key, value []byte) (bool, error) {
+		if strings.HasPrefix(string(key), reservedKeyPrefix) {
+			return true, nil
+		}
+		var entry CacheEntry
+		if err := Unmarshal(value, &entry); err != nil {
+			return true, nil
+		}
+		if err := fn(string(key), &entry); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// URLs calls fn with the URL of every cached entry, without loading bodies.
+// Iteration stops at the first error returned by fn, which URLs returns.
+func (c *Cache) URLs(fn func(url string) error) error {
+	return c.forEachStored(func(key string, entry *CacheEntry) error {
+		return fn(entry.URL)
+	})
+}
+
+// URLs calls fn with the URL of every cached entry. See Cache.URLs.
+func (hc *HTTPClient) URLs(fn func(url string) error) error {
+	return hc.cache.URLs(fn)
+}
+
+// SourcesForFinalURL returns the URLs of the entries that were redirected
+// to finalURL, in key order, for seeing how a site canonicalizes its URLs.
+// The entry fetched from finalURL itself is not included. Only entry
+// metadata is read.
+func (c *Cache) SourcesForFinalURL(finalURL string) ([]string, error) {
+	var urls []string
+	err := c.forEachStored(func(key string, entry *CacheEntry) error {
+		if entry.FinalURL == finalURL && entry.URL != finalURL {
+			urls = append(urls, entry.URL)
+		}
+		return nil
+	})
+	return urls, err
+}
+
+// SourcesForFinalURL returns the URLs of the entries that were redirected
+// to finalURL. See Cache.SourcesForFinalURL.
+func (hc *HTTPClient) SourcesForFinalURL(finalURL string) ([]string, error) {
+	return hc.cache.SourcesForFinalURL(finalURL)
+}
+
+// ExpiringSoon returns the URLs of entries that are still fresh but expire
+// within the given window, so they can be refreshed before they go stale.
+// Expiry is judged by TTL as with ExpiryFunc unset; only entry metadata is
+// read.
+func (c *Cache) ExpiringSoon(within time.Duration) ([]string, error) {
+	now := time.Now()
+	var urls []string
+	err := c.forEachStored(func(key string, entry *CacheEntry) error {
+		expires := c.expiresAt(entry)
+		if !now.After(expires) && expires.Sub(now) <= within {
+			urls = append(urls, entry.URL)
+		}
+		return nil
+	})
+	return urls, err
+}
+
+// ExpiringSoon returns the URLs of cached entries that expire within the
+// given window. See Cache.ExpiringSoon.
+func (hc *HTTPClient) ExpiringSoon(within time.Duration) ([]string, error) {
+	return hc.cache.ExpiringSoon(within)
+}
+
+// ErrNoApproxStats is returned by ApproxStats for stores other than LevelDB.
+var ErrNoApproxStats = errors.New("httpcache: store cannot estimate its size")
+
+// approxSample is the number of entries ApproxStats reads to estimate the
+// average entry size.
+const approxSample = 1000
+
+// ApproxStats estimates the number of entries and the bytes they take on
+// disk without scanning the whole store. The size is LevelDB's approximation
+// of the space used by its tables, which leaves out recent writes still held
+// in memory and includes deduplicated content. The entry count is exact for
+// stores of up to 1000 entries; beyond that it is the size divided by the
+// average size of the first 1000 entries, and only a rough guide.
+func (c *Cache) ApproxStats() (entries int64, bytes int64, err error) {
+	db := levelDB(c.Store)
+	if db == nil {
+		return 0, 0, ErrNoApproxStats
+	}
+	sizes, err := db.SizeOf([]util.Range{{Start: nil, Limit: []byte{0xff}}})
+	if err != nil {
+		return 0, 0, err
+	}
+	bytes = sizes.Sum()
+
+	var sampled, sampledBytes int64
+	complete := true
+	err = c.Store.ForEach(nil, func(key, value []byte) (bool, error) {
+		if strings.HasPrefix(string(key), reservedKeyPrefix) {
+			return true, nil
+		}
+		if sampled == approxSample {
+			complete = false
+			return false, nil
+		}
+		sampled++
+		sampledBytes += int64(len(key) + len(value))
+		return true, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if complete || sampledBytes == 0 {
+		return sampled, bytes, nil
+	}
+	return bytes * sampled / sampledBytes, bytes, nil
+}
+
+// ApproxStats estimates the number of cached entries and their size on
+// disk. See Cache.ApproxStats.
+func (hc *HTTPClient) ApproxStats() (entries int64, bytes int64, err error) {
+	return hc.cache.ApproxStats()
+}
+
+// levelDB returns the LevelDB database behind s, or nil if there is none.
+func levelDB(s Store) *leveldb.DB {
+	if ts, ok := s.(*timeoutStore); ok {
+		s = ts.Store
+	}
+	if ls, ok := s.(interface{ DB() *leveldb.DB }); ok {
+		return ls.DB()
+	}
+	return nil
+}
+
+// LargestEntries returns the n entries with the biggest bodies, largest first.
+// Only entry metadata is read: sizes are those recorded when the entries
+// were stored. Entries stored before sizes were recorded are ranked by the
+// length of their body as stored, which is its encoded length under a codec
+// and 0 if it is deduplicated.
+func (c *Cache) LargestEntries(n int) ([]EntrySummary, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	h := &summaryHeap{}
+	err := c.forEachStored(func(key string, entry *CacheEntry) error {
+		summary := entry.summary(key)
+		if entry.Size > 0 {
+			summary.Size = entry.Size
+		}
+		if h.Len() < n {
+			heap.Push(h, summary)
+		} else if summary.Size > (*h)[0].Size {
+			(*h)[0] = summary
+			heap.Fix(h, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]EntrySummary, h.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(h).(EntrySummary)
+	}
+	return result, nil
+}
+
+// LargestEntries returns the n cached entries with the biggest bodies.
+func (hc *HTTPClient) LargestEntries(n int) ([]EntrySummary, error) {
+	return hc.cache.LargestEntries(n)
+}
+
+// summaryHeap is a min-heap of entries ordered by size.
+type summaryHeap []EntrySummary
+
+func (h summaryHeap) Len() int            { return len(h) }
+func (h summaryHeap) Less(i, j int) bool  { return h[i].Size < h[j].Size }
+func (h summaryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *summaryHeap) Push(x interface{}) { *h = append(*h, x.(EntrySummary)) }
+func (h *summaryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// scan calls fn for up to limit entries (all of them if limit is 0), in key
+// order, starting after cursor. It returns the cursor to pass to the next
+// call to continue where this one stopped, or "" once the store has been
+// scanned to the end.
+func (c *Cache) scan(cursor string, limit int, fn func(key string, value []byte) error) (string, error) {
+	var slice *util.Range
+	if cursor != "" {
+		slice = &util.Range{Start: []byte(cursor + "\x00")}
+	}
+	scanned, last, next := 0, "", ""
+	err := c.Store.ForEach(slice, func(key, value []byte) (bool, error) {
+		if strings.HasPrefix(string(key), reservedKeyPrefix) {
+			return true, nil
+		}
+		if limit > 0 && scanned == limit {
+			next = last
+			return false, nil
+		}
+		scanned++
+		last = string(key)
+		return true, fn(last, value)
+	})
+	return next, err
+}
+
+// PurgeExpired deletes expired entries past DeleteGracePeriod among the next
+// limit entries after cursor, or all of them if limit is 0. It returns the
+// number deleted and the cursor to continue from, which is "" once the whole
+// store has been covered, so large stores can be cleaned up in bounded
+// chunks.
+func (c *Cache) PurgeExpired(cursor string, limit int) (int, string, error) {
+	now := time.Now()
+	// An ExpiryFunc may look at the body, which needs decoding.
+	return c.deleteWhere(cursor, limit, c.ExpiryFunc != nil, func(entry *CacheEntry) bool {
+		return c.isExpired(entry, now) && !c.inGracePeriod(entry, now)
+	})
+}
+
+// reevaluateChunk is the number of entries ReevaluatePolicies scans at a time.
+const reevaluateChunk = 1000
+
+// ReevaluatePolicies deletes every entry that is expired under the current
+// Policies, judged from its CrawledAt time, and returns how many it deleted.
+// Reads already treat such entries as expired; after Policies is replaced,
+// for example with shorter TTLs, this brings the store in line at once
+// instead of as each entry is next read. Entries whose URL no policy matches
+// any more count as expired, and DeleteGracePeriod is honored as by
+// PurgeExpired. The store is scanned in chunks, so memory use stays bounded.
+func (c *Cache) ReevaluatePolicies() (expired int, err error) {
+	cursor := ""
+	for {
+		n, next, err := c.PurgeExpired(cursor, reevaluateChunk)
+		expired += n
+		if err != nil || next == "" {
+			return expired, err
+		}
+		cursor = next
+	}
+}
+
+// DeleteMatching deletes entries whose URL matches pattern, scanning in
+// chunks like PurgeExpired.
+func (c *Cache) DeleteMatching(pattern *regexp.Regexp, cursor string, limit int) (int, string, error) {
+	return c.deleteWhere(cursor, limit, false, func(entry *CacheEntry) bool {
+		return pattern.MatchString(entry.URL)
+	})
+}
+
+// deleteWhere deletes the entries match selects among those scanned as by
+// scan. match sees entries as stored unless decode is set. With
+// MaintenanceWorkers above one, entries are decoded and matched, and the
+// selected ones deleted, by that many goroutines.
+func (c *Cache) deleteWhere(cursor string, limit int, decode bool, match func(*CacheEntry) bool) (int, string, error) {
+	selected := func(value []byte) bool {
+		var entry *CacheEntry
+		if decode {
+			entry, _ = c.decodeEntry(value)
+		} else if stored := new(CacheEntry); Unmarshal(value, stored) == nil {
+			entry = stored
+		}
+		return entry != nil && match(entry)
+	}
+	var keys []string
+	var next string
+	var err error
+	if workers := c.MaintenanceWorkers; workers > 1 {
+		keys, next, err = c.selectParallel(cursor, limit, workers, selected)
+	} else {
+		next, err = c.scan(cursor, limit, func(key string, value []byte) error {
+			if selected(value) {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return 0, cursor, err
+	}
+	if n, err := c.deleteKeys(keys); err != nil {
+		return n, cursor, err
+	}
+	return len(keys), next, nil
+}
+
+// selectParallel scans like scan, handing the values to workers goroutines
+// and returning the keys of those selected accepts, in no particular order.
+func (c *Cache) selectParallel(cursor string, limit, workers int, selected func(value []byte) bool) ([]string, string, error) {
+	type item struct {
+		key   string
+		value []byte
+	}
+	items := make(chan item, workers)
+	var mu sync.Mutex
+	var keys []string
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				if selected(it.value) {
+					mu.Lock()
+					keys = append(keys, it.key)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	next, err := c.scan(cursor, limit, func(key string, value []byte) error {
+		// Stores may reuse the value once the callback returns.
+		items <- item{key, bytes.Clone(value)}
+		return nil
+	})
+	close(items)
+	wg.Wait()
+	return keys, next, err
+}
+
+// deleteKeys deletes keys, spread over MaintenanceWorkers goroutines if
+// there are several. It returns the number deleted and the first error.
+func (c *Cache) deleteKeys(keys []string) (int, error) {
+	workers := min(c.MaintenanceWorkers, len(keys))
+	if workers <= 1 {
+		for i, key := range keys {
+			if err := c.Delete(key); err != nil {
+				return i, err
+			}
+		}
+		return len(keys), nil
+	}
+	var deleted atomic.Int64
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < len(keys); i += workers {
+				if err := c.Delete(keys[i]); err != nil {
+					once.Do(func() { firstErr = err })
+					return
+				}
+				deleted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(deleted.Load()), firstErr
+}
+
+// ListEntries summarizes the next limit entries after cursor, or all of them
+// if limit is 0, and returns the cursor to continue from as PurgeExpired does.
+func (c *Cache) ListEntries(cursor string, limit int) ([]EntrySummary, string, error) {
+	var result []EntrySummary
+	next, err := c.scan(cursor, limit, func(key string, value []byte) error {
+		if entry, err := c.decodeEntry(value); err == nil {
+			result = append(result, entry.summary(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, cursor, err
+	}
+	return result, next, nil
+}
+
+// PurgeExpired deletes expired cache entries in bounded chunks. See
+// Cache.PurgeExpired.
+func (hc *HTTPClient) PurgeExpired(cursor string, limit int) (int, string, error) {
+	return hc.cache.PurgeExpired(cursor, limit)
+}
+
+// ReevaluatePolicies deletes the entries expired under the current policies.
+// See Cache.ReevaluatePolicies.
+func (hc *HTTPClient) ReevaluatePolicies() (int, error) {
+	return hc.cache.ReevaluatePolicies()
+}
+
+// DeleteMatching deletes cached entries whose URL matches pattern. See
+// Cache.DeleteMatching.
+func (hc *HTTPClient) DeleteMatching(pattern *regexp.Regexp, cursor string, limit int) (int, string, error) {
+	return hc.cache.DeleteMatching(pattern, cursor, limit)
+}
+
+// ListEntries summarizes cached entries in bounded chunks. See
+// Cache.ListEntries.
+func (hc *HTTPClient) ListEntries(cursor string, limit int) ([]EntrySummary, string, error) {
+	return hc.cache.ListEntries(cursor, limit)
+}
+
+// RevalidateAll runs validator over the body of every cached entry, as after
+// fixing a validation bug, and returns how many entries it checked and how
+// many failed. With refetch, each failing entry of a plain GET is fetched
+// again right away: a body that now passes replaces it and counts as
+// refetched, one that still fails is deleted, and a failed fetch leaves the
+// entry as it was. Entries of other requests, such as POSTs, are only
+// counted. The store is scanned in chunks with RevalidateFrom, and the
+// entries failing in a chunk are dealt with before the next is read.
+func (hc *HTTPClient) RevalidateAll(validator ContentValidator, refetch bool) (checked, failed, refetched int, err error) {
+	cursor := ""
+	for {
+		c, f, r, next, err := hc.RevalidateFrom(cursor, reevaluateChunk, validator, refetch)
+		checked, failed, refetched = checked+c, failed+f, refetched+r
+		if err != nil || next == "" {
+			return checked, failed, refetched, err
+		}
+		cursor = next
+	}
+}
+
+// RevalidateFrom is RevalidateAll over the next limit entries after cursor,
+// or all of them if limit is 0, so the work can be spread over several
+// calls. It returns the counts for those entries and the cursor to continue
+// from, which is "" once the whole store has been covered, as PurgeExpired
+// does; on error the cursor given is returned, and the chunk can be retried.
+func (hc *HTTPClient) RevalidateFrom(cursor string, limit int, validator ContentValidator, refetch bool) (checked, failed, refetched int, next string, err error) {
+	c := hc.cache
+	var failing []*CacheEntry
+	var keys []string
+	next, err = c.scan(cursor, limit, func(key string, value []byte) error {
+		entry, err := c.decodeEntry(value)
+		if err != nil {
+			return nil
+		}
+		checked++
+		if !validator(entry.Data) {
+			failed++
+			failing = append(failing, entry)
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return checked, failed, refetched, cursor, err
+	}
+	for i, entry := range failing {
+		if !refetch || hc.key(entry.URL) != keys[i] {
+			continue
+		}
+		r, err := hc.Do(entry.URL, &RequestOptions{Preference: NetworkOnly, Validator: validator})
+		switch {
+		case err != nil:
+		case validator(r.Data):
+			refetched++
+		default:
+			if err := c.Delete(keys[i]); err != nil {
+				return checked, failed, refetched, cursor, err
+			}
+		}
+	}
+	return checked, failed, refetched, next, nil
+}
@@ -0,0 +1,411 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func newTestClient(t *testing.T, opts ...Option) *HTTPClient {
+	t.Helper()
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestLargestEntries(t *testing.T) {
+	// Sizes come from metadata, not from the stored bodies, which a codec
+	// or deduplication shrinks.
+	for _, opts := range [][]Option{nil, {WithCodec(GzipCodec{}), WithDedup()}} {
+		client := newTestClient(t, opts...)
+
+		sizes := []int{10, 500, 30, 2000, 1}
+		for i, size := range sizes {
+			url := fmt.Sprintf("http://example.com/%d", i)
+			client.cache.Set(hashKey(url), bytes.Repeat([]byte("x"), size), url, url, time.Minute)
+		}
+
+		top, err := client.LargestEntries(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantSizes := []int{2000, 500, 30}
+		if len(top) != len(wantSizes) {
+			t.Fatalf("got %d entries, want %d", len(top), len(wantSizes))
+		}
+		for i, want := range wantSizes {
+			if top[i].Size != want {
+				t.Errorf("entry %d: size = %d, want %d", i, top[i].Size, want)
+			}
+		}
+		if top[0].URL != "http://example.com/3" {
+			t.Errorf("largest entry URL = %q, want %q", top[0].URL, "http://example.com/3")
+		}
+
+		all, err := client.LargestEntries(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(sizes) {
+			t.Errorf("got %d entries, want %d", len(all), len(sizes))
+		}
+	}
+}
+
+func TestURLs(t *testing.T) {
+	client := newTestClient(t, WithDedup(), WithCodec(GzipCodec{}))
+
+	want := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		want[url] = true
+		client.cache.Set(hashKey(url), []byte("body"), url, url, time.Minute)
+	}
+
+	got := map[string]bool{}
+	err := client.URLs(func(url string) error {
+		got[url] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d URLs, want %d: %v", len(got), len(want), got)
+	}
+	for url := range want {
+		if !got[url] {
+			t.Errorf("missing URL %s", url)
+		}
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = client.URLs(func(url string) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("callback error should stop iteration: err=%v, calls=%d", err, n)
+	}
+}
+
+func TestSourcesForFinalURL(t *testing.T) {
+	client := newTestClient(t, WithDedup())
+	final := "https://example.com/canonical"
+	for url, finalURL := range map[string]string{
+		"http://example.com/canonical":  final,
+		"https://example.com/old":       final,
+		"https://www.example.com/":      final,
+		final:                           final,
+		"https://example.com/elsewhere": "https://example.com/other",
+	} {
+		client.cache.Set(hashKey(url), []byte("body"), url, finalURL, time.Minute)
+	}
+
+	got, err := client.SourcesForFinalURL(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{"http://example.com/canonical", "https://example.com/old", "https://www.example.com/"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("sources = %v, want %v", got, want)
+	}
+	if got, _ := client.SourcesForFinalURL("https://example.com/none"); len(got) != 0 {
+		t.Errorf("sources of an unknown URL = %v, want none", got)
+	}
+}
+
+func TestPurgeExpiredWorkers(t *testing.T) {
+	client := newTestClient(t, WithMaintenanceWorkers(4))
+	for i := 0; i < 100; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		entry := &CacheEntry{Data: []byte("body"), URL: url}
+		if i%3 == 0 {
+			entry.TTL = time.Nanosecond
+		}
+		client.cache.SetEntry(hashKey(url), entry, time.Minute)
+	}
+	time.Sleep(time.Millisecond)
+
+	removed, cursor, err := client.PurgeExpired("", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, cursor, err := client.PurgeExpired(cursor, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != "" || removed+more != 34 {
+		t.Errorf("removed %d+%d entries, cursor %q; want 34 and the store covered", removed, more, cursor)
+	}
+	left, _, err := client.ListEntries("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 66 {
+		t.Errorf("%d entries left, want 66", len(left))
+	}
+}
+
+func TestPurgeExpiredInBoundedPasses(t *testing.T) {
+	client := newTestClient(t, WithDedup())
+
+	for i := 0; i < 6; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		entry := &CacheEntry{Data: []byte("body"), URL: url}
+		if i%2 == 0 {
+			entry.TTL = time.Nanosecond
+		}
+		client.cache.SetEntry(hashKey(url), entry, time.Minute)
+	}
+	time.Sleep(time.Millisecond)
+
+	removed, cursor, err := client.PurgeExpired("", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor == "" {
+		t.Fatal("first pass should not cover the whole store")
+	}
+	more, cursor, err := client.PurgeExpired(cursor, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != "" {
+		t.Errorf("second pass should finish the store, cursor = %q", cursor)
+	}
+	if removed+more != 3 {
+		t.Errorf("removed %d+%d entries, want 3", removed, more)
+	}
+
+	left, cursor, err := client.ListEntries("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 3 || cursor != "" {
+		t.Errorf("ListEntries = %d entries, cursor %q; want 3 and none", len(left), cursor)
+	}
+	for _, e := range left {
+		if e.Size != len("body") {
+			t.Errorf("%s: size %d, want %d", e.URL, e.Size, len("body"))
+		}
+	}
+}
+
+func TestDeleteMatching(t *testing.T) {
+	client := newTestClient(t)
+	for _, url := range []string{"http://a.example.com/1", "http://b.example.com/1", "http://a.example.com/2"} {
+		client.cache.Set(hashKey(url), []byte("body"), url, url, time.Minute)
+	}
+
+	n, cursor, err := client.DeleteMatching(regexp.MustCompile(`^http://a\.`), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || cursor != "" {
+		t.Errorf("DeleteMatching = %d, %q; want 2 and no cursor", n, cursor)
+	}
+	if _, _, ok := client.cache.Get(hashKey("http://b.example.com/1")); !ok {
+		t.Error("non-matching entry was deleted")
+	}
+}
+
+func TestExpiringSoon(t *testing.T) {
+	client := newTestClient(t, WithDedup())
+	ttls := map[string]time.Duration{
+		"http://example.com/expired": time.Nanosecond,
+		"http://example.com/seconds": 10 * time.Second,
+		"http://example.com/minute":  time.Minute,
+		"http://example.com/hour":    time.Hour,
+	}
+	for url, ttl := range ttls {
+		client.cache.SetEntry(hashKey(url), &CacheEntry{Data: []byte("body"), URL: url, TTL: ttl}, ttl)
+	}
+	time.Sleep(time.Millisecond)
+
+	urls, err := client.ExpiringSoon(2 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(urls)
+	want := []string{"http://example.com/minute", "http://example.com/seconds"}
+	if len(urls) != len(want) || urls[0] != want[0] || urls[1] != want[1] {
+		t.Errorf("ExpiringSoon = %v, want %v", urls, want)
+	}
+}
+
+func TestForEachWhere(t *testing.T) {
+	client := newTestClient(t)
+	c := client.cache
+	c.Set("a1", []byte("small"), "http://a.example.com/1", "", time.Minute)
+	c.Set("a2", bytes.Repeat([]byte("x"), 1000), "http://a.example.com/2", "", time.Minute)
+	c.Set("b1", bytes.Repeat([]byte("x"), 1000), "http://b.example.com/1", "", time.Minute)
+	c.SetEntry("b2", &CacheEntry{Data: []byte("old"), URL: "http://b.example.com/2", TTL: time.Nanosecond}, time.Minute)
+	time.Sleep(time.Millisecond)
+
+	collect := func(pred func(CacheEntry) bool) []string {
+		var keys []string
+		err := client.ForEachWhere(pred, func(key string, entry CacheEntry) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	tests := []struct {
+		name string
+		pred func(CacheEntry) bool
+		want string
+	}{
+		{"all", nil, "a1 a2 b1 b2"},
+		{"host", func(e CacheEntry) bool { return strings.HasPrefix(e.URL, "http://a.example.com/") }, "a1 a2"},
+		{"size", func(e CacheEntry) bool { return len(e.Data) > 100 }, "a2 b1"},
+		{"expired", c.Expired(), "b2"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(collect(tt.pred), " "); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApproxStats(t *testing.T) {
+	client := newTestClient(t)
+
+	_, before, err := client.ApproxStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := make([]byte, 64<<10)
+	for i := 0; i < 20; i++ {
+		rand.Read(body)
+		url := fmt.Sprintf("http://example.com/%d", i)
+		client.cache.Set(hashKey(url), body, url, url, time.Minute)
+	}
+	// Flush the memtable so the new entries show up in the table sizes.
+	if err := levelDB(client.cache.Store).CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, after, err := client.ApproxStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after < before+20*int64(len(body)) {
+		t.Errorf("approx bytes = %d, want at least %d", after, before+20*int64(len(body)))
+	}
+	if entries != 20 {
+		t.Errorf("approx entries = %d, want 20", entries)
+	}
+}
+
+func TestReevaluatePolicies(t *testing.T) {
+	policies := []CachePolicy{
+		{Pattern: regexp.MustCompile(`/news/`), TTL: time.Hour},
+		{Pattern: regexp.MustCompile(`/docs/`), TTL: time.Hour},
+	}
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	crawled := time.Now().Add(-30 * time.Minute)
+	for _, path := range []string{"/news/1", "/news/2", "/docs/1", "/blog/1"} {
+		url := "http://example.com" + path
+		client.cache.writeEntry(hashKey(url), &CacheEntry{Data: []byte(path), URL: url, CrawledAt: crawled})
+	}
+
+	// Shorten the news TTL below the entries' age; /blog/ has no policy.
+	client.cache.Policies[0].TTL = 10 * time.Minute
+	expired, err := client.ReevaluatePolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 3 {
+		t.Errorf("expired %d entries, want 3", expired)
+	}
+	var left []string
+	client.cache.URLs(func(url string) error {
+		left = append(left, url)
+		return nil
+	})
+	if len(left) != 1 || left[0] != "http://example.com/docs/1" {
+		t.Errorf("left %v, want only the docs entry", left)
+	}
+}
+
+func TestRevalidateAll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.Write([]byte("still broken"))
+			return
+		}
+		w.Write([]byte("fixed " + r.URL.Path))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	bodies := map[string]string{"/good": "fine", "/bad": "error page", "/broken": "error page"}
+	for path, body := range bodies {
+		client.cache.SetEntry(client.key(server.URL+path), &CacheEntry{Data: []byte(body), URL: server.URL + path}, time.Minute)
+	}
+	valid := func(body []byte) bool {
+		return !bytes.Contains(body, []byte("error")) && !bytes.Contains(body, []byte("broken"))
+	}
+
+	checked, failed, refetched, err := client.RevalidateAll(valid, false)
+	if err != nil || checked != 3 || failed != 2 || refetched != 0 {
+		t.Fatalf("RevalidateAll(refetch=false) = %d, %d, %d, %v; want 3, 2, 0", checked, failed, refetched, err)
+	}
+
+	// RevalidateFrom covers the same entries one chunk per call.
+	var calls, chunkChecked, chunkFailed int
+	for cursor := ""; ; calls++ {
+		c, f, _, next, err := client.RevalidateFrom(cursor, 1, valid, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunkChecked, chunkFailed = chunkChecked+c, chunkFailed+f
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if chunkChecked != 3 || chunkFailed != 2 || calls < 2 {
+		t.Errorf("chunked: checked %d, failed %d in %d calls; want 3 and 2 over several calls", chunkChecked, chunkFailed, calls+1)
+	}
+
+	checked, failed, refetched, err = client.RevalidateAll(valid, true)
+	if err != nil || checked != 3 || failed != 2 || refetched != 1 {
+		t.Fatalf("RevalidateAll(refetch=true) = %d, %d, %d, %v; want 3, 2, 1", checked, failed, refetched, err)
+	}
+	if entry, ok := client.cache.GetEntry(client.key(server.URL + "/bad")); !ok || string(entry.Data) != "fixed /bad" {
+		t.Errorf("/bad = %v, want the refetched body", entry)
+	}
+	if _, ok := client.cache.GetEntry(client.key(server.URL + "/broken")); ok {
+		t.Error("/broken still cached after failing again")
+	}
+	if entry, ok := client.cache.GetEntry(client.key(server.URL + "/good")); !ok || string(entry.Data) != "fine" {
+		t.Errorf("/good = %v, want it untouched", entry)
+	}
+}
@@ -0,0 +1,116 @@
+package httpcache
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// MemoryStore is a Store that keeps values in memory, for tests and
+// short-lived crawls that need no persistence. The zero value is ready to use.
+//
+// Put copies the value it is given. Get returns the stored slice itself
+// unless CopyOnRead is set, so a caller that reads the store directly and
+// modifies the result would change the stored value. A Cache decodes every
+// value it reads into a new entry, so bodies returned by the cache are the
+// caller's to modify either way; CopyOnRead guards code that uses the store,
+// or a Codec that returns its input, without that step.
+type MemoryStore struct {
+	// CopyOnRead makes Get and ForEach return copies of stored values.
+	CopyOnRead bool
+
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Get returns the value stored under key, or nil if there is none.
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	value, ok := s.data[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return s.read(value), nil
+}
+
+// Put stores a copy of value under key.
+func (s *MemoryStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		s.data = make(map[string][]byte)
+	}
+	s.data[key] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+// ForEach calls callback for the keys in slice, or all keys if slice is nil,
+// in ascending order. It iterates over a snapshot, so callback may modify the
+// store.
+func (s *MemoryStore) ForEach(slice *util.Range, callback func(key, value []byte) (bool, error)) error {
+	type pair struct {
+		key   string
+		value []byte
+	}
+	s.mu.RLock()
+	pairs := make([]pair, 0, len(s.data))
+	for key, value := range s.data {
+		if slice != nil && !inRange(slice, []byte(key)) {
+			continue
+		}
+		pairs = append(pairs, pair{key, value})
+	}
+	s.mu.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+
+	for _, p := range pairs {
+		cont, err := callback([]byte(p.key), s.read(p.value))
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+	}
+	return nil
+}
+
+// Close releases the stored values.
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = nil
+	return nil
+}
+
+func (s *MemoryStore) read(value []byte) []byte {
+	if s.CopyOnRead {
+		return append([]byte{}, value...)
+	}
+	return value
+}
+
+// inRange reports whether key falls within r, whose Start is inclusive and
+// Limit exclusive; a nil bound is open.
+func inRange(r *util.Range, key []byte) bool {
+	if r.Start != nil && bytes.Compare(key, r.Start) < 0 {
+		return false
+	}
+	return r.Limit == nil || bytes.Compare(key, r.Limit) < 0
+}
@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	value := []byte("b")
+	for _, key := range []string{"c", "a", "b"} {
+		if err := s.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value[0] = 'x'
+	if got, _ := s.Get("b"); string(got) != "b" {
+		t.Errorf("Get = %q after the caller changed the value it put, want %q", got, "b")
+	}
+	if got, err := s.Get("missing"); got != nil || err != nil {
+		t.Errorf("Get(missing) = %q, %v, want nil, nil", got, err)
+	}
+
+	var keys []string
+	err := s.ForEach(&util.Range{Start: []byte("b")}, func(key, _ []byte) (bool, error) {
+		keys = append(keys, string(key))
+		return true, s.Delete(string(key))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Errorf("ForEach visited %q, want [b c]", keys)
+	}
+	if got, _ := s.Get("c"); got != nil {
+		t.Errorf("Get(c) = %q after Delete", got)
+	}
+}
+
+func TestCopyOnRead(t *testing.T) {
+	s := &MemoryStore{CopyOnRead: true}
+	s.Put("k", []byte("value"))
+	got, _ := s.Get("k")
+	got[0] = 'X'
+	if again, _ := s.Get("k"); string(again) != "value" {
+		t.Errorf("stored value changed to %q by mutating a read", again)
+	}
+
+	client := newTestClient(t, WithStore(s))
+	url := "http://example.com/a"
+	client.cache.Set(client.key(url), []byte("body"), url, url, time.Minute)
+	data, _, found := client.cache.Get(client.key(url))
+	if !found {
+		t.Fatal("entry not found")
+	}
+	copy(data, "XXXX")
+	data, _, _ = client.cache.Get(client.key(url))
+	if !bytes.Equal(data, []byte("body")) {
+		t.Errorf("cached body changed to %q by mutating a read", data)
+	}
+}
@@ -0,0 +1,118 @@
+package httpcache
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// indexRule is an index-file equivalence given to WithIndexFiles.
+type indexRule struct {
+	pattern *regexp.Regexp
+	names   []string
+}
+
+// paramFilter is a set of query parameter lists from WithKeyParams or a
+// policy.
+type paramFilter struct {
+	keep   []string
+	ignore []string
+}
+
+func (f paramFilter) empty() bool {
+	return len(f.keep) == 0 && len(f.ignore) == 0
+}
+
+// keeps reports whether the parameter called name enters the key: it must
+// match a keep pattern, if there are any, and no ignore pattern.
+func (f paramFilter) keeps(name string) bool {
+	if len(f.keep) > 0 && !matchesAny(f.keep, name) {
+		return false
+	}
+	return !matchesAny(f.ignore, name)
+}
+
+// matchesAny reports whether name matches one of the path.Match patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// paramFilter returns the query parameter lists that apply to rawURL: those
+// of its policy if it has any, else those of WithKeyParams.
+func (hc *HTTPClient) paramFilter(rawURL string) paramFilter {
+	if policy := hc.cache.Policy(rawURL); policy != nil {
+		if f := (paramFilter{keep: policy.KeyParams, ignore: policy.IgnoreParams}); !f.empty() {
+			return f
+		}
+	}
+	return hc.keyParams
+}
+
+// filterQuery returns rawQuery without the parameters f drops, keeping the
+// order of the rest.
+func filterQuery(rawQuery string, f paramFilter) string {
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if f.keeps(name) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// normalizeKeyURL returns rawURL as it enters the cache key, with query
+// parameters filtered and index files and trailing slashes folded as
+// configured. The request itself still goes to rawURL. URLs that do not
+// parse, or need no change, are returned unchanged.
+func (hc *HTTPClient) normalizeKeyURL(rawURL string) string {
+	params := hc.paramFilter(rawURL)
+	if !hc.foldSlash && len(hc.indexFiles) == 0 && params.empty() {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Opaque != "" {
+		return rawURL
+	}
+	query := u.RawQuery
+	if !params.empty() && query != "" {
+		query = filterQuery(query, params)
+	}
+	path := u.Path
+	for _, rule := range hc.indexFiles {
+		if !rule.pattern.MatchString(rawURL) {
+			continue
+		}
+		dir, file := path[:strings.LastIndex(path, "/")+1], path[strings.LastIndex(path, "/")+1:]
+		for _, name := range rule.names {
+			if file == name {
+				path = dir
+				break
+			}
+		}
+	}
+	if hc.foldSlash {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	if path == u.Path && query == u.RawQuery {
+		return rawURL
+	}
+	if path != u.Path {
+		u.Path, u.RawPath = path, ""
+	}
+	u.RawQuery = query
+	u.ForceQuery = false
+	return u.String()
+}
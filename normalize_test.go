@@ -0,0 +1,107 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNormalizeKeyURL(t *testing.T) {
+	client := newTestClient(t,
+		WithTrailingSlashFolding(),
+		WithIndexFiles(regexp.MustCompile(`^https?://static\.example\.com/`), "index.html", "index.htm"),
+	)
+	tests := []struct{ a, b string }{
+		{"http://example.com/path", "http://example.com/path/"},
+		{"http://example.com", "http://example.com/"},
+		{"http://example.com/a//", "http://example.com/a"},
+		{"http://example.com/q/?x=1", "http://example.com/q?x=1"},
+		{"http://static.example.com/dir/index.html", "http://static.example.com/dir/"},
+		{"http://static.example.com/dir/index.htm", "http://static.example.com/dir"},
+		{"http://static.example.com/index.html", "http://static.example.com/"},
+	}
+	for _, tt := range tests {
+		if client.key(tt.a) != client.key(tt.b) {
+			t.Errorf("%s and %s have different keys", tt.a, tt.b)
+		}
+	}
+
+	distinct := []struct{ a, b string }{
+		// Index files only fold where the pattern says so.
+		{"http://example.com/dir/index.html", "http://example.com/dir/"},
+		{"http://static.example.com/dir/main.html", "http://static.example.com/dir/"},
+		{"http://example.com/path", "http://example.com/path2"},
+	}
+	for _, tt := range distinct {
+		if client.key(tt.a) == client.key(tt.b) {
+			t.Errorf("%s and %s share a key", tt.a, tt.b)
+		}
+	}
+
+	plain := newTestClient(t)
+	if plain.key("http://example.com/path") == plain.key("http://example.com/path/") {
+		t.Error("trailing slashes folded without the option")
+	}
+}
+
+func TestTrailingSlashFoldingServesEntry(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithTrailingSlashFolding())
+
+	if _, err := client.Get(server.URL + "/docs/"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.Do(server.URL+"/docs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FromCache || hits.Load() != 1 {
+		t.Errorf("/docs after /docs/: fromCache = %v, hits = %d", r.FromCache, hits.Load())
+	}
+}
+
+func TestKeyParams(t *testing.T) {
+	policies, err := ParsePolicies(strings.NewReader(`.*/search\?.*=1m key_params:q,page` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, WithKeyParams(nil, []string{"utm_*", "sessionid"}))
+	client.cache.Policies = policies
+
+	same := []struct{ a, b string }{
+		{"http://example.com/item?id=7&utm_source=feed", "http://example.com/item?id=7"},
+		{"http://example.com/item?utm_medium=x&id=7&sessionid=abc", "http://example.com/item?id=7&utm_campaign=y"},
+		{"http://example.com/?utm_source=feed", "http://example.com/"},
+		// The policy keeps only q and page for search URLs.
+		{"http://example.com/search?q=go&page=2&sort=new", "http://example.com/search?q=go&page=2"},
+	}
+	for _, tt := range same {
+		if client.key(tt.a) != client.key(tt.b) {
+			t.Errorf("%s and %s have different keys", tt.a, tt.b)
+		}
+	}
+	distinct := []struct{ a, b string }{
+		{"http://example.com/item?id=7", "http://example.com/item?id=8"},
+		{"http://example.com/item?id=7&sort=new", "http://example.com/item?id=7"},
+		{"http://example.com/search?q=go&page=2", "http://example.com/search?q=go&page=3"},
+	}
+	for _, tt := range distinct {
+		if client.key(tt.a) == client.key(tt.b) {
+			t.Errorf("%s and %s share a key", tt.a, tt.b)
+		}
+	}
+
+	// With both lists, ignore wins where they overlap.
+	f := paramFilter{keep: []string{"id", "utm_*"}, ignore: []string{"utm_*"}}
+	if got := filterQuery("id=1&utm_source=x&other=2", f); got != "id=1" {
+		t.Errorf("filterQuery = %q, want id=1", got)
+	}
+}
@@ -0,0 +1,871 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Option configures an HTTPClient created by NewClient.
+type Option func(*HTTPClient)
+
+// WithFollowRedirects controls whether redirects are followed. When disabled,
+// 3xx responses are returned as-is (status code and headers included), and
+// cached if they have a body (see DefaultCacheableStatus); the final URL
+// reported for them is the resolved Location.
+func WithFollowRedirects(follow bool) Option {
+	return func(hc *HTTPClient) {
+		hc.followRedirects = follow
+	}
+}
+
+// WithRedirectChains records the URLs a fetch was redirected through in
+// CacheEntry.RedirectChain, so redirect maps can be rebuilt from the cache.
+// The chain is stored with the entry and reported in FetchInfo and
+// EntrySummary. It is off by default to keep entries small.
+func WithRedirectChains() Option {
+	return func(hc *HTTPClient) {
+		hc.redirectChains = true
+	}
+}
+
+// WithDedup stores byte-identical bodies once and has entries reference them.
+func WithDedup() Option {
+	return func(hc *HTTPClient) {
+		hc.cache.Dedup = true
+	}
+}
+
+// WithParsedJSON has GetJSON store the values it decodes, gob-encoded, next
+// to the raw bodies, trading space for not parsing hot JSON on every hit.
+func WithParsedJSON() Option {
+	return func(hc *HTTPClient) {
+		hc.cache.ParsedJSON = true
+	}
+}
+
+// WithLogger routes the client's warnings and errors to logger instead of the
+// standard logger.
+func WithLogger(logger Logger) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.Logger = logger
+	}
+}
+
+// WithFallbackToPassthrough makes NewClient return a working client when the
+// store cannot be opened (for example because another process holds the
+// LevelDB lock). Such a client fetches every request and caches nothing; the
+// failure is reported through the logger.
+func WithFallbackToPassthrough() Option {
+	return func(hc *HTTPClient) {
+		hc.fallbackToPassthrough = true
+	}
+}
+
+// WithStore backs the client with s, such as a MemoryStore, instead of a
+// LevelDB store. The cache directory passed to NewClient is then not used and
+// may be empty. The client closes s when it is closed.
+func WithStore(s Store) Option {
+	return func(hc *HTTPClient) {
+		hc.store = s
+	}
+}
+
+// WithStoreOpTimeout bounds each read, write and delete on the store at
+// timeout, keeping request latency bounded when the disk stalls. The price
+// is that a slow store turns into cache misses: a read that times out counts
+// as a miss, and a write that times out is logged and dropped from the
+// caller's point of view (though it may still complete later).
+func WithStoreOpTimeout(timeout time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.storeOpTimeout = timeout
+	}
+}
+
+// WithLockWait makes NewClient wait up to timeout for another process to
+// release the cache directory instead of failing at once.
+func WithLockWait(timeout time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.lockWait = timeout
+	}
+}
+
+// WithLockFallbackToTemp makes NewClient use a private temporary cache,
+// removed by Close, when another process holds the cache directory (after
+// waiting as set by WithLockWait). Nothing is shared with the other process.
+func WithLockFallbackToTemp() Option {
+	return func(hc *HTTPClient) {
+		hc.fallbackToTemp = true
+	}
+}
+
+// WithFallbackAlias makes GetWithFallbacks cache a result fetched from a
+// mirror under the primary URL as well, so later calls are served from the
+// cache without trying the primary again until the entry expires.
+func WithFallbackAlias() Option {
+	return func(hc *HTTPClient) {
+		hc.aliasFallbacks = true
+	}
+}
+
+// WithContentLocation caches a fetched GET response under the URL its
+// Content-Location header names too, so a later request for that canonical
+// URL is a hit. The header is ignored if it points to another scheme or
+// host than the response came from, so one site cannot plant entries for
+// another.
+func WithContentLocation() Option {
+	return func(hc *HTTPClient) {
+		hc.contentLocation = true
+	}
+}
+
+// WithStaleWarning adds the Warning headers RFC 7234 prescribes to the
+// headers reported for stale responses: 110 (Response is Stale) always, and
+// 111 (Revalidation Failed) when a failed fetch is the reason. Stored entries
+// are left as they are.
+func WithStaleWarning() Option {
+	return func(hc *HTTPClient) {
+		hc.staleWarning = true
+	}
+}
+
+// WithHeader adds default headers sent with every request. They override the
+// built-in User-Agent and are overridden by policy headers.
+func WithHeader(header http.Header) Option {
+	return func(hc *HTTPClient) {
+		if hc.header == nil {
+			hc.header = make(http.Header)
+		}
+		mergeHeader(hc.header, header)
+	}
+}
+
+// WithMaxConcurrentFetches caps the number of network fetches running at
+// once across the client. Cache hits are not counted. Callers over the limit
+// wait for a free slot, or get ErrTooBusy if failFast is set.
+func WithMaxConcurrentFetches(n int, failFast bool) Option {
+	return func(hc *HTTPClient) {
+		if n > 0 {
+			hc.fetchSem = make(chan struct{}, n)
+		}
+		hc.failFastWhenBusy = failFast
+	}
+}
+
+// WithRetries retries a failed network fetch up to n more times, waiting
+// delay before the first retry and doubling the wait for each one after,
+// with jitter, up to maxDelay if it is positive; WithBackoff changes how the
+// waits grow. Transport errors, bodies cut short of their
+// Content-Length (see ShortBodyError) and 429 and 5xx responses are retried;
+// the result of the last attempt is the one returned and, if it succeeded,
+// cached.
+func WithRetries(n int, delay, maxDelay time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.retries = n
+		hc.retryDelay = delay
+		hc.maxRetryDelay = maxDelay
+	}
+}
+
+// WithRetryOnEmptyBody treats 200 OK responses with bodies shorter than
+// minBytes, or empty ones if minBytes is 1 or less, as the glitches of a
+// flaky origin: they are retried as WithRetries retries server errors, and
+// never cached, even once the retries run out. Resources that may be
+// legitimately empty or short are exempted by a policy with AllowEmpty set
+// (allow_empty:true in a policies file).
+func WithRetryOnEmptyBody(minBytes int) Option {
+	return func(hc *HTTPClient) {
+		hc.minBodyBytes = max(minBytes, 1)
+	}
+}
+
+// WithBackoff replaces the exponential backoff with jitter used between
+// the retries enabled by WithRetries, whose maxDelay still caps each wait.
+// ConstantBackoff, LinearBackoff and ExponentialBackoff are provided.
+func WithBackoff(b Backoff) Option {
+	return func(hc *HTTPClient) {
+		hc.backoff = b
+	}
+}
+
+// WithDeadLetters puts a URL on a dead-letter list once threshold fetches of
+// it in a row have failed, with a transport error or a 404, 410 or 5xx
+// response, counting each call after its retries as one fetch. For cooldown
+// after each further failure it is not fetched: requests fail at once with a
+// *DeadLetterError, falling back to a cached entry as failed fetches do. A
+// successful fetch takes the URL off the list. DeadLetters lists it and
+// ClearDeadLetters clears it.
+func WithDeadLetters(threshold int, cooldown time.Duration) Option {
+	return func(hc *HTTPClient) {
+		if threshold <= 0 {
+			return
+		}
+		hc.deadLetters = &deadLetterList{
+			threshold: threshold,
+			cooldown:  cooldown,
+			entries:   make(map[string]*deadLetter),
+		}
+	}
+}
+
+// WithUserAgents sends requests with the first of agents, moving on to the
+// next for a host when a fetch from it is blocked, so crawls survive
+// User-Agent based blocking. blocked decides what counts as a block from the
+// status and body of a response, BlockedStatus if nil. A blocked fetch is
+// resent at once with the next agent, trying each at most once, and the
+// agent that worked is remembered for the host; UserAgentFor reports it.
+// The User-Agent never enters the cache key, and a User-Agent set through
+// headers takes precedence over the rotation.
+func WithUserAgents(agents []string, blocked func(status int, body []byte) bool) Option {
+	return func(hc *HTTPClient) {
+		if len(agents) == 0 {
+			return
+		}
+		if blocked == nil {
+			blocked = BlockedStatus
+		}
+		hc.userAgents = &userAgentRotation{
+			agents:  append([]string(nil), agents...),
+			blocked: blocked,
+			current: make(map[string]int),
+		}
+	}
+}
+
+// WithMaxBodyBytes fails fetches whose response body is larger than n bytes
+// with ErrBodyTooLarge, reading no more than n+1 bytes of it. Zero, the
+// default, means no limit. RequestOptions.MaxBytes overrides it per call.
+func WithMaxBodyBytes(n int64) Option {
+	return func(hc *HTTPClient) {
+		hc.maxBodyBytes = n
+	}
+}
+
+// WithMaxDecompressedBytes aborts fetches whose body, as decompressed by the
+// transport, grows past n bytes, with a *DecompressionLimitError. It guards
+// against compression bombs: small gzip responses that expand to gigabytes.
+// The transport decompresses gzip responses to requests that set no
+// Accept-Encoding of their own; WithMaxBodyBytes also bounds decompressed
+// bodies, and this limit lets them be held to less than other bodies. With
+// WithRawEncoding it bounds the bodies decompressed by the client instead.
+func WithMaxDecompressedBytes(n int64) Option {
+	return func(hc *HTTPClient) {
+		hc.maxDecompressed = n
+	}
+}
+
+// WithMaxCacheableDuration leaves responses that took longer than d to
+// fetch, from sending the request to receiving the last byte of the body,
+// out of the cache, on the assumption that a server that slow may have
+// served a degraded or partial page. The body is still returned. Zero, the
+// default, caches responses however long they took.
+func WithMaxCacheableDuration(d time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.maxCacheableDuration = d
+	}
+}
+
+// WithRawEncoding stores gzip-compressed response bodies as the server sent
+// them, rather than decompressed by the transport, and decompresses them
+// when they are read: Get and Do always return the decompressed body, while
+// the cache holds the original bytes. WithMaxBodyBytes then limits the
+// compressed size and validators see the decompressed body. Requests ask for
+// gzip unless they set an Accept-Encoding of their own, which is then sent
+// as given and its responses are stored as they are.
+func WithRawEncoding() Option {
+	return func(hc *HTTPClient) {
+		hc.rawEncoding = true
+	}
+}
+
+// WithCodec encodes stored bodies with codec, e.g. GzipCodec{} to compress
+// them. The codec is registered for decoding as well.
+func WithCodec(codec Codec) Option {
+	return func(hc *HTTPClient) {
+		RegisterCodec(codec)
+		hc.cache.Codec = codec
+	}
+}
+
+// WithPrecompressed decides with fn which bodies are compressed already and
+// are stored without the codec. See Cache.Precompressed.
+func WithPrecompressed(fn func(contentType, url string) bool) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.Precompressed = fn
+	}
+}
+
+// WithSerializer stores entries in the format s writes, e.g.
+// JSONSerializer{} for entries that can be inspected by hand. The serializer
+// is registered for reading as well.
+func WithSerializer(s Serializer) Option {
+	return func(hc *HTTPClient) {
+		if s.Marker() != 0 {
+			RegisterSerializer(s)
+		}
+		hc.cache.Serializer = s
+	}
+}
+
+// WithMaxTTL caps the lifetime of every cache entry at max, guarding against
+// policies that would accidentally keep data for a very long time.
+func WithMaxTTL(max time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.MaxTTL = max
+	}
+}
+
+// WithExpiryFunc lets expired decide whether an entry is expired, for
+// freshness rules a TTL cannot express, such as "until the next market
+// open". Policy TTLs then only choose which URLs are cached.
+func WithExpiryFunc(expired func(entry CacheEntry) bool) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.ExpiryFunc = expired
+	}
+}
+
+// WithDeleteGracePeriod keeps expired entries in the store for grace before
+// deleting them. See Cache.DeleteGracePeriod.
+func WithDeleteGracePeriod(grace time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.DeleteGracePeriod = grace
+	}
+}
+
+// WithGlobalMaxAge expires every entry crawled longer ago than max. See
+// Cache.GlobalMaxAge.
+func WithGlobalMaxAge(max time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.GlobalMaxAge = max
+	}
+}
+
+// WithMaintenanceWorkers scans and deletes with n goroutines during
+// maintenance. See Cache.MaintenanceWorkers.
+func WithMaintenanceWorkers(n int) Option {
+	return func(hc *HTTPClient) {
+		hc.cache.MaintenanceWorkers = n
+	}
+}
+
+// WithNoLazyDelete keeps reads from deleting the expired entries they find.
+// See Cache.NoLazyDelete.
+func WithNoLazyDelete() Option {
+	return func(hc *HTTPClient) {
+		hc.cache.NoLazyDelete = true
+	}
+}
+
+// WithServerClock judges whether entries are fresh by the Date and Age
+// headers of the responses they were stored from rather than by the local
+// time they were stored, so skew between local and server clocks does not
+// expire them early or late. Entries without a Date header are judged by
+// local time.
+func WithServerClock() Option {
+	return func(hc *HTTPClient) {
+		hc.cache.ServerClock = true
+	}
+}
+
+// WithUnixSocket sends requests for host (a hostname, or host:port to match
+// one port only) over the Unix domain socket at path. URLs, and therefore
+// cache keys, keep naming the logical host.
+func WithUnixSocket(host, path string) Option {
+	return func(hc *HTTPClient) {
+		if hc.unixSockets == nil {
+			hc.unixSockets = make(map[string]string)
+		}
+		hc.unixSockets[host] = path
+	}
+}
+
+// WithDialTimeout bounds the time taken to connect to a host, DNS lookup
+// included, so requests to unreachable hosts fail fast and free their fetch
+// slot, while responses from hosts that do answer may take as long as the
+// request's context allows.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.dialTimeout = timeout
+	}
+}
+
+// WithSSRFGuard refuses to connect to internal addresses, failing such
+// requests with a *BlockedAddressError, for services fetching URLs their
+// users supply: loopback, private, link-local (including cloud metadata
+// endpoints), unspecified and multicast addresses are blocked unless one of
+// allow contains them. The address is checked as the connection is made,
+// after DNS resolution, so a host name resolving to an internal address, or
+// re-resolving to one later, is caught, as is a redirect to one. Through a
+// proxy only the proxy's address is checked, as the client never connects
+// to the target itself.
+func WithSSRFGuard(allow ...netip.Prefix) Option {
+	return func(hc *HTTPClient) {
+		hc.ssrfGuard = &ssrfGuard{allow: append([]netip.Prefix(nil), allow...)}
+	}
+}
+
+// WithProxy sends every request through the proxy at proxyURL instead of the
+// proxy named by the environment.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(hc *HTTPClient) {
+		hc.proxy = proxyURL
+	}
+}
+
+// WithProxyAuth authenticates to the proxy with HTTP basic auth. It applies
+// to the proxy set by WithProxy or taken from the environment, for http URLs
+// and for https URLs tunneled with CONNECT alike, and replaces any
+// credentials in the proxy URL. The credentials are never sent to the
+// target server.
+func WithProxyAuth(username, password string) Option {
+	return func(hc *HTTPClient) {
+		hc.proxyUser = url.UserPassword(username, password)
+	}
+}
+
+// WithHeaderOrder sends the request headers named in names first, in that
+// order and written with exactly that casing, so that requests look like
+// those of a particular browser to servers fingerprinting header order.
+// Headers not named follow in Go's usual order. It replaces the transport's
+// normal header handling, so it is off by default and comes with limits:
+// requests are sent over HTTP/1.1 on a new connection each, FetchInfo.TLS is
+// not reported, and https requests tunneled through a proxy keep Go's order.
+func WithHeaderOrder(names ...string) Option {
+	return func(hc *HTTPClient) {
+		hc.headerOrder = append([]string(nil), names...)
+	}
+}
+
+// WithKeyFunc replaces HashKey as the function mapping URLs to store keys.
+// Entries stored under the previous scheme become unreachable; Rekey can
+// migrate them.
+func WithKeyFunc(fn func(url string) string) Option {
+	return func(hc *HTTPClient) {
+		hc.keyFunc = fn
+	}
+}
+
+// WithKeyFromRequest derives store keys with fn, which receives each request
+// fully built, headers included, in place of hashing its key input. It is
+// the most general keying hook: fn can fold specific headers in for some
+// hosts, drop query parameters for others or normalize paths. fn must not
+// read the request body. Without it, keys are the hash of the URL, as
+// WithKeyFunc or WithHasher have it. Remove entries keyed by request details
+// with DeleteRequest, and note that Rekey, which maps URLs to keys, cannot
+// migrate them.
+func WithKeyFromRequest(fn func(req *http.Request) string) Option {
+	return func(hc *HTTPClient) {
+		hc.keyFromRequest = fn
+	}
+}
+
+// WithTrailingSlashFolding keys "/path/" and "/path" as the same URL, and a
+// bare host as its root path, so both spellings share one entry. Requests
+// still go to the URL as given, and the entry serves whichever spelling is
+// asked for. Servers that treat the two differently, say a directory
+// listing and a file, have them merged into one entry.
+func WithTrailingSlashFolding() Option {
+	return func(hc *HTTPClient) {
+		hc.foldSlash = true
+	}
+}
+
+// WithIndexFiles keys URLs matching pattern whose last path segment is one
+// of names, such as "index.html", as the directory holding it, so
+// "/dir/index.html" and "/dir/" share an entry. Which file a server serves
+// for a directory is site-specific, hence the pattern, which is matched
+// against the whole URL. Calls add to each other. As with
+// WithTrailingSlashFolding, folding URLs that are in fact distinct pages
+// makes them overwrite each other in the cache.
+func WithIndexFiles(pattern *regexp.Regexp, names ...string) Option {
+	return func(hc *HTTPClient) {
+		hc.indexFiles = append(hc.indexFiles, indexRule{pattern: pattern, names: names})
+	}
+}
+
+// WithKeyParams chooses which query parameters enter the cache key, so that
+// URLs differing only in noise such as tracking parameters share an entry
+// while significant ones, such as a page number, still tell entries apart.
+// keep, if not empty, lists the only parameters kept; ignore lists
+// parameters dropped. Both hold names or path.Match patterns, such as
+// "utm_*". When both are given a parameter must match keep and not match
+// ignore, so ignore wins where they overlap. A policy with KeyParams or
+// IgnoreParams of its own uses its lists instead, for the URLs it matches.
+// The request is always sent with its full query.
+func WithKeyParams(keep, ignore []string) Option {
+	return func(hc *HTTPClient) {
+		hc.keyParams = paramFilter{keep: keep, ignore: ignore}
+	}
+}
+
+// WithKeyLabelLength cuts the key inputs shown in log messages and returned
+// by KeyInput to n bytes, 200 by default. Cut inputs end with the digest of
+// the whole input, so they stay distinguishable. Store keys are unaffected.
+func WithKeyLabelLength(n int) Option {
+	return func(hc *HTTPClient) {
+		hc.keyLabelLen = n
+	}
+}
+
+// WithHasher derives store keys with h instead of SHA256Hasher. Like
+// WithKeyFunc it changes every key, so switching hashers, or changing the
+// secret of an HMACHasher, leaves existing entries unreachable.
+func WithHasher(h Hasher) Option {
+	return func(hc *HTTPClient) {
+		hc.keyFunc = h.Hash
+	}
+}
+
+// WithKeyEncoding writes the SHA-256 digests used as store keys in enc
+// instead of hex; Base64URL keys are a third shorter. It is WithHasher with
+// a SHA256Hasher, and like it changes every key: entries stored under the
+// other encoding become unreachable unless Rekey migrates them, with the
+// two hashers' Hash methods as the old and new key functions.
+func WithKeyEncoding(enc KeyEncoding) Option {
+	return WithHasher(SHA256Hasher{Encoding: enc})
+}
+
+// WithAutoReferer sends the final URL of the last successful request as the
+// Referer of the next, as a browser following links would. The client is
+// shared, so concurrent crawls should set RequestOptions.Referer instead.
+// Redirects need nothing from this option: the transport already sets the
+// Referer of each redirected request to the URL that redirected.
+func WithAutoReferer() Option {
+	return func(hc *HTTPClient) {
+		hc.autoReferer = true
+	}
+}
+
+// WithSchemeInKey controls whether http and https URLs are cached apart,
+// which they are by default. When disabled, an https URL shares the entry of
+// its http counterpart, so entries cached before a site moved to https keep
+// serving it.
+func WithSchemeInKey(include bool) Option {
+	return func(hc *HTTPClient) {
+		hc.schemeInKey = include
+	}
+}
+
+// WithCacheableMethods caches responses to methods beyond GET, HEAD and
+// OPTIONS, for example POST for APIs that use it for idempotent queries.
+// Entries for them are keyed by method and request body as well as URL.
+func WithCacheableMethods(methods ...string) Option {
+	return func(hc *HTTPClient) {
+		if hc.cacheableMethods == nil {
+			hc.cacheableMethods = make(map[string]bool)
+		}
+		for _, method := range methods {
+			hc.cacheableMethods[strings.ToUpper(method)] = true
+		}
+	}
+}
+
+// WithAccept sets the Accept header sent with every request. A policy can
+// override it for matching URLs through CachePolicy.Header, and a single call
+// through RequestOptions.Header.
+func WithAccept(accept string) Option {
+	return WithHeader(http.Header{"Accept": {accept}})
+}
+
+// WithAcceptInKey makes the Accept header sent with a request part of its
+// cache key. Servers that negotiate content return different bodies for the
+// same URL, for example JSON and HTML; caching both variants requires this,
+// otherwise whichever is fetched first is served for both.
+func WithAcceptInKey() Option {
+	return func(hc *HTTPClient) {
+		hc.acceptInKey = true
+	}
+}
+
+// WithSchemaValidator checks every body read from the cache with validate,
+// for example against the JSON schema an API is expected to follow. A body
+// it returns an error for is treated as a miss and fetched again, so entries
+// stored before the format changed are replaced without being invalidated by
+// hand. Fetched bodies are not checked; use RequestOptions.WriteValidator
+// to keep them out of the cache.
+func WithSchemaValidator(validate func(body []byte) error) Option {
+	return func(hc *HTTPClient) {
+		hc.schemaValidator = validate
+	}
+}
+
+// WithAccessLog writes a line to w for every network fetch, in the Combined
+// Log Format followed by the fetch duration and a MISS marker. With logHits,
+// requests answered from the cache are logged too, marked HIT. Writes to w
+// are serialized.
+func WithAccessLog(w io.Writer, logHits bool) Option {
+	return func(hc *HTTPClient) {
+		hc.accessLog = &accessLog{w: w, hits: logHits}
+	}
+}
+
+// WithDebugDump writes the request line and headers and the full response
+// of every fetch whose URL matches pattern to a timestamped file in dir, as a
+// debugging aid separate from the cache. The body is dumped as the transport
+// delivered it, after any transparent gzip decoding. At most maxFiles files
+// are written over the life of the client and bodies are cut at maxBytes;
+// zero leaves either unbounded.
+func WithDebugDump(dir string, pattern *regexp.Regexp, maxFiles int, maxBytes int) Option {
+	return func(hc *HTTPClient) {
+		hc.debugDump = &debugDump{dir: dir, pattern: pattern, maxFiles: int64(maxFiles), maxBytes: maxBytes}
+	}
+}
+
+// TTLFunc computes the TTL of a fetched response from its URL, body size and
+// headers.
+type TTLFunc func(url string, size int, header http.Header) time.Duration
+
+// WithTTLFunc decides the TTL of each fetched response with fn once the
+// response is known, for example to keep large downloads longer than small
+// API responses. A positive result replaces the policy TTL and is subject to
+// MaxTTL; zero or less keeps the policy TTL. fn only runs for responses that
+// are being cached, so a URL must still be covered by a policy, and a
+// per-call RequestOptions.TTL takes precedence over it.
+func WithTTLFunc(fn TTLFunc) Option {
+	return func(hc *HTTPClient) {
+		hc.ttlFunc = fn
+	}
+}
+
+// WithHARTTL stores the entries ImportHAR reads for d instead of the TTL of
+// each URL's policy. This lets an archive be replayed for URLs no policy
+// covers.
+func WithHARTTL(d time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.harTTL = d
+	}
+}
+
+// WithConditionalRequests revalidates expired entries that carry an ETag or
+// Last-Modified header with a conditional request instead of refetching them
+// outright. A 304 Not Modified answer keeps the cached body and sets the
+// entry's RevalidatedAt, from which its freshness then counts; see
+// conditional.go. Expired entries are kept for this rather than deleted on
+// read.
+func WithConditionalRequests() Option {
+	return func(hc *HTTPClient) {
+		hc.conditional = true
+	}
+}
+
+// WithSlowFetchThreshold logs every network fetch taking longer than
+// threshold, from sending the request to reading the whole body, with its
+// URL, duration and status, to spot slow hosts. Zero, the default, logs
+// nothing.
+func WithSlowFetchThreshold(threshold time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.slowFetchThreshold = threshold
+	}
+}
+
+// WithBypassFunc skips the cache read, as NetworkOnly does, for requests
+// whose URL fn accepts at the time they are made, for example during business
+// hours or for certain query values. The fetched body is still stored as the
+// policies and the call direct, so later requests fn lets through find it
+// fresh; set RequestOptions.NoStore as well to keep it out of the cache.
+func WithBypassFunc(fn func(url string) bool) Option {
+	return func(hc *HTTPClient) {
+		hc.bypassFunc = fn
+	}
+}
+
+// WithCacheableStatus replaces DefaultCacheableStatus as the rule deciding
+// which fetched responses may be cached; nil caches every status.
+// RequestOptions.AcceptStatus applies on top of it.
+func WithCacheableStatus(fn CacheableStatusFunc) Option {
+	return func(hc *HTTPClient) {
+		hc.cacheableStatus = fn
+	}
+}
+
+// WithClassifier labels every fetched body with classify, for example as an
+// article or a product page. The label is stored with the entry and returned
+// in FetchInfo.Class and EntrySummary.Class, so reading the entry back does
+// not classify it again.
+func WithClassifier(classify func(body []byte, finalURL string) string) Option {
+	return func(hc *HTTPClient) {
+		hc.classifier = classify
+	}
+}
+
+// WithBodyTransform rewrites every fetched body with transform, for example
+// to strip tracking scripts or normalize whitespace. It runs after the
+// validators and status checks have seen the body as served, and the
+// transformed body is what is cached and what callers receive, from the
+// network and from the cache alike: the original is not kept.
+func WithBodyTransform(transform func(url string, body []byte) []byte) Option {
+	return func(hc *HTTPClient) {
+		hc.bodyTransform = transform
+	}
+}
+
+// WithHostAuth authenticates requests to the hosts in creds, keyed by host
+// name or host:port, with the matching Credentials. A per-call Authorization
+// header takes precedence, and the header is dropped on redirects to other
+// hosts.
+//
+// Authenticated responses are cached like any other: they are written to the
+// store unencrypted and served to every caller of the client, whatever
+// credentials, if any, that caller would have used. Use WithAuthInKey if
+// different credentials see different content, and RequestOptions.NoStore
+// for responses that must not reach the disk at all.
+func WithHostAuth(creds map[string]Credentials) Option {
+	return func(hc *HTTPClient) {
+		hc.hostAuth = make(map[string]Credentials, len(creds))
+		for host, c := range creds {
+			hc.hostAuth[host] = c
+		}
+	}
+}
+
+// WithAuthInKey makes the Authorization header sent with a request part of
+// its cache key, so responses fetched with different credentials, or none,
+// are cached separately. Only a digest of the header enters the key.
+// Changing credentials then leaves the entries fetched with the old ones
+// unreachable.
+func WithAuthInKey() Option {
+	return func(hc *HTTPClient) {
+		hc.authInKey = true
+	}
+}
+
+// WithRefreshOnExpiry makes CacheFirst requests that find an expired entry
+// return it once and refresh it in the background, so only the read after
+// the refresh sees fresh data. Entries expired for longer than maxStale are
+// treated as misses; zero means no bound.
+func WithRefreshOnExpiry(maxStale time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.refreshOnExpiry = true
+		hc.maxStale = maxStale
+	}
+}
+
+// WithBackgroundRefreshLimits bounds the background refreshes of
+// WithRefreshOnExpiry, so a slow origin cannot make them pile up: each is
+// cancelled after timeout, and at most max run at once, further expired
+// entries being served stale without starting a refresh. Zero leaves either
+// unbounded. Stats reports the refreshes running, skipped and timed out.
+func WithBackgroundRefreshLimits(timeout time.Duration, max int) Option {
+	return func(hc *HTTPClient) {
+		hc.backgroundTimeout = timeout
+		if max > 0 {
+			hc.backgroundSem = make(chan struct{}, max)
+		}
+	}
+}
+
+// WithSharedRefreshLock deduplicates the background refreshes of
+// WithRefreshOnExpiry across clients sharing a store, such as crawler
+// instances on one database, which each refresh a hot expired entry
+// otherwise. Before refreshing an entry, a client writes a marker for it
+// into the store, and other clients finding the marker serve the expired
+// entry without refreshing it; the refresh counts in Stats as skipped. The
+// marker is deleted when the refresh ends, and one older than ttl is taken
+// to be left by a client that died mid-refresh and is taken over, so ttl
+// should exceed the longest refresh, such as WithBackgroundRefreshLimits'
+// timeout. The store has no atomic writes, so two clients racing for a free
+// marker may rarely both refresh.
+func WithSharedRefreshLock(ttl time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.refreshLockTTL = ttl
+		hc.refreshLockOwner = newRefreshLockOwner()
+	}
+}
+
+// WithRevalidationWindow coalesces the refetches of expired entries found by
+// CacheFirst requests. The first request to find an entry expired refetches
+// it and waits for the result; for the next window, concurrent requests for
+// the same entry are served the expired one at once, marked Stale with
+// StaleRefreshing, instead of waiting too. Requests arriving later while the
+// refetch still runs join it and share its result. If the refetch fails, the
+// expired entry is served, marked StaleOnError. Unlike WithRefreshOnExpiry,
+// the request that triggers the refetch always gets fresh data.
+func WithRevalidationWindow(window time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.revalidationWindow = window
+	}
+}
+
+// WithStaleOnError keeps an expired entry found by a CacheFirst request
+// until the refetch replacing it succeeds, and serves it, marked Stale with
+// StaleOnError, if the refetch fails. Without it expired entries are deleted
+// as soon as they are read, so a failed refetch returns the fetch error. An
+// entry that the successful refetch does not replace, for example because
+// the new body fails validation, is deleted then.
+func WithStaleOnError() Option {
+	return func(hc *HTTPClient) {
+		hc.staleOnError = true
+	}
+}
+
+// WithPrefetchErrorHandler routes errors of Prefetch fetches to handle,
+// which is called from the background goroutine that did the fetch.
+func WithPrefetchErrorHandler(handle func(url string, err error)) Option {
+	return func(hc *HTTPClient) {
+		hc.onPrefetchError = handle
+	}
+}
+
+// WithRedirectPrefetch builds a crawl frontier out of redirects: with
+// WithFollowRedirects(false), each 3xx response fetched, cached as a redirect
+// entry as usual, also has its target prefetched in the background as by
+// Prefetch, and so on down redirect chains of up to 10 hops. At most max such
+// prefetches run at once; further targets are dropped rather than queued, so
+// a page of redirects cannot fan out without bound.
+func WithRedirectPrefetch(max int) Option {
+	return func(hc *HTTPClient) {
+		if max > 0 {
+			hc.redirectPrefetchSem = make(chan struct{}, max)
+		}
+	}
+}
+
+// WithJanitor deletes expired entries in the background every interval,
+// until the client is closed. See WithJanitorBatchSize and WithJanitorJitter.
+func WithJanitor(interval time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.ensureJanitor().interval = interval
+	}
+}
+
+// WithPersistentStats keeps the counters reported by Stats across restarts:
+// they are saved in the store under a reserved key every interval, if it is
+// positive, and when the client is closed, and a client opening the store
+// later starts from the saved values. Counters of the present, such as
+// InFlight, start from zero as usual, and latencies are kept with the
+// precision of the histogram Stats reports them from. Entries are never
+// affected; ResetStats clears the saved counters too at the next save.
+func WithPersistentStats(interval time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.statsSaver = &statsSaver{interval: interval}
+	}
+}
+
+// WithJanitorBatchSize caps the entries the janitor scans per tick at n. A
+// store larger than that is cleaned over several ticks, the janitor yielding
+// between them, which avoids latency spikes on large caches. Zero, the
+// default, scans the whole store every tick.
+func WithJanitorBatchSize(n int) Option {
+	return func(hc *HTTPClient) {
+		hc.ensureJanitor().batchSize = n
+	}
+}
+
+// WithJanitorJitter adds a random delay of up to jitter to every janitor
+// interval, so that several instances do not clean up in lockstep.
+func WithJanitorJitter(jitter time.Duration) Option {
+	return func(hc *HTTPClient) {
+		hc.ensureJanitor().jitter = jitter
+	}
+}
+
+func (hc *HTTPClient) ensureJanitor() *janitor {
+	if hc.janitor == nil {
+		hc.janitor = &janitor{}
+	}
+	return hc.janitor
+}
@@ -0,0 +1,46 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Peek fetches url for its status, headers and at most the first n bytes of
+// its body, to sniff its content type or spot a block page before committing
+// to a full download. It asks for just that range of bytes; a server that
+// honors the request answers 206 Partial Content, and one that ignores it
+// starts sending the whole body, which is cut off after n bytes by closing
+// the connection. Nothing is read from or written to the cache.
+func (hc *HTTPClient) Peek(url string, n int) (prefix []byte, header http.Header, status int, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := hc.newRequest(ctx, http.MethodGet, url, nil, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if n > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	}
+	limiter := hc.policyLimiter(hc.cache.Policy(url))
+	if err := limiter.acquire(ctx, hc.failFastWhenBusy); err != nil {
+		return nil, nil, 0, err
+	}
+	defer limiter.release()
+	if err := hc.acquireFetch(ctx); err != nil {
+		return nil, nil, 0, err
+	}
+	defer hc.releaseFetch()
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// Cancelling before closing drops the connection instead of draining
+	// the rest of a body the server insists on sending.
+	defer resp.Body.Close()
+	defer cancel()
+	prefix, err = io.ReadAll(io.LimitReader(resp.Body, int64(max(n, 0))))
+	return prefix, resp.Header, resp.StatusCode, err
+}
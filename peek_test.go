@@ -0,0 +1,41 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeek(t *testing.T) {
+	body := strings.Repeat("x", 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/ranged" {
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+			return
+		}
+		// Ignore Range and send everything.
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	for path, wantStatus := range map[string]int{"/ranged": http.StatusPartialContent, "/whole": http.StatusOK} {
+		prefix, header, status, err := client.Peek(server.URL+path, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(prefix, []byte(body[:100])) {
+			t.Errorf("%s: got %d bytes, want the first 100", path, len(prefix))
+		}
+		if status != wantStatus || header.Get("Content-Type") != "text/plain" {
+			t.Errorf("%s: status %d, Content-Type %q; want %d, text/plain", path, status, header.Get("Content-Type"), wantStatus)
+		}
+		if _, found := client.cache.GetEntry(client.key(server.URL + path)); found {
+			t.Errorf("%s: peeked response was cached", path)
+		}
+	}
+}
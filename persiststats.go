@@ -0,0 +1,142 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// statsKey is the reserved store key WithPersistentStats saves the counters
+// under.
+const statsKey = reservedKeyPrefix + "stats"
+
+// savedStats is the stored form of the cumulative counters. Counters that
+// describe the present, such as InFlight, are not saved.
+type savedStats struct {
+	BytesFromCache     int64   `json:"bytes_from_cache"`
+	BytesFromNetwork   int64   `json:"bytes_from_network"`
+	DroppedEvents      int64   `json:"dropped_events"`
+	BackgroundSkipped  int64   `json:"background_skipped"`
+	BackgroundTimedOut int64   `json:"background_timed_out"`
+	CompressedBytesIn  int64   `json:"compressed_bytes_in"`
+	CompressedBytesOut int64   `json:"compressed_bytes_out"`
+	LatencyBuckets     []int64 `json:"latency_buckets,omitempty"`
+	LatencySum         int64   `json:"latency_sum"`
+	LatencyMin         int64   `json:"latency_min"` // plus one, as in latencyHistogram
+	LatencyMax         int64   `json:"latency_max"`
+}
+
+// statsSaver saves the counters every interval until stopped.
+type statsSaver struct {
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// loadStats adds the counters saved by an earlier client to this one's and
+// starts saving them periodically, if the client was configured to.
+func (hc *HTTPClient) loadStats() {
+	s := hc.statsSaver
+	if s == nil {
+		return
+	}
+	// Stores other than LevelDB report a missing key as an empty value.
+	if value, err := hc.cache.Store.Get(statsKey); err == nil && len(value) > 0 {
+		var saved savedStats
+		if err := json.Unmarshal(value, &saved); err != nil {
+			hc.cache.logf("Failed to decode saved stats: %v", err)
+		} else {
+			hc.restoreStats(&saved)
+		}
+	}
+	if s.interval <= 0 {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				hc.saveStats()
+			}
+		}
+	}()
+}
+
+// stopStats stops the periodic saving and saves the counters a last time.
+func (hc *HTTPClient) stopStats() {
+	s := hc.statsSaver
+	if s == nil {
+		return
+	}
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	hc.saveStats()
+}
+
+// saveStats writes the cumulative counters to the store.
+func (hc *HTTPClient) saveStats() {
+	h := &hc.latency
+	saved := savedStats{
+		BytesFromCache:     hc.bytesFromCache.Load(),
+		BytesFromNetwork:   hc.bytesFromNetwork.Load(),
+		DroppedEvents:      hc.cache.events.dropped.Load(),
+		BackgroundSkipped:  hc.backgroundSkipped.Load(),
+		BackgroundTimedOut: hc.backgroundTimedOut.Load(),
+		CompressedBytesIn:  hc.cache.codecIn.Load(),
+		CompressedBytesOut: hc.cache.codecOut.Load(),
+		LatencySum:         h.sum.Load(),
+		LatencyMin:         h.min.Load(),
+		LatencyMax:         h.max.Load(),
+	}
+	for i := range h.buckets {
+		if n := h.buckets[i].Load(); n > 0 {
+			if saved.LatencyBuckets == nil {
+				saved.LatencyBuckets = make([]int64, latencyBuckets)
+			}
+			saved.LatencyBuckets[i] = n
+		}
+	}
+	value, err := json.Marshal(&saved)
+	if err != nil {
+		hc.cache.logf("Failed to encode stats: %v", err)
+		return
+	}
+	if err := hc.cache.Store.Put(statsKey, value); err != nil {
+		hc.cache.logf("Failed to save stats: %v", err)
+	}
+}
+
+// restoreStats adds saved to the client's counters.
+func (hc *HTTPClient) restoreStats(saved *savedStats) {
+	hc.bytesFromCache.Add(saved.BytesFromCache)
+	hc.bytesFromNetwork.Add(saved.BytesFromNetwork)
+	hc.cache.events.dropped.Add(saved.DroppedEvents)
+	hc.backgroundSkipped.Add(saved.BackgroundSkipped)
+	hc.backgroundTimedOut.Add(saved.BackgroundTimedOut)
+	hc.cache.codecIn.Add(saved.CompressedBytesIn)
+	hc.cache.codecOut.Add(saved.CompressedBytesOut)
+
+	h := &hc.latency
+	for i, n := range saved.LatencyBuckets {
+		if i < latencyBuckets {
+			h.buckets[i].Add(n)
+		}
+	}
+	h.sum.Add(saved.LatencySum)
+	if m := h.min.Load(); saved.LatencyMin != 0 && (m == 0 || saved.LatencyMin < m) {
+		h.min.Store(saved.LatencyMin)
+	}
+	if saved.LatencyMax > h.max.Load() {
+		h.max.Store(saved.LatencyMax)
+	}
+}
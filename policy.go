@@ -0,0 +1,209 @@
+package httpcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// catchAllPolicy is the policy ParsePolicies appends unless told otherwise.
+func catchAllPolicy() CachePolicy {
+	return CachePolicy{
+		Pattern: regexp.MustCompile(".*"),
+		TTL:     10 * time.Minute,
+	}
+}
+
+// ParsePolicies reads cache policies from r, one per line in the format
+// described at parsePolicyLine, skipping blank lines and # comments. Lines
+// starting with fallback= give fallback policies in the same format: they
+// come after all the others, wherever they appear, in the order given, so
+// broad defaults for categories of URLs can sit behind the specific
+// policies and before the catch-all. A catch-all policy caching every other
+// URL for 10 minutes is appended last; a default=<duration> line changes
+// its TTL and default=never leaves it out, so unmatched URLs are not cached.
+func ParsePolicies(r io.Reader) ([]CachePolicy, error) {
+	defaultPolicy := catchAllPolicy()
+	policies := []CachePolicy{}
+	fallbacks := []CachePolicy{}
+	useDefault := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Skip empty lines and comment-only lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Remove inline comments
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = strings.TrimSpace(line[:idx])
+		}
+
+		if value, ok := strings.CutPrefix(line, "default="); ok {
+			if value == "never" {
+				useDefault = false
+				continue
+			}
+			ttl, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default duration: %s", err)
+			}
+			defaultPolicy.TTL, useDefault = ttl, true
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(line, "fallback="); ok {
+			policy, err := parsePolicyLine(value)
+			if err != nil {
+				return nil, err
+			}
+			fallbacks = append(fallbacks, policy)
+			continue
+		}
+
+		policy, err := parsePolicyLine(line)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading policies file: %v", err)
+	}
+
+	policies = append(policies, fallbacks...)
+	if useDefault {
+		policies = append(policies, defaultPolicy)
+	}
+	return policies, nil
+}
+
+// parsePolicyLine parses a line of a policies file:
+//
+//	regex=duration [option:value ...]
+//
+// The options are body_must_match and body_must_not_match, whose values are
+// regular expressions without whitespace (use \s), and rate and concurrency,
+// which set Rate (fetches per second) and MaxConcurrent, and key_params and
+// ignore_params, comma-separated lists setting KeyParams and IgnoreParams,
+// and allow_empty:true, setting AllowEmpty. The regex runs up to the last =
+// that is followed by a duration, so it may contain = itself.
+func parsePolicyLine(line string) (CachePolicy, error) {
+	idx := strings.LastIndex(line, "=")
+	if idx == -1 {
+		return CachePolicy{}, fmt.Errorf("invalid policy format: %s", line)
+	}
+	for i := idx; i != -1; i = strings.LastIndex(line[:i], "=") {
+		if startsWithDuration(strings.Fields(line[i+1:])) {
+			idx = i
+			break
+		}
+	}
+	fields := strings.Fields(line[idx+1:])
+	duration := ""
+	if len(fields) > 0 {
+		duration, fields = fields[0], fields[1:]
+	}
+	ttl, err := time.ParseDuration(duration)
+	if err != nil {
+		return CachePolicy{}, fmt.Errorf("invalid duration: %s", err)
+	}
+
+	// Compile pattern
+	pattern, err := regexp.Compile(strings.TrimSpace(line[:idx]))
+	if err != nil {
+		return CachePolicy{}, fmt.Errorf("invalid regex pattern: %s", err)
+	}
+	policy := CachePolicy{Pattern: pattern, TTL: ttl}
+
+	for _, option := range fields {
+		key, value, ok := strings.Cut(option, ":")
+		if !ok {
+			return CachePolicy{}, fmt.Errorf("invalid policy option: %s", option)
+		}
+		switch key {
+		case "body_must_match", "body_must_not_match":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return CachePolicy{}, fmt.Errorf("invalid %s pattern: %s", key, err)
+			}
+			if key == "body_must_match" {
+				policy.BodyMustMatch = re
+			} else {
+				policy.BodyMustNotMatch = re
+			}
+		case "rate":
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 {
+				return CachePolicy{}, fmt.Errorf("invalid rate: %s", value)
+			}
+			policy.Rate = rate
+		case "concurrency":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return CachePolicy{}, fmt.Errorf("invalid concurrency: %s", value)
+			}
+			policy.MaxConcurrent = n
+		case "key_params":
+			policy.KeyParams = strings.Split(value, ",")
+		case "ignore_params":
+			policy.IgnoreParams = strings.Split(value, ",")
+		case "allow_empty":
+			allow, err := strconv.ParseBool(value)
+			if err != nil {
+				return CachePolicy{}, fmt.Errorf("invalid allow_empty: %s", value)
+			}
+			policy.AllowEmpty = allow
+		default:
+			return CachePolicy{}, fmt.Errorf("unknown policy option: %s", key)
+		}
+	}
+	return policy, nil
+}
+
+func startsWithDuration(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	_, err := time.ParseDuration(fields[0])
+	return err == nil
+}
+
+// ValidatePoliciesAgainst reports how many of sampleURLs each policy
+// pattern governs, keyed by the pattern's source. Like GetTTL it counts a
+// URL for the first policy matching it only, so a pattern reported with 0
+// either matches none of the samples or is shadowed by the patterns before
+// it; either way it is worth reviewing. Every pattern is in the map.
+func (c *Cache) ValidatePoliciesAgainst(sampleURLs []string) map[string]int {
+	counts := make(map[string]int, len(c.Policies))
+	for _, policy := range c.Policies {
+		counts[policy.Pattern.String()] += 0
+	}
+	for _, url := range sampleURLs {
+		if policy := c.Policy(url); policy != nil {
+			counts[policy.Pattern.String()]++
+		}
+	}
+	return counts
+}
+
+// allowsBody reports whether body passes the policy's body patterns. A nil
+// policy allows every body.
+func (p *CachePolicy) allowsBody(body []byte) bool {
+	if p == nil {
+		return true
+	}
+	if p.BodyMustMatch != nil && !p.BodyMustMatch.Match(body) {
+		return false
+	}
+	return p.BodyMustNotMatch == nil || !p.BodyMustNotMatch.Match(body)
+}
@@ -0,0 +1,300 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyBodyPatterns(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if strings.HasPrefix(r.URL.Path, "/limited") {
+			w.Write([]byte("Rate limit exceeded, slow down"))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	content := `
+/api\?v=1=5m body_must_match:^\{ body_must_not_match:Rate\slimit
+.*=1m body_must_not_match:Rate\slimit
+`
+	path := filepath.Join(t.TempDir(), "policies.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := LoadPoliciesFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := policies[0]; p.Pattern.String() != `/api\?v=1` || p.TTL != 5*time.Minute || p.BodyMustMatch == nil || p.BodyMustNotMatch == nil {
+		t.Fatalf("first policy parsed as %+v", p)
+	}
+
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, path := range []string{"/api?v=1", "/limited"} {
+		for i := 0; i < 2; i++ {
+			if _, err := client.Get(server.URL + path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The JSON response is cached, the rate-limit notice fetched both times.
+	if hits.Load() != 3 {
+		t.Errorf("hits = %d, want 3", hits.Load())
+	}
+
+	for _, line := range []string{".*=5m body_must_match", ".*=5m colour:red", ".*=5m body_must_match:(", ".*=soon"} {
+		if _, err := parsePolicyLine(line); err == nil {
+			t.Errorf("parsePolicyLine(%q) succeeded", line)
+		}
+	}
+}
+
+func TestDefaultPolicyLine(t *testing.T) {
+	tests := []struct {
+		content string
+		want    time.Duration // TTL of unmatched URLs
+	}{
+		{"/a=5m\n", 10 * time.Minute},
+		{"/a=5m\ndefault=1h\n", time.Hour},
+		{"default=never\n/a=5m\n", 0},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "policies.txt")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		policies, err := LoadPoliciesFromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Cache{Policies: policies}
+		if got := c.GetTTL("http://example.com/b"); got != tt.want {
+			t.Errorf("%q: unmatched TTL = %v, want %v", tt.content, got, tt.want)
+		}
+		if got := c.GetTTL("http://example.com/a"); got != 5*time.Minute {
+			t.Errorf("%q: /a TTL = %v, want 5m", tt.content, got)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "policies.txt")
+	os.WriteFile(path, []byte("default=sometimes\n"), 0644)
+	if _, err := LoadPoliciesFromFile(path); err == nil {
+		t.Error("invalid default duration accepted")
+	}
+}
+
+func TestFallbackPolicies(t *testing.T) {
+	content := `fallback=.*\.example\.com/.*=1h
+.*/static/.*=24h
+fallback=.*\.com/.*=30m
+default=5m
+.*/api/.*=1m
+`
+	policies, err := ParsePolicies(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Cache{Policies: policies}
+	tests := []struct {
+		url  string
+		want time.Duration
+	}{
+		{"http://www.example.com/static/a.css", 24 * time.Hour},
+		{"http://www.example.com/api/items", time.Minute},
+		{"http://www.example.com/page", time.Hour},
+		{"http://other.com/page", 30 * time.Minute},
+		{"http://other.org/page", 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := c.GetTTL(tt.url); got != tt.want {
+			t.Errorf("%s: TTL = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	if n := len(policies); n != 5 || policies[n-1].Pattern.String() != ".*" {
+		t.Errorf("got %d policies ending in %v, want 5 ending in the catch-all", n, policies[n-1].Pattern)
+	}
+
+	if _, err := ParsePolicies(strings.NewReader("fallback=[=1h\n")); err == nil {
+		t.Error("invalid fallback pattern accepted")
+	}
+}
+
+func TestValidatePoliciesAgainst(t *testing.T) {
+	policies, err := ParsePolicies(strings.NewReader(".*/news/.*=5m\n.*/news/archive/.*=168h\n.*/shop/.*=1h\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Cache{Policies: policies}
+	counts := c.ValidatePoliciesAgainst([]string{
+		"http://example.com/news/today",
+		"http://example.com/news/archive/2020",
+		"http://example.com/about",
+	})
+	want := map[string]int{
+		".*/news/.*":         2,
+		".*/news/archive/.*": 0, // shadowed by the pattern before it
+		".*/shop/.*":         0,
+		".*":                 1,
+	}
+	if len(counts) != len(want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+	for pattern, n := range want {
+		if got, ok := counts[pattern]; !ok || got != n {
+			t.Errorf("%s: count = %d (present %v), want %d", pattern, got, ok, n)
+		}
+	}
+}
+
+func TestGzipPolicies(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("# compressed\n/a=5m\ndefault=1h\n"))
+	zw.Close()
+
+	// Detected by extension, and by magic bytes when the name doesn't say.
+	for _, name := range []string{"policies.txt.gz", "policies.txt"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		policies, err := LoadPoliciesFromFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		c := &Cache{Policies: policies}
+		if got := c.GetTTL("http://example.com/a"); got != 5*time.Minute {
+			t.Errorf("%s: /a TTL = %v, want 5m", name, got)
+		}
+		if got := c.GetTTL("http://example.com/b"); got != time.Hour {
+			t.Errorf("%s: unmatched TTL = %v, want 1h", name, got)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "policies.txt.gz")
+	os.WriteFile(path, []byte("/a=5m\n"), 0644)
+	if _, err := LoadPoliciesFromFile(path); err == nil {
+		t.Error("plain text with a .gz name accepted")
+	}
+}
+
+func TestPolicyLimits(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := map[string]int{}, map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running[r.URL.Path]++
+		peak[r.URL.Path] = max(peak[r.URL.Path], running[r.URL.Path])
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running[r.URL.Path]--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	policies := make([]CachePolicy, 0, 2)
+	for _, line := range []string{"/fragile=1m rate:20 concurrency:1", "/robust=1m concurrency:4"} {
+		p, err := parsePolicyLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies = append(policies, p)
+	}
+	if p := policies[0]; p.Rate != 20 || p.MaxConcurrent != 1 {
+		t.Fatalf("fragile policy parsed as %+v", p)
+	}
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	elapsed := map[string]time.Duration{}
+	for _, path := range []string{"/fragile", "/robust"} {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := client.Do(server.URL+path, &RequestOptions{Preference: NetworkOnly}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		elapsed[path] = time.Since(start)
+	}
+
+	if peak["/fragile"] != 1 {
+		t.Errorf("fragile peak concurrency = %d, want 1", peak["/fragile"])
+	}
+	if peak["/robust"] < 2 {
+		t.Errorf("robust peak concurrency = %d, want fetches in parallel", peak["/robust"])
+	}
+	// At 20 per second, the fourth fetch starts 150ms after the first.
+	if elapsed["/fragile"] < 140*time.Millisecond {
+		t.Errorf("fragile fetches took %v, want the rate to space them out", elapsed["/fragile"])
+	}
+
+	for _, line := range []string{".*=5m rate:fast", ".*=5m concurrency:-1"} {
+		if _, err := parsePolicyLine(line); err == nil {
+			t.Errorf("parsePolicyLine(%q) succeeded", line)
+		}
+	}
+}
+
+func TestPolicyFragmentsDir(t *testing.T) {
+	dir := t.TempDir()
+	fragments := map[string]string{
+		"20-news.txt":  "/news/=5m\ndefault=1h",
+		"10-shop.txt":  "/shop/=168h\n",
+		"30-notes.md":  "/ignored/=1m\n",
+		"00-empty.txt": "",
+	}
+	for name, content := range fragments {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policies, err := LoadPoliciesFromFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []string
+	for _, p := range policies {
+		patterns = append(patterns, p.Pattern.String())
+	}
+	if len(patterns) != 3 || patterns[0] != "/shop/" || patterns[1] != "/news/" {
+		t.Fatalf("patterns = %q, want the fragments in filename order and one catch-all", patterns)
+	}
+	c := &Cache{Policies: policies}
+	for url, want := range map[string]time.Duration{
+		"http://example.com/shop/1":    168 * time.Hour,
+		"http://example.com/news/1":    5 * time.Minute,
+		"http://example.com/ignored/1": time.Hour,
+	} {
+		if got := c.GetTTL(url); got != want {
+			t.Errorf("%s: TTL = %v, want %v", url, got, want)
+		}
+	}
+}
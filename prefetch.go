@@ -0,0 +1,46 @@
+package httpcache
+
+import "net/http"
+
+// Prefetch fetches url into the cache in the background and returns at once,
+// for speculative fetches of pages likely to be requested soon. Nothing is
+// fetched if a fresh entry exists. The fetch counts against the concurrency
+// limit like any other, and prefetches and background refreshes of the same
+// URL in progress are not duplicated. Errors go to the handler set with
+// WithPrefetchErrorHandler and are dropped otherwise.
+func (hc *HTTPClient) Prefetch(url string) {
+	hc.prefetch(url, nil)
+}
+
+func (hc *HTTPClient) prefetch(url string, opts *RequestOptions) {
+	hc.inBackground(hc.key(url), func() {
+		if opts != nil && opts.redirectHops > 0 {
+			select {
+			case hc.redirectPrefetchSem <- struct{}{}:
+				defer func() { <-hc.redirectPrefetchSem }()
+			default:
+				return
+			}
+		}
+		if _, err := hc.run(url, opts); err != nil && hc.onPrefetchError != nil {
+			hc.onPrefetchError(url, err)
+		}
+	})
+}
+
+// maxRedirectHops bounds the chains of redirects WithRedirectPrefetch
+// follows, as net/http does, so redirect loops end.
+const maxRedirectHops = 10
+
+// prefetchRedirect prefetches the target of entry, fetched for url with
+// opts, if it is a redirect and WithRedirectPrefetch is on.
+func (hc *HTTPClient) prefetchRedirect(url string, entry *CacheEntry, opts *RequestOptions) {
+	if hc.redirectPrefetchSem == nil || entry.status() < 300 || entry.status() >= 400 ||
+		entry.StatusCode == http.StatusNotModified || entry.FinalURL == "" || entry.FinalURL == url {
+		return
+	}
+	if opts.redirectHops >= maxRedirectHops {
+		return
+	}
+	hc.prefetch(entry.FinalURL, &RequestOptions{redirectHops: opts.redirectHops + 1})
+}
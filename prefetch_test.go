@@ -0,0 +1,105 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t)
+
+	for i := 0; i < 5; i++ {
+		client.Prefetch(server.URL)
+	}
+	client.backgroundWG.Wait()
+
+	r, err := client.Do(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 1" || !r.FromCache {
+		t.Errorf("data=%q fromCache=%v, want the prefetched entry", r.Data, r.FromCache)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("hits = %d, want 1", hits.Load())
+	}
+}
+
+func TestPrefetchErrorHandler(t *testing.T) {
+	server, _ := countingServer(t)
+	url := server.URL
+	server.Close()
+
+	failed := make(chan string, 1)
+	client := newTestClient(t, WithPrefetchErrorHandler(func(url string, err error) {
+		failed <- url
+	}))
+	client.Prefetch(url)
+	select {
+	case got := <-failed:
+		if got != url {
+			t.Errorf("handler got %q, want %q", got, url)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error handler was not called")
+	}
+}
+
+func TestRedirectPrefetch(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusFound)
+		case "/loop1":
+			w.Header().Set("Location", "/loop2")
+			w.WriteHeader(http.StatusFound)
+		case "/loop2":
+			w.Header().Set("Location", "/loop1")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Write([]byte("target"))
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithFollowRedirects(false), WithRedirectPrefetch(2))
+
+	r, err := client.Do(server.URL+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusMovedPermanently || r.FinalURL != server.URL+"/b" {
+		t.Fatalf("got %d to %s, want the redirect itself", r.StatusCode, r.FinalURL)
+	}
+	client.backgroundWG.Wait()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		if _, found := client.cache.GetEntry(client.key(server.URL + path)); !found {
+			t.Errorf("%s should be cached", path)
+		}
+	}
+	if r, err := client.Do(server.URL+"/c", &RequestOptions{Preference: CacheOnly}); err != nil || string(r.Data) != "target" {
+		t.Errorf("target: %q, %v; want it prefetched", r.Data, err)
+	}
+
+	// Uncacheable redirects in a loop are followed a bounded number of times.
+	if _, err := client.Do(server.URL+"/loop1", nil); err != nil {
+		t.Fatal(err)
+	}
+	client.backgroundWG.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if n := hits["/loop1"] + hits["/loop2"]; n != maxRedirectHops+1 {
+		t.Errorf("loop fetched %d times, want %d", n, maxRedirectHops+1)
+	}
+}
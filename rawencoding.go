@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Raw encoding
+//
+// By default the transport asks for gzip and decompresses responses itself,
+// so what is cached is the decompressed body. With WithRawEncoding the
+// client asks for gzip on its own behalf instead: the body is stored exactly
+// as the server sent it, recorded with its Content-Encoding in
+// CacheEntry.Encoding, and decompressed each time it is read back.
+
+// setRawEncoding makes req ask for a gzip body the transport leaves alone,
+// unless it already says which encodings it accepts.
+func (hc *HTTPClient) setRawEncoding(req *http.Request) {
+	if hc.rawEncoding && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+}
+
+// decodeRaw fills in entry.Data with the decompressed form of body, a gzip
+// body received as it was sent, keeping body to be stored. limit, if
+// positive, bounds the decompressed size.
+func decodeRaw(url string, entry *CacheEntry, body []byte, limit int64) error {
+	data, err := gunzip(body, limit)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return &DecompressionLimitError{URL: url, Limit: limit}
+	}
+	entry.Data = data
+	entry.Encoding = "gzip"
+	entry.raw = body
+	return nil
+}
+
+// decodeEncoding decompresses the body of a stored entry that was kept in
+// its Content-Encoding, keeping the stored bytes for when it is written
+// again.
+func decodeEncoding(entry *CacheEntry) error {
+	switch entry.Encoding {
+	case "":
+		return nil
+	case "gzip":
+		data, err := gunzip(entry.Data, 0)
+		if err != nil {
+			return err
+		}
+		entry.raw = entry.Data
+		entry.Data = data
+		return nil
+	}
+	return fmt.Errorf("unsupported stored encoding %q", entry.Encoding)
+}
+
+// gunzip decompresses body, reading at most limit+1 bytes of output if limit
+// is positive.
+func gunzip(body []byte, limit int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var r io.Reader = zr
+	if limit > 0 {
+		r = io.LimitReader(zr, limit+1)
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRawEncoding(t *testing.T) {
+	body := strings.Repeat("compressible ", 100)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(body))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithRawEncoding())
+	for i := 0; i < 2; i++ {
+		data, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != body {
+			t.Fatalf("request %d: got %d bytes, want the decompressed body", i, len(data))
+		}
+	}
+
+	value, err := client.cache.Store.Get(client.key(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored CacheEntry
+	if err := Unmarshal(value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Encoding != "gzip" || !bytes.Equal(stored.Data, compressed.Bytes()) {
+		t.Errorf("stored %d bytes with encoding %q, want the %d gzip bytes as sent",
+			len(stored.Data), stored.Encoding, compressed.Len())
+	}
+
+	client = newTestClient(t, WithRawEncoding(), WithMaxDecompressedBytes(100))
+	_, err = client.Get(server.URL)
+	var limitErr *DecompressionLimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("err = %v, want *DecompressionLimitError", err)
+	}
+}
+
+func TestRawEncodingBodyTransform(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello TRACKER world"))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithRawEncoding(), WithBodyTransform(func(url string, body []byte) []byte {
+		return bytes.ReplaceAll(body, []byte("TRACKER "), nil)
+	}))
+	for i, source := range []string{"fetched", "cached"} {
+		r, err := client.Do(server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.FromCache != (i == 1) || string(r.Data) != "hello world" {
+			t.Errorf("%s body = %q (fromCache=%v), want the transformed body", source, r.Data, r.FromCache)
+		}
+	}
+}
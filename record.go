@@ -0,0 +1,48 @@
+package httpcache
+
+import "net/http"
+
+// Mode selects whether the client records, replays or neither, for tests that
+// must not depend on the network. The recording is the cache itself: Export
+// and Import move it between stores, for example into a test's fixtures.
+type Mode int32
+
+const (
+	// Live is normal operation.
+	Live Mode = iota
+	// Record fetches every request from the network and stores it, whatever
+	// Preference, Bypass, NoStore or SetEnabled say and whether or not a
+	// policy caches the URL or its status. Responses to URLs no policy
+	// caches are stored already expired, so Live mode does not serve them.
+	Record
+	// Replay serves every request from the cache, expired entries included,
+	// and never touches the network: requests without a recorded response
+	// fail with ErrCacheMiss.
+	Replay
+)
+
+// SetMode switches the client to m for every request made from now on. It
+// is safe to call while requests are in progress, which finish as they
+// started.
+func (hc *HTTPClient) SetMode(m Mode) {
+	hc.mode.Store(int32(m))
+}
+
+// Mode returns the mode set by SetMode, Live by default.
+func (hc *HTTPClient) Mode() Mode {
+	return Mode(hc.mode.Load())
+}
+
+// replay answers a request in Replay mode from the entry recorded under key.
+func (hc *HTTPClient) replay(key, url string, req *http.Request, opts *RequestOptions) (*Result, error) {
+	if !hc.cacheableMethod(req.Method) {
+		return &Result{}, ErrCacheMiss
+	}
+	entry, _ := hc.cache.lookup(key)
+	if entry == nil || !opts.validRead(entry) {
+		hc.cache.events.publish(CacheEvent{Kind: EventMiss, Key: key, URL: url})
+		return &Result{}, ErrCacheMiss
+	}
+	hc.hit(key, url, req, entry)
+	return entry.result(true), nil
+}
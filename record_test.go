@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "recorded %s", r.URL.Path)
+	}))
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(`/cached/`), TTL: time.Minute}}
+	client, err := NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	base := server.URL
+
+	client.SetMode(Record)
+	paths := []string{"/cached/1", "/cached/1", "/uncached", "/missing"}
+	for _, path := range paths {
+		if _, err := client.Get(base + path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != int64(len(paths)) {
+		t.Errorf("server hits = %d, want every request recorded from the network", hits.Load())
+	}
+	server.Close()
+
+	client.SetMode(Replay)
+	for _, path := range paths[1:] {
+		r, err := client.Do(base+path, nil)
+		if err != nil {
+			t.Fatalf("replaying %s: %v", path, err)
+		}
+		if path == "/missing" {
+			if r.StatusCode != http.StatusNotFound {
+				t.Errorf("%s: status %d, want the recorded 404", path, r.StatusCode)
+			}
+		} else if string(r.Data) != "recorded "+path || !r.FromCache {
+			t.Errorf("%s: got %q, fromCache=%v; want the recording", path, r.Data, r.FromCache)
+		}
+	}
+	if _, err := client.Get(base + "/never"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("unrecorded URL: err = %v, want ErrCacheMiss", err)
+	}
+
+	// Live mode does not serve what no policy caches.
+	client.SetMode(Live)
+	if _, err := client.Get(base + "/uncached"); err == nil {
+		t.Error("Live mode served an uncached URL with the server down")
+	}
+}
@@ -0,0 +1,135 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// staleOrFresh looks key up for a CacheFirst request on a client with
+// refresh on expiry, reporting whether the entry is expired. A fresh entry is
+// returned as is. An expired entry within the staleness bound is returned
+// too, after starting a background refresh; one past the bound is deleted
+// and nil returned, as GetEntry would.
+func (hc *HTTPClient) staleOrFresh(url, key string, req *http.Request, opts *RequestOptions) (*CacheEntry, bool) {
+	entry, expired := hc.cache.lookup(key)
+	if entry == nil || !expired {
+		return entry, false
+	}
+	if hc.maxStale > 0 && time.Since(hc.cache.expiresAt(entry)) > hc.maxStale {
+		hc.cache.deleteExpired(key, entry)
+		return nil, false
+	}
+	hc.refreshInBackground(url, key, req, opts)
+	return entry, true
+}
+
+// refreshInBackground repeats req, a request for url, and stores the result
+// under key without blocking the caller. Concurrent refreshes of the same key
+// share a single fetch. With WithBackgroundRefreshLimits, the refresh is
+// skipped when the maximum number already run, and cancelled at the timeout;
+// with WithSharedRefreshLock, it is skipped while another client sharing the
+// store refreshes key.
+func (hc *HTTPClient) refreshInBackground(url, key string, req *http.Request, opts *RequestOptions) {
+	refresh := refetchOptions(req, opts)
+	hc.inBackground(key, func() {
+		if hc.backgroundSem != nil {
+			select {
+			case hc.backgroundSem <- struct{}{}:
+				defer func() { <-hc.backgroundSem }()
+			default:
+				hc.backgroundSkipped.Add(1)
+				return
+			}
+		}
+		if !hc.acquireRefreshLock(key) {
+			hc.backgroundSkipped.Add(1)
+			return
+		}
+		defer hc.releaseRefreshLock(key)
+		hc.backgroundRefreshing.Add(1)
+		defer hc.backgroundRefreshing.Add(-1)
+
+		// The caller's context may end as soon as it has the stale entry.
+		ctx := context.Background()
+		if hc.backgroundTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, hc.backgroundTimeout)
+			defer cancel()
+		}
+		refresh.Context = ctx
+		if _, err := hc.run(url, &refresh); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				hc.backgroundTimedOut.Add(1)
+			}
+			hc.cache.logf("background refresh of %s failed: %v", url, err)
+		}
+	})
+}
+
+// inBackground runs fn in a goroutine that Close waits for. Background work
+// for the same key is deduplicated: while fn runs, later calls for key share
+// it instead of starting their own.
+func (hc *HTTPClient) inBackground(key string, fn func()) {
+	hc.backgroundWG.Add(1)
+	go func() {
+		defer hc.backgroundWG.Done()
+		hc.background.Do(key, func() (interface{}, error) {
+			fn()
+			return nil, nil
+		})
+	}()
+}
+
+// refetchOptions returns options repeating req, made with opts, as a
+// NetworkOnly request. The caller's body may be gone by the time the repeat
+// runs, so the repeat gets a copy of it.
+func refetchOptions(req *http.Request, opts *RequestOptions) RequestOptions {
+	refetch := *opts
+	refetch.Preference = NetworkOnly
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			refetch.Body = bytes.NewReader(data)
+		}
+	}
+	return refetch
+}
+
+// inRevalidationWindow reports whether a revalidation of key started less
+// than the revalidation window ago and is still running.
+func (hc *HTTPClient) inRevalidationWindow(key string) bool {
+	started, ok := hc.revalidating.Load(key)
+	return ok && time.Since(started.(time.Time)) < hc.revalidationWindow
+}
+
+// revalidate refetches the expired entry stale, stored under key for req,
+// and returns the result. Concurrent revalidations of key share a single
+// fetch; while it runs, requests within the revalidation window are served
+// stale without calling revalidate. If the fetch fails, stale is served
+// instead.
+func (hc *HTTPClient) revalidate(url, key string, req *http.Request, opts *RequestOptions, stale *CacheEntry) (*Result, error) {
+	refetch := refetchOptions(req, opts)
+	v, err, shared := hc.revalidations.Do(key, func() (interface{}, error) {
+		hc.revalidating.Store(key, time.Now())
+		defer hc.revalidating.Delete(key)
+		return hc.do(url, &refetch)
+	})
+	r := v.(*Result)
+	if err != nil {
+		if opts.validRead(stale) {
+			hc.hit(key, url, req, stale)
+			return hc.staleResult(stale, StaleOnError), nil
+		}
+		return r, err
+	}
+	if shared {
+		// Each caller owns the body it gets.
+		copied := *r
+		copied.Data = append([]byte(nil), r.Data...)
+		r = &copied
+	}
+	return r, nil
+}
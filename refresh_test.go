@@ -0,0 +1,258 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshOnExpiry(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t, WithRefreshOnExpiry(0))
+	opts := &RequestOptions{TTL: 50 * time.Millisecond}
+
+	if _, err := client.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	r, err := client.Do(server.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 1" || !r.FromCache {
+		t.Fatalf("expired read: data=%q fromCache=%v, want stale response 1", r.Data, r.FromCache)
+	}
+	if !r.Stale || r.StaleReason != StaleRefreshing {
+		t.Errorf("expired read: stale=%v reason=%q", r.Stale, r.StaleReason)
+	}
+
+	key := client.key(server.URL)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entry, expired := client.cache.lookup(key)
+		if entry != nil && !expired && string(entry.Data) == "response 2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry was not refreshed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r, err = client.Do(server.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 2" || !r.FromCache || r.Stale {
+		t.Errorf("read after refresh: data=%q fromCache=%v stale=%v", r.Data, r.FromCache, r.Stale)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestRefreshOnExpiryMaxStale(t *testing.T) {
+	server, hits := countingServer(t)
+	client := newTestClient(t, WithRefreshOnExpiry(10*time.Millisecond))
+	opts := &RequestOptions{TTL: 20 * time.Millisecond}
+
+	if _, err := client.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	r, err := client.Do(server.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Data) != "response 2" || r.FromCache {
+		t.Errorf("data=%q fromCache=%v, want a synchronous fetch past max staleness", r.Data, r.FromCache)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestRevalidationWindow(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		if n > 1 {
+			<-release
+		}
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithRevalidationWindow(time.Minute))
+	opts := &RequestOptions{TTL: 20 * time.Millisecond}
+
+	if _, err := client.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The first request after expiry revalidates and waits for the result.
+	leader := make(chan *Result)
+	go func() {
+		r, err := client.Do(server.URL, opts)
+		if err != nil {
+			t.Error(err)
+		}
+		leader <- r
+	}()
+	for !client.inRevalidationWindow(client.key(server.URL)) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A burst during the revalidation is served stale without waiting.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := client.Do(server.URL, opts)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(r.Data) != "response 1" || !r.Stale || r.StaleReason != StaleRefreshing {
+				t.Errorf("burst: data=%q stale=%v reason=%q, want stale response 1", r.Data, r.Stale, r.StaleReason)
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+
+	r := <-leader
+	if string(r.Data) != "response 2" || r.FromCache {
+		t.Errorf("revalidating request: data=%q fromCache=%v, want the refetched body", r.Data, r.FromCache)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("server hits = %d, want one initial fetch and one revalidation", hits.Load())
+	}
+	if r, _ := client.Do(server.URL, opts); string(r.Data) != "response 2" || !r.FromCache || r.Stale {
+		t.Errorf("after revalidation: data=%q fromCache=%v stale=%v", r.Data, r.FromCache, r.Stale)
+	}
+}
+
+func TestBackgroundRefreshLimits(t *testing.T) {
+	var slow atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	client := newTestClient(t, WithRefreshOnExpiry(0), WithBackgroundRefreshLimits(100*time.Millisecond, 2))
+	opts := &RequestOptions{TTL: 20 * time.Millisecond}
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.Do(fmt.Sprintf("%s/%d", server.URL, i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slow.Store(true)
+	time.Sleep(40 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		r, err := client.Do(fmt.Sprintf("%s/%d", server.URL, i), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Stale {
+			t.Errorf("read %d not served stale", i)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Stats().BackgroundSkipped+client.Stats().BackgroundRefreshing < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := client.Stats()
+	if stats.BackgroundRefreshing > 2 || stats.BackgroundSkipped != 2 {
+		t.Errorf("running = %d, skipped = %d; want at most 2 running and 2 skipped", stats.BackgroundRefreshing, stats.BackgroundSkipped)
+	}
+
+	for s := client.Stats(); (s.BackgroundTimedOut < 2 || s.BackgroundRefreshing > 0) && time.Now().Before(deadline); s = client.Stats() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats = client.Stats()
+	if stats.BackgroundTimedOut != 2 || stats.BackgroundRefreshing != 0 {
+		t.Errorf("timed out = %d, running = %d; want 2 and 0", stats.BackgroundTimedOut, stats.BackgroundRefreshing)
+	}
+}
+
+func TestSharedRefreshLock(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 2 {
+			<-release
+		}
+		fmt.Fprintf(w, "response %d", hits.Load())
+	}))
+	defer server.Close()
+
+	s := NewMemoryStore()
+	newClient := func() *HTTPClient {
+		return newTestClient(t, WithStore(s), WithRefreshOnExpiry(0), WithSharedRefreshLock(time.Minute))
+	}
+	a, b := newClient(), newClient()
+	opts := &RequestOptions{TTL: 20 * time.Millisecond}
+	if _, err := a.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if r, err := a.Do(server.URL, opts); err != nil || !r.Stale {
+		t.Fatalf("a: err=%v, want a stale read", err)
+	}
+	waitFor("a's refresh to start", func() bool { return hits.Load() == 2 })
+	if r, err := b.Do(server.URL, opts); err != nil || !r.Stale {
+		t.Fatalf("b: err=%v, want a stale read", err)
+	}
+	waitFor("b to skip its refresh", func() bool { return b.Stats().BackgroundSkipped == 1 })
+	close(release)
+	waitFor("a's refresh to finish", func() bool { return a.Stats().BackgroundRefreshing == 0 })
+
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2: only one client should refresh", hits.Load())
+	}
+	key := a.key(server.URL)
+	if value, _ := s.Get(refreshLockKey(key)); value != nil {
+		t.Errorf("refresh lock left behind: %s", value)
+	}
+
+	// A marker past its TTL, left by a client that died mid-refresh, does not
+	// hold refreshes back.
+	time.Sleep(50 * time.Millisecond)
+	value, _ := json.Marshal(refreshLock{Owner: "gone", Expires: time.Now().Add(-time.Second)})
+	s.Put(refreshLockKey(key), value)
+	if _, err := b.Do(server.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("b's refresh over the stale lock", func() bool { return hits.Load() == 3 })
+}
@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// refreshLockKey returns the reserved store key of the marker a client
+// sharing the store writes while it refreshes key in the background.
+func refreshLockKey(key string) string {
+	return reservedKeyPrefix + "refresh/" + key
+}
+
+// refreshLock is the stored form of a refresh marker: which client holds it,
+// and until when. A marker past its expiry is stale, left by a client that
+// died or stalled mid-refresh, and may be taken over.
+type refreshLock struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// newRefreshLockOwner returns a token naming this client in the refresh
+// markers it writes, unique across the processes sharing a store.
+func newRefreshLockOwner() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// readRefreshLock returns the marker stored for key, or nil if there is none
+// or it cannot be read.
+func (hc *HTTPClient) readRefreshLock(key string) *refreshLock {
+	value, err := hc.cache.Store.Get(refreshLockKey(key))
+	if err != nil {
+		if !errors.Is(err, leveldb.ErrNotFound) {
+			hc.cache.logf("reading refresh lock for %s: %v", key, err)
+		}
+		return nil
+	}
+	var lock refreshLock
+	if value == nil || json.Unmarshal(value, &lock) != nil {
+		return nil
+	}
+	return &lock
+}
+
+// acquireRefreshLock reports whether this client may refresh key in the
+// background, writing its marker if so. It is always true without
+// WithSharedRefreshLock. The store offers no atomic create, so two clients
+// racing for a free marker both write it; the last write wins, and reading it
+// back tells each whether it was theirs.
+func (hc *HTTPClient) acquireRefreshLock(key string) bool {
+	if hc.refreshLockTTL <= 0 {
+		return true
+	}
+	if held := hc.readRefreshLock(key); held != nil && time.Now().Before(held.Expires) && held.Owner != hc.refreshLockOwner {
+		return false
+	}
+	value, _ := json.Marshal(refreshLock{Owner: hc.refreshLockOwner, Expires: time.Now().Add(hc.refreshLockTTL)})
+	if err := hc.cache.Store.Put(refreshLockKey(key), value); err != nil {
+		hc.cache.logf("writing refresh lock for %s: %v", key, err)
+		return false
+	}
+	held := hc.readRefreshLock(key)
+	return held != nil && held.Owner == hc.refreshLockOwner
+}
+
+// releaseRefreshLock deletes the marker for key if this client still holds
+// it; one that expired and was taken over belongs to its new owner.
+func (hc *HTTPClient) releaseRefreshLock(key string) {
+	if hc.refreshLockTTL <= 0 {
+		return
+	}
+	if held := hc.readRefreshLock(key); held != nil && held.Owner == hc.refreshLockOwner {
+		if err := hc.cache.Store.Delete(refreshLockKey(key)); err != nil {
+			hc.cache.logf("deleting refresh lock for %s: %v", key, err)
+		}
+	}
+}
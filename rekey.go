@@ -0,0 +1,204 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Key returns the store key the entry for a plain GET of url is stored
+// under, with the scheme folding, URL normalization, query parameter
+// filtering and hasher the client is configured with. Tools reading the
+// store directly should look entries up with it rather than hash URLs
+// themselves.
+func (hc *HTTPClient) Key(url string) string {
+	return hc.key(url)
+}
+
+// key returns the store key for a plain GET of url, as issued with no
+// per-call headers.
+func (hc *HTTPClient) key(url string) string {
+	req, err := hc.newRequest(context.Background(), http.MethodGet, url, nil, nil)
+	if err != nil {
+		return hc.keyFunc(url)
+	}
+	return hc.requestKey(url, req, "")
+}
+
+// requestKey returns the store key for req, a request for url whose body, if
+// it has one, hashes to bodyHash.
+func (hc *HTTPClient) requestKey(url string, req *http.Request, bodyHash string) string {
+	return hc.inputKey(hc.keyInput(url, req, bodyHash), req)
+}
+
+// inputKey returns the store key for req, whose key input is input: the
+// WithKeyFromRequest hook's choice if there is one, or else the hash of
+// input.
+func (hc *HTTPClient) inputKey(input string, req *http.Request) string {
+	if hc.keyFromRequest != nil {
+		return hc.keyFromRequest(req)
+	}
+	return hc.keyFunc(input)
+}
+
+// DeleteRequest removes the entry req would be served from, building the
+// request with the client's headers layered under req's as Do would, and
+// hashing req's body, if any, for methods other than GET. It is the
+// counterpart of DeleteURL for clients whose WithKeyFromRequest hook keys
+// entries by more than the URL.
+func (hc *HTTPClient) DeleteRequest(req *http.Request) error {
+	url := req.URL.String()
+	built, err := hc.newRequest(req.Context(), req.Method, url, req.Header, nil)
+	if err != nil {
+		return err
+	}
+	built.Host = req.Host
+	bodyHash := ""
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := prepareBody(req.Body)
+		if err != nil {
+			return err
+		}
+		body.attach(built)
+		bodyHash = body.hash
+	}
+	return hc.cache.Delete(hc.requestKey(url, built, bodyHash))
+}
+
+// keyInput returns the canonical text identifying req that is hashed into
+// its store key. The key of a GET is derived from url alone unless the client
+// folds request headers, such as Accept or the credentials, into it, or the
+// request overrides the Host header; other methods add the method and the
+// body hash, so the key of a GET is unchanged by the addition of method-aware
+// keys.
+func (hc *HTTPClient) keyInput(url string, req *http.Request, bodyHash string) string {
+	if !hc.schemeInKey && strings.HasPrefix(url, "https://") {
+		url = "http://" + strings.TrimPrefix(url, "https://")
+	}
+	url = hc.normalizeKeyURL(url)
+	input := url
+	if req.Method != http.MethodGet {
+		input = req.Method + " " + url
+		if bodyHash != "" {
+			input += "\nBody: " + bodyHash
+		}
+	}
+	if req.Host != "" && req.Host != req.URL.Host {
+		input += "\nHost: " + req.Host
+	}
+	if hc.acceptInKey {
+		if accept := req.Header.Get("Accept"); accept != "" {
+			input += "\nAccept: " + accept
+		}
+	}
+	if hc.authInKey {
+		input += authKey(req)
+	}
+	return input
+}
+
+// defaultKeyLabelLen is the length key inputs are cut to for display unless
+// WithKeyLabelLength changes it.
+const defaultKeyLabelLen = 200
+
+// KeyInput returns the text the store key of a plain GET of url is hashed
+// from, in the printable, bounded form used in log messages.
+func (hc *HTTPClient) KeyInput(url string) string {
+	req, err := hc.newRequest(context.Background(), http.MethodGet, url, nil, nil)
+	if err != nil {
+		return hc.keyLabel(url)
+	}
+	return hc.keyLabel(hc.keyInput(url, req, ""))
+}
+
+// keyLabel renders a key input for logs: quoted, so separators and control
+// characters show as escapes, and cut to the configured length. A cut input
+// is followed by its full length and SHA-256 digest, so distinct inputs
+// never share a label however long their common prefix.
+func (hc *HTTPClient) keyLabel(input string) string {
+	max := hc.keyLabelLen
+	if max <= 0 {
+		max = defaultKeyLabelLen
+	}
+	if len(input) <= max {
+		return strconv.Quote(input)
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(input[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...(%d bytes, sha256:%s)", strconv.Quote(input[:cut]), len(input), hashKey(input))
+}
+
+// Rekey moves entries written under oldKeyFn to the keys newKeyFn gives
+// their URLs, so a change of key scheme does not leave the cache cold.
+// Entries whose key does not match oldKeyFn, such as variants keyed by
+// request headers, are left alone. When several entries map to the same new
+// key, the most recently crawled one wins and the others are deleted. Rekey
+// returns the number of entries now stored under new keys.
+//
+// Rekey should run while nothing else writes to the cache.
+func (c *Cache) Rekey(oldKeyFn, newKeyFn func(url string) string) (int, error) {
+	type move struct {
+		from, to  string
+		crawledAt time.Time
+	}
+	var moves []move
+	err := c.forEachStored(func(key string, entry *CacheEntry) error {
+		if entry.URL == "" || oldKeyFn(entry.URL) != key {
+			return nil
+		}
+		if to := newKeyFn(entry.URL); to != key {
+			moves = append(moves, move{from: key, to: to, crawledAt: entry.CrawledAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := make(map[string]bool)
+	for _, m := range moves {
+		value, err := c.Store.Get(m.from)
+		if err != nil || value == nil {
+			continue
+		}
+		if existing := c.storedEntry(m.to); existing != nil && existing.CrawledAt.After(m.crawledAt) {
+			if err := c.Delete(m.from); err != nil {
+				return len(moved), err
+			}
+			continue
+		}
+		if err := c.replaceRaw(m.to, value); err != nil {
+			return len(moved), err
+		}
+		// The moved value keeps its content reference, so the old key is
+		// removed without releasing it.
+		if err := c.Store.Delete(m.from); err != nil {
+			return len(moved), err
+		}
+		moved[m.to] = true
+	}
+	return len(moved), nil
+}
+
+// replaceRaw stores an already-encoded entry under key, releasing whatever
+// the entry it replaces referenced.
+func (c *Cache) replaceRaw(key string, value []byte) error {
+	c.refMu.Lock()
+	defer c.refMu.Unlock()
+	if err := c.releaseContent(key); err != nil {
+		return err
+	}
+	return c.Store.Put(key, value)
+}
+
+// Rekey migrates the client's entries from oldKeyFn to newKeyFn. See
+// Cache.Rekey.
+func (hc *HTTPClient) Rekey(oldKeyFn, newKeyFn func(url string) string) (int, error) {
+	return hc.cache.Rekey(oldKeyFn, newKeyFn)
+}
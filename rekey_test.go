@@ -0,0 +1,155 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRekey(t *testing.T) {
+	client := newTestClient(t)
+	c := client.cache
+
+	// The new scheme ignores case, so the two example.com URLs collide.
+	newKey := func(url string) string { return HashKey(strings.ToLower(url)) }
+
+	c.Set(HashKey("http://Example.com/a"), []byte("older"), "http://Example.com/a", "", time.Minute)
+	time.Sleep(time.Millisecond)
+	c.Set(HashKey("http://example.COM/a"), []byte("newer"), "http://example.COM/a", "", time.Minute)
+	c.Set(HashKey("http://other.com/b"), []byte("b"), "http://other.com/b", "", time.Minute)
+
+	migrated, err := client.Rekey(HashKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 {
+		t.Errorf("migrated = %d, want 1", migrated)
+	}
+
+	client.keyFunc = newKey
+	data, err := client.Do("http://example.com/a", &RequestOptions{Preference: CacheOnly})
+	if err != nil {
+		t.Fatalf("rekeyed entry not found: %v", err)
+	}
+	if string(data.Data) != "newer" {
+		t.Errorf("collision kept %q, want the newest entry", data.Data)
+	}
+	if data, err := client.Do("http://other.com/b", &RequestOptions{Preference: CacheOnly}); err != nil || string(data.Data) != "b" {
+		t.Errorf("other.com entry: %q, %v", data.Data, err)
+	}
+
+	var keys int
+	c.forEachStored(func(key string, entry *CacheEntry) error {
+		keys++
+		if key != newKey(entry.URL) {
+			t.Errorf("entry %s left under old key", entry.URL)
+		}
+		return nil
+	})
+	if keys != 2 {
+		t.Errorf("store holds %d entries, want 2", keys)
+	}
+}
+
+func TestSchemeInKey(t *testing.T) {
+	server, hits := countingServer(t)
+	httpsURL := "https://" + strings.TrimPrefix(server.URL, "http://") + "/page"
+
+	for _, include := range []bool{true, false} {
+		client := newTestClient(t, WithSchemeInKey(include))
+		if _, err := client.Get(server.URL + "/page"); err != nil {
+			t.Fatal(err)
+		}
+		r, err := client.Do(httpsURL, &RequestOptions{Preference: CacheOnly})
+		if include && err != ErrCacheMiss {
+			t.Errorf("scheme in key: https lookup err = %v, want a miss", err)
+		}
+		if !include && (err != nil || !r.FromCache) {
+			t.Errorf("scheme not in key: https lookup err = %v, want the http entry", err)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestKeyLabel(t *testing.T) {
+	client := newTestClient(t, WithKeyLabelLength(64))
+	long := "http://example.com/?q=" + strings.Repeat("é", 1<<20)
+
+	label := client.KeyInput(long)
+	if len(label) > 64*2+100 {
+		t.Errorf("label is %d bytes, want it bounded", len(label))
+	}
+	if label != client.KeyInput(long) {
+		t.Error("label is not stable")
+	}
+	if !strings.Contains(label, HashKey(long)) {
+		t.Errorf("cut label %q lacks the digest of the full input", label)
+	}
+	if other := client.KeyInput(long + "x"); other == label {
+		t.Error("inputs differing past the cut share a label")
+	}
+	if client.key(long) != HashKey(long) {
+		t.Error("label length changed the store key")
+	}
+
+	if got, want := client.keyLabel("POST http://example.com/\nBody: abc"), `"POST http://example.com/\nBody: abc"`; got != want {
+		t.Errorf("short label = %s, want %s", got, want)
+	}
+}
+
+func TestKeyFromRequest(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %d", r.URL.Path, r.Header.Get("X-Tenant"), hits.Add(1))
+	}))
+	defer server.Close()
+
+	// Ignore the query and trailing slashes, but keep tenants apart.
+	client := newTestClient(t, WithKeyFromRequest(func(req *http.Request) string {
+		return hashKey(req.URL.Host + strings.TrimSuffix(req.URL.Path, "/") + "\n" + req.Header.Get("X-Tenant"))
+	}))
+	get := func(url, tenant string) *Result {
+		t.Helper()
+		r, err := client.Do(url, &RequestOptions{Header: http.Header{"X-Tenant": {tenant}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	first := get(server.URL+"/page/?utm=1", "a")
+	if r := get(server.URL+"/page?utm=2", "a"); !r.FromCache || string(r.Data) != string(first.Data) {
+		t.Errorf("folded URL = %q (cached %v), want the first response", r.Data, r.FromCache)
+	}
+	if r := get(server.URL+"/page", "b"); r.FromCache {
+		t.Errorf("tenant b was served tenant a's entry %q", r.Data)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/page", nil)
+	req.Header.Set("X-Tenant", "a")
+	if err := client.DeleteRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if r := get(server.URL+"/page", "a"); r.FromCache {
+		t.Error("entry still cached after DeleteRequest")
+	}
+	if r := get(server.URL+"/page", "b"); !r.FromCache {
+		t.Error("DeleteRequest removed another tenant's entry")
+	}
+}
+
+func TestKeyFromRequestDefault(t *testing.T) {
+	client := newTestClient(t)
+	if got, want := client.key("http://example.com/a"), hashKey("http://example.com/a"); got != want {
+		t.Errorf("default key = %s, want sha256 of the URL %s", got, want)
+	}
+}
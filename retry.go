@@ -0,0 +1,170 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// fetch performs req, a live request for url, retrying it as configured by
+// WithRetries and, when a rotating User-Agent is blocked, resending it with
+// the next one (see WithUserAgents). The entry and error are those of the
+// last attempt. URLs on the dead-letter list (see WithDeadLetters) are not
+// fetched at all.
+func (hc *HTTPClient) fetch(url string, req *http.Request, maxBytes int64) (*CacheEntry, error) {
+	if err := hc.deadLetters.check(url); err != nil {
+		return &CacheEntry{URL: url}, err
+	}
+	entry, err := hc.fetchAttempts(url, req, maxBytes)
+	hc.deadLetters.record(url, entry, err)
+	return entry, err
+}
+
+// fetchAttempts is fetch without the dead-letter list.
+func (hc *HTTPClient) fetchAttempts(url string, req *http.Request, maxBytes int64) (*CacheEntry, error) {
+	rotations := 0
+	for attempt := 0; ; {
+		entry, err := hc.fetchOnce(url, req, maxBytes)
+		if agent, ok := hc.userAgents.rotate(req, entry, err); ok && rotations < len(hc.userAgents.agents)-1 {
+			next, ok := resendable(req)
+			if !ok {
+				return entry, err
+			}
+			next.Header.Set("User-Agent", agent)
+			req = next
+			rotations++
+			continue
+		}
+		if attempt >= hc.retries || !(retryable(entry, err) || hc.suspectBody(url, req.Method, entry, err)) {
+			return entry, err
+		}
+		next, ok := resendable(req)
+		if !ok {
+			return entry, err
+		}
+		req = next
+		timer := time.NewTimer(hc.retryBackoff(attempt))
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return entry, err
+		}
+		attempt++
+	}
+}
+
+// suspectBody reports whether entry, fetched for url with method, is a 200
+// OK whose body is shorter than WithRetryOnEmptyBody accepts.
+func (hc *HTTPClient) suspectBody(url, method string, entry *CacheEntry, err error) bool {
+	if hc.minBodyBytes <= 0 || err != nil || entry.StatusCode != http.StatusOK ||
+		method == http.MethodHead || len(entry.Data) >= hc.minBodyBytes {
+		return false
+	}
+	policy := hc.cache.Policy(url)
+	return policy == nil || !policy.AllowEmpty
+}
+
+// resendable returns a copy of req that can be sent again, with its body
+// rewound, or false if the body cannot be replayed.
+func resendable(req *http.Request) (*http.Request, bool) {
+	next := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		next.Body = body
+	}
+	return next, true
+}
+
+// retryBackoff is the wait before retry number attempt+1, as the Backoff set
+// with WithBackoff gives it, capped at the maximum delay. Without one it is
+// an ExponentialBackoff from the retry delay, with jitter.
+func (hc *HTTPClient) retryBackoff(attempt int) time.Duration {
+	backoff := hc.backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff{Delay: hc.retryDelay, Jitter: true}
+	}
+	d := backoff.Next(attempt)
+	if hc.maxRetryDelay > 0 && d > hc.maxRetryDelay {
+		d = hc.maxRetryDelay
+	}
+	return d
+}
+
+// Backoff decides how long to wait between retries. Next returns the wait
+// before retry number attempt+1, attempt counting from 0; the client caps it
+// at the maximum delay given to WithRetries.
+type Backoff interface {
+	Next(attempt int) time.Duration
+}
+
+// ExponentialBackoff waits Delay before the first retry and doubles the wait
+// for each one after. With Jitter each wait is picked at random between half
+// of that and all of it, so clients that failed together do not all retry
+// at the same moment.
+type ExponentialBackoff struct {
+	Delay  time.Duration
+	Jitter bool
+}
+
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	d := b.Delay
+	for i := 0; i < attempt && d > 0 && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if b.Jitter && d > 1 {
+		d = d/2 + rand.N(d-d/2)
+	}
+	return d
+}
+
+// ConstantBackoff waits Delay before every retry.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) Next(attempt int) time.Duration {
+	return b.Delay
+}
+
+// LinearBackoff waits Delay before the first retry and Delay longer before
+// each one after.
+type LinearBackoff struct {
+	Delay time.Duration
+}
+
+func (b LinearBackoff) Next(attempt int) time.Duration {
+	if n := time.Duration(attempt + 1); b.Delay > 0 && n > math.MaxInt64/b.Delay {
+		return math.MaxInt64
+	}
+	return b.Delay * time.Duration(attempt+1)
+}
+
+// retryable reports whether a fetch that ended with entry and err is worth
+// repeating: transport failures, truncated bodies, 429 and 5xx responses.
+// Cancellation and limits the client imposes itself are final.
+func retryable(entry *CacheEntry, err error) bool {
+	var short *ShortBodyError
+	var bomb *DecompressionLimitError
+	var blocked *BlockedAddressError
+	switch {
+	case err == nil:
+		return entry.StatusCode == http.StatusTooManyRequests || entry.StatusCode >= 500
+	case errors.As(err, &short):
+		return true
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrTooBusy), errors.Is(err, ErrBodyTooLarge), errors.As(err, &bomb),
+		errors.As(err, &blocked):
+		return false
+	}
+	return true
+}
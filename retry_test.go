@@ -0,0 +1,158 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShortBody(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		full := "0123456789abcdef"
+		w.Header().Set("Content-Length", "16")
+		if hits.Add(1) == 1 {
+			// Advertise the full length but stop halfway, as a cut-off
+			// download does.
+			w.Write([]byte(full[:8]))
+			return
+		}
+		w.Write([]byte(full))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t)
+	_, err := client.Get(server.URL)
+	var short *ShortBodyError
+	if !errors.As(err, &short) {
+		t.Fatalf("err = %v, want *ShortBodyError", err)
+	}
+	if short.Got != 8 || short.Want != 16 {
+		t.Errorf("ShortBodyError = %+v, want 8 of 16 bytes", short)
+	}
+	if _, err := client.Do(server.URL, &RequestOptions{Preference: CacheOnly}); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("short body was cached: err = %v", err)
+	}
+
+	hits.Store(0)
+	client = newTestClient(t, WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	data, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789abcdef" || hits.Load() != 2 {
+		t.Errorf("got %q after %d fetches, want the full body on the retry", data, hits.Load())
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	client := newTestClient(t, WithRetries(5, 10*time.Millisecond, 50*time.Millisecond),
+		WithBackoff(ExponentialBackoff{Delay: 10 * time.Millisecond}))
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for attempt, w := range want {
+		if got := client.retryBackoff(attempt); got != w*time.Millisecond {
+			t.Errorf("retryBackoff(%d) = %v, want %v", attempt, got, w*time.Millisecond)
+		}
+	}
+
+	// By default the waits are jittered below the exponential ones.
+	client = newTestClient(t, WithRetries(5, 10*time.Millisecond, 50*time.Millisecond))
+	for attempt, w := range want {
+		w *= time.Millisecond
+		if got := client.retryBackoff(attempt); got < w/2 || got > w {
+			t.Errorf("jittered retryBackoff(%d) = %v, want %v to %v", attempt, got, w/2, w)
+		}
+	}
+}
+
+func TestBackoffStrategies(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		want    []time.Duration
+	}{
+		{"exponential", ExponentialBackoff{Delay: time.Second}, []time.Duration{1, 2, 4, 8, 16}},
+		{"constant", ConstantBackoff{Delay: time.Second}, []time.Duration{1, 1, 1, 1, 1}},
+		{"linear", LinearBackoff{Delay: time.Second}, []time.Duration{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		for attempt, w := range tt.want {
+			if got := tt.backoff.Next(attempt); got != w*time.Second {
+				t.Errorf("%s: Next(%d) = %v, want %v", tt.name, attempt, got, w*time.Second)
+			}
+		}
+	}
+
+	jittered := ExponentialBackoff{Delay: time.Second, Jitter: true}
+	for attempt := 0; attempt < 5; attempt++ {
+		full := time.Second << attempt
+		for i := 0; i < 20; i++ {
+			if got := jittered.Next(attempt); got < full/2 || got > full {
+				t.Fatalf("jittered Next(%d) = %v, want %v to %v", attempt, got, full/2, full)
+			}
+		}
+	}
+	if got := (ExponentialBackoff{Delay: time.Second}).Next(100); got <= 0 {
+		t.Errorf("Next(100) = %v, want no overflow", got)
+	}
+}
+
+func TestRetryOnEmptyBody(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n == 1 {
+				return // 200 with an empty body
+			}
+			w.Write([]byte("real content"))
+		case "/short":
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	retries := WithRetries(2, time.Millisecond, 10*time.Millisecond)
+	client := newTestClient(t, retries, WithRetryOnEmptyBody(4))
+	data, err := client.Get(server.URL + "/flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "real content" || hits.Load() != 2 {
+		t.Errorf("got %q after %d fetches, want the real content on the retry", data, hits.Load())
+	}
+
+	// A body short every time is returned once the retries run out, but
+	// not cached.
+	hits.Store(0)
+	data, err = client.Get(server.URL + "/short")
+	if err != nil || string(data) != "ok" || hits.Load() != 3 {
+		t.Errorf("got %q, %v after %d fetches, want the short body after 3", data, err, hits.Load())
+	}
+	if _, err := client.Do(server.URL+"/short", &RequestOptions{Preference: CacheOnly}); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("short body was cached: err = %v", err)
+	}
+
+	// Policies can allow short bodies.
+	policies, err := ParsePolicies(strings.NewReader("/short=1m allow_empty:true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowing, err := NewClient(t.TempDir(), policies, retries, WithRetryOnEmptyBody(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer allowing.Close()
+	hits.Store(0)
+	if _, err := allowing.Get(server.URL + "/short"); err != nil || hits.Load() != 1 {
+		t.Errorf("allowed short body: %v after %d fetches, want 1", err, hits.Load())
+	}
+	if _, err := allowing.Do(server.URL+"/short", &RequestOptions{Preference: CacheOnly}); err != nil {
+		t.Errorf("allowed short body was not cached: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/liuzl/store"
+)
+
+// Serializer encodes cache entries into stored values. Values written by a
+// serializer other than GobSerializer start with its marker byte, so a store
+// holding a mix of formats, for example during a migration, stays readable.
+type Serializer interface {
+	// Name identifies the format to people, e.g. "json".
+	Name() string
+	// Marker is the first byte of every value the serializer writes. It must
+	// lie in 0x80-0xF7, a range gob never starts a value with; 0 is reserved
+	// for GobSerializer, whose values carry no marker.
+	Marker() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = map[byte]Serializer{}
+)
+
+func init() {
+	RegisterSerializer(JSONSerializer{})
+}
+
+// RegisterSerializer makes a serializer available for reading values carrying
+// its marker. JSONSerializer is registered by default.
+func RegisterSerializer(s Serializer) {
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+	serializers[s.Marker()] = s
+}
+
+// DetectSerializer returns the serializer that wrote value.
+func DetectSerializer(value []byte) (Serializer, error) {
+	if len(value) == 0 || value[0] < 0x80 || value[0] > 0xF7 {
+		return GobSerializer{}, nil
+	}
+	serializersMu.RLock()
+	s, ok := serializers[value[0]]
+	serializersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no serializer registered for marker %#x", value[0])
+	}
+	return s, nil
+}
+
+// Unmarshal decodes a stored value into v, whichever registered serializer
+// wrote it.
+func Unmarshal(value []byte, v interface{}) error {
+	s, err := DetectSerializer(value)
+	if err != nil {
+		return err
+	}
+	if s.Marker() != 0 {
+		value = value[1:]
+	}
+	return s.Unmarshal(value, v)
+}
+
+// marshal encodes v with the cache's serializer, gob by default.
+func (c *Cache) marshal(v interface{}) ([]byte, error) {
+	if c.Serializer == nil || c.Serializer.Marker() == 0 {
+		return store.ObjectToBytes(v)
+	}
+	data, err := c.Serializer.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.Serializer.Marker()}, data...), nil
+}
+
+// GobSerializer is the compact binary format entries have always been stored
+// in. It is the default.
+type GobSerializer struct{}
+
+func (GobSerializer) Name() string { return "gob" }
+func (GobSerializer) Marker() byte { return 0 }
+
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	return store.ObjectToBytes(v)
+}
+
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return store.BytesToObject(data, v)
+}
+
+// JSONSerializer stores entries as JSON, which is larger and slower than gob
+// but readable with ordinary tools when debugging.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Name() string { return "json" }
+func (JSONSerializer) Marker() byte { return 0x80 }
+
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
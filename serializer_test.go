@@ -0,0 +1,58 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSerializerRoundTrip(t *testing.T) {
+	for _, s := range []Serializer{GobSerializer{}, JSONSerializer{}} {
+		t.Run(s.Name(), func(t *testing.T) {
+			c := &Cache{Serializer: s}
+			in := CacheEntry{
+				Data:       []byte("body"),
+				URL:        "http://example.com/",
+				FinalURL:   "http://example.com/final",
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+				TTL:        time.Minute,
+				CrawledAt:  time.Now().UTC().Truncate(time.Second),
+			}
+			value, err := c.marshal(&in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			detected, err := DetectSerializer(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected.Name() != s.Name() {
+				t.Errorf("detected %s, want %s", detected.Name(), s.Name())
+			}
+			var out CacheEntry
+			if err := Unmarshal(value, &out); err != nil {
+				t.Fatal(err)
+			}
+			if string(out.Data) != "body" || out.URL != in.URL || out.FinalURL != in.FinalURL ||
+				out.StatusCode != in.StatusCode || out.Header.Get("Content-Type") != "text/plain" ||
+				out.TTL != in.TTL || !out.CrawledAt.Equal(in.CrawledAt) {
+				t.Errorf("round trip = %+v, want %+v", out, in)
+			}
+		})
+	}
+}
+
+func TestMixedSerializers(t *testing.T) {
+	client := newTestClient(t)
+	client.cache.Set("gob", []byte("old"), "http://example.com/old", "", time.Minute)
+	client.cache.Serializer = JSONSerializer{}
+	client.cache.Set("json", []byte("new"), "http://example.com/new", "", time.Minute)
+
+	for key, want := range map[string]string{"gob": "old", "json": "new"} {
+		data, _, ok := client.cache.Get(key)
+		if !ok || string(data) != want {
+			t.Errorf("Get(%q) = %q, %v; want %q", key, data, ok, want)
+		}
+	}
+}
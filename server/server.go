@@ -0,0 +1,68 @@
+// Package server exposes an httpcache client over HTTP, so that programs in
+// any language can share one warm cache.
+//
+// The server answers GET /fetch?url=<url> with the body the client gets for
+// url, read from the cache or fetched as its policies direct, with the
+// origin's status code and Content-Type and the headers:
+//
+//	X-Final-URL   the URL the body was served from, after redirects
+//	X-Cache       HIT or MISS, and STALE for expired bodies served anyway
+//	Age           seconds since a cached body was fetched
+//
+// A fetch that fails is answered with 502 Bad Gateway.
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/crawlerclub/httpcache"
+)
+
+// Handler returns the handler serving /fetch from client.
+func Handler(client *httpcache.HTTPClient) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fetch", func(w http.ResponseWriter, r *http.Request) {
+		url := r.URL.Query().Get("url")
+		if url == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+		result, err := client.Do(url, &httpcache.RequestOptions{Context: r.Context()})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("fetching %s: %v", url, err), http.StatusBadGateway)
+			return
+		}
+		header := w.Header()
+		if contentType := result.Header.Get("Content-Type"); contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("X-Final-URL", result.FinalURL)
+		switch {
+		case result.Stale:
+			header.Set("X-Cache", "STALE")
+		case result.FromCache:
+			header.Set("X-Cache", "HIT")
+		default:
+			header.Set("X-Cache", "MISS")
+		}
+		if result.FromCache && !result.CrawledAt.IsZero() {
+			header.Set("Age", strconv.Itoa(int(time.Since(result.CrawledAt).Seconds())))
+		}
+		status := result.StatusCode
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		w.Write(result.Data)
+	})
+	return mux
+}
+
+// Serve listens on addr and serves /fetch from client until it fails, as
+// http.ListenAndServe does.
+func Serve(addr string, client *httpcache.HTTPClient) error {
+	return http.ListenAndServe(addr, Handler(client))
+}
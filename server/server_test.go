@@ -0,0 +1,76 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/crawlerclub/httpcache"
+)
+
+func TestServer(t *testing.T) {
+	var hits int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("page body"))
+	}))
+	defer origin.Close()
+
+	policies := []httpcache.CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := httpcache.NewClient(t.TempDir(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	srv := httptest.NewServer(Handler(client))
+	defer srv.Close()
+
+	fetch := func(target string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/fetch?url=" + url.QueryEscape(target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	for i, want := range []string{"MISS", "HIT"} {
+		resp, body := fetch(origin.URL + "/old")
+		if resp.StatusCode != http.StatusOK || body != "page body" {
+			t.Fatalf("request %d: %d %q", i, resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("X-Cache"); got != want {
+			t.Errorf("request %d: X-Cache = %q, want %q", i, got, want)
+		}
+		if got := resp.Header.Get("X-Final-URL"); got != origin.URL+"/page" {
+			t.Errorf("request %d: X-Final-URL = %q", i, got)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("request %d: Content-Type = %q", i, got)
+		}
+		if _, ok := resp.Header["Age"]; ok != (want == "HIT") {
+			t.Errorf("request %d: Age = %q", i, resp.Header.Get("Age"))
+		}
+	}
+	if hits != 2 {
+		t.Errorf("origin hits = %d, want 2 for the redirect and the page", hits)
+	}
+
+	if resp, _ := fetch(""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing url: status %d, want 400", resp.StatusCode)
+	}
+	if resp, _ := fetch("http://127.0.0.1:1/"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("failed fetch: status %d, want 502", resp.StatusCode)
+	}
+}
@@ -0,0 +1,166 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/liuzl/store"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// shardReplicas is the number of points each shard has on the hash ring.
+const shardReplicas = 64
+
+// ShardedStore is a Store spreading keys over several LevelDB stores, for
+// caches too large for one directory or disk. Keys are assigned to shards by
+// consistent hashing, so adding a shard moves only the keys it takes over,
+// about 1/N of them, which then miss once and are fetched again. Shards are
+// identified by their position, so the directories must be given in the same
+// order every time and new ones appended.
+type ShardedStore struct {
+	shards []*store.LevelStore
+	dirs   []string
+	ring   []uint32 // sorted hash points
+	owner  map[uint32]int
+}
+
+// NewShardedStore opens a LevelDB store in each of dirs.
+func NewShardedStore(dirs []string) (*ShardedStore, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("httpcache: no shard directories")
+	}
+	s := &ShardedStore{dirs: dirs, owner: make(map[uint32]int)}
+	for i, dir := range dirs {
+		db, err := store.NewLevelStore(dir)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("httpcache: opening shard %s: %w", dir, err)
+		}
+		s.shards = append(s.shards, db)
+		for r := 0; r < shardReplicas; r++ {
+			point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + strconv.Itoa(r)))
+			if _, taken := s.owner[point]; taken {
+				continue
+			}
+			s.owner[point] = i
+			s.ring = append(s.ring, point)
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+	return s, nil
+}
+
+// shard returns the index of the shard key belongs to.
+func (s *ShardedStore) shard(key string) int {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.owner[s.ring[i]]
+}
+
+// Get reads key from the shard it belongs to.
+func (s *ShardedStore) Get(key string) ([]byte, error) {
+	return s.shards[s.shard(key)].Get(key)
+}
+
+// Put writes key to the shard it belongs to.
+func (s *ShardedStore) Put(key string, value []byte) error {
+	return s.shards[s.shard(key)].Put(key, value)
+}
+
+// Delete removes key from the shard it belongs to.
+func (s *ShardedStore) Delete(key string) error {
+	return s.shards[s.shard(key)].Delete(key)
+}
+
+// ForEach visits the keys of all shards in slice in ascending order,
+// merging the shards as it goes, so cursors into it work as they do for a
+// single store. It stays ordered, rather than walking the shards in
+// parallel, because ListEntries, PurgeExpired and the other paginated scans
+// resume from the last key they saw and would skip keys otherwise. The
+// per-entry work of PurgeExpired and the other deleting scans is spread
+// over Cache.MaintenanceWorkers instead.
+func (s *ShardedStore) ForEach(slice *util.Range, callback func(key, value []byte) (bool, error)) error {
+	iters := make([]iterator.Iterator, 0, len(s.shards))
+	defer func() {
+		for _, it := range iters {
+			it.Release()
+		}
+	}()
+	var live []iterator.Iterator
+	for _, shard := range s.shards {
+		it := shard.DB().NewIterator(slice, nil)
+		iters = append(iters, it)
+		if it.Next() {
+			live = append(live, it)
+		}
+	}
+	for len(live) > 0 {
+		min := 0
+		for i := 1; i < len(live); i++ {
+			if bytes.Compare(live[i].Key(), live[min].Key()) < 0 {
+				min = i
+			}
+		}
+		it := live[min]
+		cont, err := callback(it.Key(), it.Value())
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+		if !it.Next() {
+			if err := it.Error(); err != nil {
+				return err
+			}
+			live = append(live[:min], live[min+1:]...)
+		}
+	}
+	return nil
+}
+
+// Check probes every shard concurrently and returns the errors of those that
+// fail to answer, or nil if all are healthy.
+func (s *ShardedStore) Check() error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard *store.LevelStore) {
+			defer wg.Done()
+			_, err := shard.Get(reservedKeyPrefix + "check")
+			if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+				errs[i] = fmt.Errorf("shard %s: %w", s.dirs[i], err)
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close closes every shard, returning the errors of those that fail.
+func (s *ShardedStore) Close() error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard *store.LevelStore) {
+			defer wg.Done()
+			if err := shard.Close(); err != nil {
+				errs[i] = fmt.Errorf("shard %s: %w", s.dirs[i], err)
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
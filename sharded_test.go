@@ -0,0 +1,77 @@
+package httpcache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestShardedStore(t *testing.T) {
+	root := t.TempDir()
+	dirs := []string{filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c")}
+	s, err := NewShardedStore(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 300
+	var keys []string
+	for i := 0; i < n; i++ {
+		key := HashKey(fmt.Sprintf("http://example.com/%d", i))
+		keys = append(keys, key)
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, shard := range s.shards {
+		count := 0
+		shard.ForEach(nil, func(_, _ []byte) (bool, error) { count++; return true, nil })
+		if count < n/10 {
+			t.Errorf("shard %d holds %d of %d keys", i, count, n)
+		}
+	}
+
+	var visited []string
+	err = s.ForEach(nil, func(key, value []byte) (bool, error) {
+		if string(key) != string(value) {
+			t.Errorf("key %s has value %s", key, value)
+		}
+		visited = append(visited, string(key))
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(visited) != n || !sort.StringsAreSorted(visited) {
+		t.Fatalf("ForEach visited %d keys, sorted %v; want %d in order", len(visited), sort.StringsAreSorted(visited), n)
+	}
+	if err := s.Check(); err != nil {
+		t.Errorf("Check on healthy shards: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Check(); err == nil {
+		t.Error("Check on closed shards succeeded")
+	}
+
+	// Reopened, every key is found on the shard it was written to.
+	s, err = NewShardedStore(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, WithStore(s))
+	for _, key := range keys {
+		if value, err := s.Get(key); err != nil || string(value) != key {
+			t.Fatalf("Get(%s) = %q, %v after reopening", key, value, err)
+		}
+	}
+	url := "http://example.com/entry"
+	client.cache.Set(client.key(url), []byte("body"), url, url, time.Minute)
+	if data, _, found := client.cache.Get(client.key(url)); !found || string(data) != "body" {
+		t.Errorf("entry through sharded store = %q, %v", data, found)
+	}
+}
@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// BlockedAddressError is returned for requests that would connect to an
+// address the SSRF guard of WithSSRFGuard refuses.
+type BlockedAddressError struct {
+	Addr netip.Addr
+}
+
+func (e *BlockedAddressError) Error() string {
+	return fmt.Sprintf("httpcache: connection to internal address %s blocked", e.Addr)
+}
+
+// ssrfGuard refuses connections to internal addresses outside allow.
+type ssrfGuard struct {
+	allow []netip.Prefix
+}
+
+// control is a net.Dialer Control function. It runs once the host has been
+// resolved and just before connecting, so the address it checks is the one
+// connected to, whatever a later DNS answer for the host would say.
+func (g *ssrfGuard) control(network, address string, _ syscall.RawConn) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return nil // unix sockets are configured, not user-supplied
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !internalAddr(addr) {
+		return nil
+	}
+	for _, prefix := range g.allow {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return &BlockedAddressError{Addr: addr}
+}
+
+// internalAddr reports whether addr belongs to the host itself or a private
+// network: loopback, private, link-local, unspecified and multicast
+// addresses, including the cloud metadata service at 169.254.169.254.
+func internalAddr(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsUnspecified() || addr.IsMulticast()
+}
+
+// guardDialer makes dialer refuse the connections the guard blocks, if the
+// client has one.
+func (hc *HTTPClient) guardDialer(dialer *net.Dialer) {
+	if hc.ssrfGuard != nil {
+		dialer.Control = hc.ssrfGuard.control
+	}
+}
@@ -0,0 +1,65 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestSSRFGuard(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	// Use a host name, so the address is only known once it is resolved.
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	client := newTestClient(t, WithSSRFGuard())
+	_, err := client.Get(url)
+	var blocked *BlockedAddressError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("err = %v, want *BlockedAddressError", err)
+	}
+	if !blocked.Addr.IsLoopback() {
+		t.Errorf("blocked address = %s, want a loopback address", blocked.Addr)
+	}
+	if hits != 0 {
+		t.Errorf("hits = %d, want 0", hits)
+	}
+
+	allowed := newTestClient(t, WithSSRFGuard(netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")))
+	data, err := allowed.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "internal" || hits != 1 {
+		t.Errorf("got %q after %d hits", data, hits)
+	}
+}
+
+func TestInternalAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fd00::1":         true,
+		"fe80::1":         true,
+		"8.8.8.8":         false,
+		"172.32.0.1":      false,
+		"2001:4860::8888": false,
+	} {
+		if got := internalAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("internalAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
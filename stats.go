@@ -0,0 +1,123 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTooBusy is returned instead of waiting when the concurrent fetch limit is
+// reached and the client was configured to fail fast.
+var ErrTooBusy = errors.New("httpcache: too many concurrent fetches")
+
+// Stats is a snapshot of the client's counters.
+type Stats struct {
+	// InFlight is the number of network fetches currently in progress.
+	InFlight int64
+	// BytesFromCache and BytesFromNetwork count the body bytes returned to
+	// callers, split by where they came from.
+	BytesFromCache   int64
+	BytesFromNetwork int64
+	// Latency describes the durations of network fetches since the client
+	// was created or ResetStats was called.
+	Latency LatencyStats
+	// DroppedEvents counts the events the channel returned by Events had no
+	// room for.
+	DroppedEvents int64
+	// BackgroundRefreshing is the number of WithRefreshOnExpiry refreshes
+	// running now. BackgroundSkipped counts those not started because
+	// WithBackgroundRefreshLimits' maximum was reached or, with
+	// WithSharedRefreshLock, another client was refreshing the entry, and
+	// BackgroundTimedOut those cancelled at its timeout.
+	BackgroundRefreshing int64
+	BackgroundSkipped    int64
+	BackgroundTimedOut   int64
+	// CompressedBytesIn and CompressedBytesOut sum the bodies given to the
+	// cache's Codec as it stored them and what it encoded them into.
+	// CompressionRatio is Out over In, below 1 when the codec saves space,
+	// and 0 before anything was encoded.
+	CompressedBytesIn  int64
+	CompressedBytesOut int64
+	CompressionRatio   float64
+}
+
+// Stats returns a snapshot of the client's counters.
+func (hc *HTTPClient) Stats() Stats {
+	in, out := hc.cache.codecIn.Load(), hc.cache.codecOut.Load()
+	var ratio float64
+	if in > 0 {
+		ratio = float64(out) / float64(in)
+	}
+	return Stats{
+		InFlight:         hc.inFlight.Load(),
+		BytesFromCache:   hc.bytesFromCache.Load(),
+		BytesFromNetwork: hc.bytesFromNetwork.Load(),
+		Latency:          hc.latency.snapshot(),
+		DroppedEvents:    hc.cache.events.dropped.Load(),
+
+		BackgroundRefreshing: hc.backgroundRefreshing.Load(),
+		BackgroundSkipped:    hc.backgroundSkipped.Load(),
+		BackgroundTimedOut:   hc.backgroundTimedOut.Load(),
+
+		CompressedBytesIn:  in,
+		CompressedBytesOut: out,
+		CompressionRatio:   ratio,
+	}
+}
+
+// ResetStats clears the accumulated counters. InFlight and
+// BackgroundRefreshing, which describe the present rather than the past, are
+// unaffected.
+func (hc *HTTPClient) ResetStats() {
+	hc.bytesFromCache.Store(0)
+	hc.bytesFromNetwork.Store(0)
+	hc.latency.reset()
+	hc.cache.events.dropped.Store(0)
+	hc.backgroundSkipped.Store(0)
+	hc.backgroundTimedOut.Store(0)
+	hc.cache.codecIn.Store(0)
+	hc.cache.codecOut.Store(0)
+}
+
+// countBytes adds the body of r to the byte counters.
+func (hc *HTTPClient) countBytes(r *Result) {
+	if r.FromCache {
+		hc.bytesFromCache.Add(int64(len(r.Data)))
+	} else {
+		hc.bytesFromNetwork.Add(int64(len(r.Data)))
+	}
+}
+
+// observeFetch records the duration of a network fetch that started at start.
+func (hc *HTTPClient) observeFetch(start time.Time) {
+	hc.latency.record(time.Since(start))
+}
+
+// acquireFetch reserves a slot for a network fetch, waiting for one to free
+// up unless the client fails fast. Cache hits never call it.
+func (hc *HTTPClient) acquireFetch(ctx context.Context) error {
+	if hc.fetchSem != nil {
+		if hc.failFastWhenBusy {
+			select {
+			case hc.fetchSem <- struct{}{}:
+			default:
+				return ErrTooBusy
+			}
+		} else {
+			select {
+			case hc.fetchSem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	hc.inFlight.Add(1)
+	return nil
+}
+
+func (hc *HTTPClient) releaseFetch() {
+	hc.inFlight.Add(-1)
+	if hc.fetchSem != nil {
+		<-hc.fetchSem
+	}
+}
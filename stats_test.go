@@ -0,0 +1,233 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentFetches(t *testing.T) {
+	var current, peak atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		current.Add(-1)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithMaxConcurrentFetches(2, false))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := client.Get(fmt.Sprintf("%s/%d", server.URL, i)); err != nil {
+				t.Errorf("request %d failed: %v", i, err)
+			}
+		}(i)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Stats().InFlight < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := client.Stats().InFlight; got != 2 {
+		t.Errorf("InFlight = %d, want 2", got)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := peak.Load(); got > 2 {
+		t.Errorf("peak concurrent fetches = %d, want <= 2", got)
+	}
+	if got := client.Stats().InFlight; got != 0 {
+		t.Errorf("InFlight after completion = %d, want 0", got)
+	}
+}
+
+func TestMaxConcurrentFetchesFailFast(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, WithMaxConcurrentFetches(1, true))
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Get(server.URL + "/slow")
+		done <- err
+	}()
+	<-started
+
+	if _, err := client.Get(server.URL + "/other"); !errors.Is(err, ErrTooBusy) {
+		t.Errorf("second fetch error = %v, want ErrTooBusy", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("first fetch failed: %v", err)
+	}
+
+	// Cache hits are exempt from the limit.
+	if _, err := client.Get(server.URL + "/slow"); err != nil {
+		t.Errorf("cached fetch failed: %v", err)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	var h latencyHistogram
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	s := h.snapshot()
+	if s.Count != 1000 || s.Min != time.Millisecond || s.Max != time.Second {
+		t.Errorf("count=%d min=%v max=%v", s.Count, s.Min, s.Max)
+	}
+	if want := 500500 * time.Microsecond; s.Avg != want {
+		t.Errorf("avg = %v, want %v", s.Avg, want)
+	}
+	for _, p := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"p50", s.P50, 500 * time.Millisecond},
+		{"p90", s.P90, 900 * time.Millisecond},
+		{"p99", s.P99, 990 * time.Millisecond},
+	} {
+		if diff := p.got - p.want; diff < -p.want/8 || diff > p.want/8 {
+			t.Errorf("%s = %v, want about %v", p.name, p.got, p.want)
+		}
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Do(server.URL, &RequestOptions{Preference: NetworkOnly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := client.Stats().Latency.Count; n != 3 {
+		t.Errorf("latency count = %d, want 3", n)
+	}
+	client.ResetStats()
+	if s := client.Stats().Latency; s != (LatencyStats{}) {
+		t.Errorf("after reset: %+v", s)
+	}
+}
+
+func TestBytesServed(t *testing.T) {
+	server, _ := countingServer(t)
+	client := newTestClient(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := int64(len("response 1"))
+	if s := client.Stats(); s.BytesFromNetwork != n || s.BytesFromCache != n {
+		t.Errorf("network=%d cache=%d, want %d each", s.BytesFromNetwork, s.BytesFromCache, n)
+	}
+	client.ResetStats()
+	if s := client.Stats(); s.BytesFromNetwork != 0 || s.BytesFromCache != 0 {
+		t.Errorf("after reset: network=%d cache=%d", s.BytesFromNetwork, s.BytesFromCache)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	client := newTestClient(t, WithCodec(GzipCodec{}))
+	if ratio := client.Stats().CompressionRatio; ratio != 0 {
+		t.Errorf("ratio before any write = %v, want 0", ratio)
+	}
+	for i := 0; i < 3; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		client.cache.Set(hashKey(url), bytes.Repeat([]byte("compressible "), 1000), url, url, time.Minute)
+	}
+
+	stats := client.Stats()
+	if stats.CompressedBytesIn != 3*13000 {
+		t.Errorf("bytes in = %d, want %d", stats.CompressedBytesIn, 3*13000)
+	}
+	if stats.CompressionRatio <= 0 || stats.CompressionRatio >= 1 {
+		t.Errorf("ratio = %v, want between 0 and 1", stats.CompressionRatio)
+	}
+
+	client.ResetStats()
+	if stats := client.Stats(); stats.CompressedBytesIn != 0 || stats.CompressionRatio != 0 {
+		t.Errorf("after ResetStats: %+v", stats)
+	}
+}
+
+func TestPersistentStats(t *testing.T) {
+	server, _ := countingServer(t)
+	dir := t.TempDir()
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client, err := NewClient(dir, policies, WithPersistentStats(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := client.Stats()
+	client.Close()
+	if before.BytesFromCache == 0 || before.BytesFromNetwork == 0 || before.Latency.Count != 1 {
+		t.Fatalf("stats before restart = %+v, want a hit and a fetch", before)
+	}
+
+	client, err = NewClient(dir, policies, WithPersistentStats(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	after := client.Stats()
+	if after.BytesFromCache != before.BytesFromCache || after.BytesFromNetwork != before.BytesFromNetwork ||
+		after.Latency != before.Latency {
+		t.Errorf("stats after restart = %+v, want %+v", after, before)
+	}
+	if entries, _, err := client.ListEntries("", 10); err != nil || len(entries) != 1 {
+		t.Errorf("ListEntries = %+v, %v; want the saved stats not listed as an entry", entries, err)
+	}
+
+	// A first start on a store with no saved stats, which MemoryStore
+	// reports as an empty value, is not an error.
+	logger := &recordingLogger{}
+	memClient := newTestClient(t, WithStore(NewMemoryStore()), WithPersistentStats(time.Hour), WithLogger(logger))
+	if _, err := memClient.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if logger.contains("saved stats") {
+		t.Errorf("first start logged %q", logger.messages)
+	}
+}
@@ -0,0 +1,176 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Store is the key-value storage backing a Cache. *store.LevelStore satisfies
+// it.
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	ForEach(slice *util.Range, callback func(key, value []byte) (bool, error)) error
+	Close() error
+}
+
+// NopStore is a Store that keeps nothing: every read misses and every write
+// is discarded. A client backed by it fetches every request from the network.
+type NopStore struct{}
+
+func (NopStore) Get(key string) ([]byte, error)     { return nil, nil }
+func (NopStore) Put(key string, value []byte) error { return nil }
+func (NopStore) Delete(key string) error            { return nil }
+func (NopStore) Close() error                       { return nil }
+
+func (NopStore) ForEach(slice *util.Range, callback func(key, value []byte) (bool, error)) error {
+	return nil
+}
+
+// Logger is the logging interface used by the cache. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+func (c *Cache) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// ErrStoreTimeout is returned by store operations that take longer than the
+// client's store operation timeout.
+var ErrStoreTimeout = errors.New("httpcache: store operation timed out")
+
+// timeoutStore bounds the time Get, Put and Delete may take on the wrapped
+// store. An operation that times out keeps running in the background; only
+// the caller stops waiting for it.
+type timeoutStore struct {
+	Store
+	timeout time.Duration
+}
+
+func (s *timeoutStore) Get(key string) ([]byte, error) {
+	type result struct {
+		value []byte
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := s.Store.Get(key)
+		done <- result{value, err}
+	}()
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		return nil, ErrStoreTimeout
+	}
+}
+
+func (s *timeoutStore) Put(key string, value []byte) error {
+	return s.run(func() error { return s.Store.Put(key, value) })
+}
+
+func (s *timeoutStore) Delete(key string) error {
+	return s.run(func() error { return s.Store.Delete(key) })
+}
+
+func (s *timeoutStore) run(op func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrStoreTimeout
+	}
+}
+
+// setStore makes s the client's store, bounding its operations if a store
+// operation timeout is configured.
+func (hc *HTTPClient) setStore(s Store) {
+	if hc.storeOpTimeout > 0 {
+		s = &timeoutStore{Store: s, timeout: hc.storeOpTimeout}
+	}
+	hc.cache.Store = s
+}
+
+// StoreIterator iterates forward over the entries of a store in key order.
+// Next advances it and must be called before the first entry is read; Key
+// and Value then return that entry. Release must be called once done.
+type StoreIterator interface {
+	Next() bool
+	Key() string
+	// Value is the entry as stored: a serialized CacheEntry, its body
+	// possibly encoded or deduplicated. DecodeEntry turns it back into the
+	// entry that was set.
+	Value() []byte
+	// Err returns the error that ended the iteration early, if any.
+	Err() error
+	Release()
+}
+
+// Iterator returns an iterator over the raw cache entries of the store, for
+// tooling the listing methods do not cover. Keys reserved for the cache's own
+// bookkeeping, such as deduplicated content, are skipped. The iterator holds
+// the store's iteration open until it is released.
+func (hc *HTTPClient) Iterator() (StoreIterator, error) {
+	var err error
+	seq := func(yield func(string, []byte) bool) {
+		err = hc.cache.Store.ForEach(nil, func(key, value []byte) (bool, error) {
+			if strings.HasPrefix(string(key), reservedKeyPrefix) {
+				return true, nil
+			}
+			return yield(string(key), bytes.Clone(value)), nil
+		})
+	}
+	next, stop := iter.Pull2(iter.Seq2[string, []byte](seq))
+	return &storeIterator{next: next, stop: stop, err: &err}, nil
+}
+
+// DecodeEntry decodes a raw value read with Iterator into the entry that was
+// set. See Cache.DecodeEntry.
+func (hc *HTTPClient) DecodeEntry(value []byte) (*CacheEntry, error) {
+	return hc.cache.DecodeEntry(value)
+}
+
+// DecodeEntry decodes a value read from the store into the entry that was
+// set, whichever serializer wrote it: deduplicated content is resolved and
+// the body decoded with the registered codec it was encoded with and from
+// the Content-Encoding it was kept in.
+func (c *Cache) DecodeEntry(value []byte) (*CacheEntry, error) {
+	return c.decodeEntry(value)
+}
+
+type storeIterator struct {
+	next  func() (string, []byte, bool)
+	stop  func()
+	err   *error
+	key   string
+	value []byte
+}
+
+func (it *storeIterator) Next() bool {
+	var ok bool
+	it.key, it.value, ok = it.next()
+	return ok
+}
+
+func (it *storeIterator) Key() string   { return it.key }
+func (it *storeIterator) Value() []byte { return it.value }
+func (it *storeIterator) Err() error    { return *it.err }
+func (it *storeIterator) Release()      { it.stop() }
@@ -0,0 +1,170 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, msg := range l.messages {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFallbackToPassthrough(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("test response"))
+	}))
+	defer server.Close()
+
+	// A regular file where the LevelDB directory should be makes opening fail.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data"), []byte("not a directory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+
+	if _, err := NewClient(dir, policies); err == nil {
+		t.Fatal("NewClient succeeded without fallback, want error")
+	}
+
+	logger := &recordingLogger{}
+	client, err := NewClient(dir, policies, WithFallbackToPassthrough(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("NewClient with fallback failed: %v", err)
+	}
+	defer client.Close()
+
+	if !logger.contains("pass-through") {
+		t.Errorf("expected a pass-through warning, got %v", logger.messages)
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i+1, err)
+		}
+		if string(data) != "test response" {
+			t.Errorf("request %d: got %q", i+1, data)
+		}
+	}
+	if hits != 2 {
+		t.Errorf("server hits = %d, want 2 (nothing should be cached)", hits)
+	}
+	if client.GetStore() != nil {
+		t.Error("GetStore() should be nil in pass-through mode")
+	}
+}
+
+// slowStore is a NopStore whose operations take delay.
+type slowStore struct {
+	NopStore
+	delay time.Duration
+}
+
+func (s slowStore) Get(key string) ([]byte, error) {
+	time.Sleep(s.delay)
+	return nil, nil
+}
+
+func (s slowStore) Put(key string, value []byte) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func TestStoreOpTimeout(t *testing.T) {
+	server, hits := countingServer(t)
+	logger := &recordingLogger{}
+	policies := []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Minute}}
+	client := newHTTPClient(policies, WithStoreOpTimeout(20*time.Millisecond), WithLogger(logger))
+	client.setStore(slowStore{delay: time.Second})
+
+	start := time.Now()
+	data, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Get took %v with a stalled store", elapsed)
+	}
+	if string(data) != "response 1" || hits.Load() != 1 {
+		t.Errorf("data=%q hits=%d, want a network fetch", data, hits.Load())
+	}
+	if !logger.contains(ErrStoreTimeout.Error()) {
+		t.Errorf("expected the timed out write to be logged, got %v", logger.messages)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	client := newTestClient(t, WithDedup(), WithCodec(GzipCodec{}))
+	want := map[string]string{}
+	for i := 0; i < 5; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		want[hashKey(url)] = url
+		client.cache.Set(hashKey(url), []byte("shared body"), url, url, time.Minute)
+	}
+
+	it, err := client.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Release()
+	var last string
+	n := 0
+	for it.Next() {
+		if it.Key() <= last {
+			t.Errorf("key %s after %s, want ascending order", it.Key(), last)
+		}
+		last = it.Key()
+		entry, err := client.DecodeEntry(it.Value())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.URL != want[it.Key()] || string(entry.Data) != "shared body" {
+			t.Errorf("%s: decoded %s with %q", it.Key(), entry.URL, entry.Data)
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Errorf("iterated %d entries, want %d without the deduplicated content", n, len(want))
+	}
+
+	// Releasing early ends the iteration.
+	early, _ := client.Iterator()
+	if !early.Next() {
+		t.Fatal("iterator is empty")
+	}
+	early.Release()
+	if early.Next() {
+		t.Error("Next after Release should report no more entries")
+	}
+}
@@ -0,0 +1,65 @@
+package httpcache
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// GetText is like Get but returns the body decoded to UTF-8 from the
+// character set given by the Content-Type header or declared in the body,
+// falling back to windows-1252 for undeclared bodies that are not valid
+// UTF-8. The cache keeps the body as received, so Get still returns the raw
+// bytes; the detected character set is stored with the entry.
+func (hc *HTTPClient) GetText(url string) (string, error) {
+	r, err := hc.Do(url, nil)
+	if err != nil {
+		return "", err
+	}
+	name := r.Charset
+	if name == "" {
+		name = detectCharset(r.Data, r.Header.Get("Content-Type"))
+	}
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return string(r.Data), nil
+	}
+	text, err := enc.NewDecoder().Bytes(r.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// detectCharset returns the name of the character set of body, served with
+// contentType.
+func detectCharset(body []byte, contentType string) string {
+	_, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && utf8.Valid(body) {
+		return "utf-8"
+	}
+	return name
+}
+
+// isText reports whether contentType describes a body worth detecting the
+// character set of. An empty type counts, as servers often omit it for text.
+func isText(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, s := range []string{"html", "xml", "json", "javascript"} {
+		if strings.Contains(mediaType, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestGetText(t *testing.T) {
+	const want = "你好，世界"
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Content-Type", "text/html; charset=gbk")
+		case "/meta":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<meta charset="gb2312">`))
+		}
+		w.Write(gbk)
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	for _, path := range []string{"/header", "/meta"} {
+		for i := 0; i < 2; i++ {
+			text, err := client.GetText(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := text[len(text)-len(want):]; got != want {
+				t.Errorf("%s: GetText = %q, want it to end in %q", path, text, want)
+			}
+		}
+		entry, _ := client.cache.GetEntry(client.key(server.URL + path))
+		if entry == nil || entry.Charset != "gbk" {
+			t.Errorf("%s: stored entry %+v, want charset gbk", path, entry)
+		}
+		raw, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := raw[len(raw)-len(gbk):]; string(got) != string(gbk) {
+			t.Errorf("%s: Get returned %q, want the raw GBK bytes", path, raw)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// newTransport builds the client's transport from its options.
+func (hc *HTTPClient) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: hc.dialTimeout}
+	hc.guardDialer(dialer)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := hc.unixSocket(addr); ok {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if hc.proxy != nil {
+		transport.Proxy = http.ProxyURL(hc.proxy)
+	}
+	if hc.proxyUser != nil {
+		transport.Proxy = withProxyUser(transport.Proxy, hc.proxyUser)
+	}
+	if len(hc.headerOrder) > 0 {
+		hc.orderHeaderTransport(transport)
+	}
+	return transport
+}
+
+// withProxyUser wraps proxy so that the proxies it picks carry user as their
+// credentials. The transport turns them into a Proxy-Authorization header
+// sent to the proxy alone: on each request for http URLs, and on the CONNECT
+// request that opens the tunnel for https ones.
+func withProxyUser(proxy func(*http.Request) (*url.URL, error), user *url.Userinfo) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if u == nil || err != nil {
+			return u, err
+		}
+		withUser := *u
+		withUser.User = user
+		return &withUser, nil
+	}
+}
+
+// unixSocket returns the socket configured for addr, matched either as
+// host:port or by host alone.
+func (hc *HTTPClient) unixSocket(addr string) (string, bool) {
+	if len(hc.unixSockets) == 0 {
+		return "", false
+	}
+	if path, ok := hc.unixSockets[addr]; ok {
+		return path, true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	path, ok := hc.unixSockets[host]
+	return path, ok
+}
@@ -0,0 +1,173 @@
+package httpcache
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "http.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	var hits int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("via socket " + r.Host + r.URL.Path))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client := newTestClient(t, WithUnixSocket("internal.service", socket))
+
+	url := "http://internal.service/status"
+	for i := 0; i < 2; i++ {
+		data, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "via socket internal.service/status" {
+			t.Errorf("got %q", data)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+	if _, found := client.cache.GetEntry(hashKey(url)); !found {
+		t.Error("entry should be keyed by the logical URL")
+	}
+}
+
+// authProxy is a fake proxy requiring basic auth. It answers plain HTTP
+// requests itself and tunnels CONNECT requests to their target.
+func authProxy(t *testing.T, user, password string) *httptest.Server {
+	t.Helper()
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			w.Write([]byte("proxied " + r.URL.String()))
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() {
+			io.Copy(target, conn)
+			target.Close()
+		}()
+		io.Copy(conn, target)
+		conn.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestProxyAuth(t *testing.T) {
+	proxy := authProxy(t, "crawler", "p@ss:word")
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	client := newTestClient(t, WithProxy(proxyURL), WithProxyAuth("crawler", "p@ss:word"))
+	data, err := client.Get("http://example.com/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "proxied http://example.com/page" {
+		t.Errorf("http through proxy: got %q", data)
+	}
+
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("proxy credentials leaked to the origin")
+		}
+		w.Write([]byte("tunneled"))
+	}))
+	defer origin.Close()
+	client.client.Transport.(*http.Transport).TLSClientConfig = origin.Client().Transport.(*http.Transport).TLSClientConfig
+	data, err = client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "tunneled" {
+		t.Errorf("https through proxy: got %q", data)
+	}
+
+	unauthenticated := newTestClient(t, WithProxy(proxyURL))
+	r, err := unauthenticated.Do("http://example.com/page", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("without credentials: status %d, want 407", r.StatusCode)
+	}
+}
+
+func TestConnectionInfo(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+	client.client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	_, info, err := client.GetWithInfo(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Proto != "HTTP/1.1" {
+		t.Errorf("Proto = %q, want HTTP/1.1", info.Proto)
+	}
+	if info.TLS == nil || info.TLS.Version < tls.VersionTLS12 || info.TLS.CipherSuite == 0 {
+		t.Fatalf("TLS = %+v, want the negotiated version and cipher", info.TLS)
+	}
+
+	_, info, err = client.GetWithInfo(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.FromCache || info.Proto != "" || info.TLS != nil {
+		t.Errorf("cache hit: fromCache=%v proto=%q tls=%v, want no connection details", info.FromCache, info.Proto, info.TLS)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// Nothing answers at this non-routable address, so connecting hangs
+	// until the dial timeout, or fails at once without a route.
+	url := "http://10.255.255.1/"
+	client := newTestClient(t, WithDialTimeout(100*time.Millisecond))
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.Do(url, &RequestOptions{Context: ctx})
+	if err == nil {
+		t.Fatal("fetch from an unroutable address succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fetch failed after %v, want within the dial timeout", elapsed)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("the request context ran out instead of the dial timeout: %v", err)
+	}
+}
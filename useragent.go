@@ -0,0 +1,72 @@
+package httpcache
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/projectdiscovery/useragent"
+)
+
+// userAgentRotation is the list of User-Agents given to WithUserAgents and
+// the one currently in use for each host.
+type userAgentRotation struct {
+	agents  []string
+	blocked func(status int, body []byte) bool
+
+	mu      sync.Mutex
+	current map[string]int // host -> index into agents
+}
+
+// BlockedStatus is the block detector WithUserAgents uses by default: it
+// treats 403 Forbidden and 429 Too Many Requests as a block.
+func BlockedStatus(status int, body []byte) bool {
+	return status == http.StatusForbidden || status == http.StatusTooManyRequests
+}
+
+// defaultUserAgent is the User-Agent of requests without a rotation or any
+// User-Agent header of their own.
+func defaultUserAgent() string {
+	return useragent.UserAgents[0].String()
+}
+
+// agent returns the User-Agent currently in use for host. A nil rotation
+// gives the default one.
+func (r *userAgentRotation) agent(host string) string {
+	if r == nil {
+		return defaultUserAgent()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.agents[r.current[host]]
+}
+
+// rotate moves req's host on to the next User-Agent if the fetch of req,
+// which ended with entry and err, was blocked while using the host's
+// current one, and returns the User-Agent to resend req with. Requests whose
+// User-Agent is not from the rotation, because a header set it, are left
+// alone.
+func (r *userAgentRotation) rotate(req *http.Request, entry *CacheEntry, err error) (string, bool) {
+	if r == nil || err != nil || !r.blocked(entry.StatusCode, entry.Data) {
+		return "", false
+	}
+	used := slices.Index(r.agents, req.Header.Get("User-Agent"))
+	if used < 0 {
+		return "", false
+	}
+	host := req.URL.Host
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// A concurrent request may already have moved the host on.
+	if r.current[host] == used {
+		r.current[host] = (used + 1) % len(r.agents)
+	}
+	return r.agents[r.current[host]], true
+}
+
+// UserAgentFor returns the User-Agent requests to host are currently sent
+// with, host being a URL's host with any port, as in "example.com:8080".
+// Headers given through WithHeader, policies or per call still override it.
+func (hc *HTTPClient) UserAgentFor(host string) string {
+	return hc.userAgents.agent(host)
+}
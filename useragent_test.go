@@ -0,0 +1,81 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUserAgentRotation(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua := r.Header.Get("User-Agent")
+		mu.Lock()
+		seen = append(seen, ua)
+		mu.Unlock()
+		if ua == "crawler/1" {
+			http.Error(w, "blocked", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, "%s for %s", r.URL.Path, ua)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	plain := newTestClient(t)
+	client := newTestClient(t, WithUserAgents([]string{"crawler/1", "crawler/2"}, nil))
+	if got := client.UserAgentFor(host); got != "crawler/1" {
+		t.Errorf("initial agent = %q, want crawler/1", got)
+	}
+
+	r, err := client.Do(server.URL+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusOK || string(r.Data) != "/a for crawler/2" {
+		t.Errorf("got %d %q, want the second agent's response", r.StatusCode, r.Data)
+	}
+	if got := client.UserAgentFor(host); got != "crawler/2" {
+		t.Errorf("agent after the block = %q, want crawler/2", got)
+	}
+
+	// The working agent is remembered for the host.
+	if _, err := client.Get(server.URL + "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"crawler/1", "crawler/2", "crawler/2"}; fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("agents sent = %v, want %v", seen, want)
+	}
+
+	// The agent does not enter the cache key.
+	if client.key(server.URL+"/a") != plain.key(server.URL+"/a") {
+		t.Error("User-Agent rotation changed the cache key")
+	}
+	u, _ := url.Parse(server.URL)
+	if got := client.UserAgentFor("other.example:" + u.Port()); got != "crawler/1" {
+		t.Errorf("agent for an unrelated host = %q, want crawler/1", got)
+	}
+}
+
+func TestUserAgentRotationAllBlocked(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("Access denied"))
+	}))
+	defer server.Close()
+
+	blocked := func(status int, body []byte) bool { return strings.Contains(string(body), "denied") }
+	client := newTestClient(t, WithUserAgents([]string{"a", "b", "c"}, blocked))
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 3 {
+		t.Errorf("hits = %d, want each agent tried once", hits)
+	}
+}
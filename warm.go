@@ -0,0 +1,100 @@
+package httpcache
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WarmReport summarizes a Warm run.
+type WarmReport struct {
+	// Fetched counts the URLs fetched from the network, Skipped those that
+	// already had a fresh entry and Failed those whose warming failed.
+	Fetched int
+	Skipped int
+	Failed  int
+	// Bytes is the total size of the bodies fetched.
+	Bytes int64
+	// Elapsed is how long the run took.
+	Elapsed time.Duration
+	// Errors holds the error for each URL, in order, nil where warming
+	// succeeded.
+	Errors []error
+}
+
+// Warm fetches urls into the cache, running up to concurrency fetches at
+// once (one if concurrency is not positive). URLs with a fresh entry are not
+// fetched again. The returned report counts what happened to the URLs and
+// holds the error of each.
+func (hc *HTTPClient) Warm(urls []string, concurrency int) WarmReport {
+	start := time.Now()
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	report := WarmReport{Errors: make([]error, len(urls))}
+	var mu sync.Mutex
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(urls); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r, err := hc.Do(urls[i], nil)
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Errors[i] = err
+					report.Failed++
+				case r.FromCache:
+					report.Skipped++
+				default:
+					report.Fetched++
+					report.Bytes += int64(len(r.Data))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range urls {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report
+}
+
+// ReadURLFile reads a newline-delimited list of URLs, skipping blank lines
+// and lines starting with #.
+func ReadURLFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// WarmFromFile warms the cache with the URLs listed in the file at path, as
+// read by ReadURLFile, and reports as Warm does. If the file cannot be read,
+// that error is the only one in the report.
+func (hc *HTTPClient) WarmFromFile(path string, concurrency int) WarmReport {
+	urls, err := ReadURLFile(path)
+	if err != nil {
+		return WarmReport{Errors: []error{err}}
+	}
+	return hc.Warm(urls, concurrency)
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWarmFromFile(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte("page " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	list := strings.Join([]string{
+		"# pages to prime",
+		server.URL + "/a",
+		"",
+		"  " + server.URL + "/b  ",
+		"http://[::1]:namedport/bad",
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t)
+	errs := client.WarmFromFile(path, 2).Errors
+	if len(errs) != 3 {
+		t.Fatalf("got %d results, want one per URL: %v", len(errs), errs)
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("errs = %v, want only the bad URL to fail", errs)
+	}
+
+	for _, p := range []string{"/a", "/b"} {
+		r, err := client.Do(server.URL+p, &RequestOptions{Preference: CacheOnly})
+		if err != nil || string(r.Data) != "page "+p {
+			t.Errorf("%s not warmed: %q, %v", p, r.Data, err)
+		}
+		if hits[p] != 1 {
+			t.Errorf("%s fetched %d times, want 1", p, hits[p])
+		}
+	}
+
+	if errs := client.WarmFromFile(filepath.Join(t.TempDir(), "missing"), 1).Errors; len(errs) != 1 || errs[0] == nil {
+		t.Errorf("missing file: errs = %v", errs)
+	}
+}
+
+func TestWarmReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page " + r.URL.Path))
+	}))
+	defer server.Close()
+	client := newTestClient(t)
+
+	for _, p := range []string{"/cached1", "/cached2"} {
+		if _, err := client.Get(server.URL + p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	urls := []string{
+		server.URL + "/cached1",
+		server.URL + "/new1",
+		server.URL + "/cached2",
+		server.URL + "/new22",
+		"http://[::1]:namedport/bad",
+	}
+	report := client.Warm(urls, 3)
+
+	if report.Fetched != 2 || report.Skipped != 2 || report.Failed != 1 {
+		t.Errorf("fetched %d, skipped %d, failed %d; want 2, 2, 1", report.Fetched, report.Skipped, report.Failed)
+	}
+	if want := int64(len("page /new1") + len("page /new22")); report.Bytes != want {
+		t.Errorf("bytes = %d, want %d", report.Bytes, want)
+	}
+	if report.Elapsed <= 0 {
+		t.Errorf("elapsed = %v", report.Elapsed)
+	}
+	if len(report.Errors) != len(urls) || report.Errors[4] == nil {
+		t.Errorf("errors = %v, want one per URL with the last failing", report.Errors)
+	}
+}
@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// BatchEntry is an entry for SetBatch to store under Key for TTL.
+type BatchEntry struct {
+	Key   string
+	Entry *CacheEntry
+	TTL   time.Duration
+}
+
+// writeBatchSize is the number of entries committed in one LevelDB batch.
+const writeBatchSize = 1000
+
+// SetBatch stores entries as SetEntry would store each of them. On a LevelDB
+// store they are committed in LevelDB write batches of up to 1000 entries
+// rather than with a Put per entry; BenchmarkSetBatch compares the two.
+// Other stores, and caches with Dedup, whose reference counting reads the
+// entry each write replaces, fall back to storing the entries one at a time.
+// On error, the batches committed so far stay. The differences from SetEntry
+// are these: WithStoreOpTimeout bounds each batch commit rather than each
+// entry, and encoding or write failures are returned rather than logged.
+// Like SetEntry, SetBatch publishes no EventStore, which is reserved for
+// fetched responses, and leaves any GetJSON parsed value of a replaced entry
+// in place, where its body hash no longer matches and it is never served.
+func (c *Cache) SetBatch(entries []BatchEntry) error {
+	now := time.Now()
+	for _, e := range entries {
+		e.Entry.CrawledAt = now
+		e.Entry.ExpiresAt = now.Add(c.clampTTL(e.TTL))
+	}
+	return c.writeBatch(entries)
+}
+
+// writeBatch stores entries as they are, like writeEntry, batching the
+// writes when the store allows. TTLs are ignored.
+func (c *Cache) writeBatch(entries []BatchEntry) error {
+	db := levelDB(c.Store)
+	if db == nil || c.Dedup {
+		for _, e := range entries {
+			if err := c.writeEntry(e.Key, e.Entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		// A write that times out may still be running, so the next batch
+		// gets a fresh one.
+		committed := batch
+		batch = new(leveldb.Batch)
+		write := func() error { return db.Write(committed, nil) }
+		if ts, ok := c.Store.(*timeoutStore); ok {
+			return ts.run(write)
+		}
+		return write()
+	}
+	for _, e := range entries {
+		stored := c.encodeBody(e.Entry)
+		encoded, err := c.marshal(&stored)
+		if err != nil {
+			if flushErr := flush(); flushErr != nil {
+				return flushErr
+			}
+			return err
+		}
+		batch.Put([]byte(e.Key), encoded)
+		if batch.Len() >= writeBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,105 @@
+package httpcache
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSetBatch(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithCodec(GzipCodec{})}, {WithDedup()}, {WithStore(NewMemoryStore())}, {WithStoreOpTimeout(time.Second)}} {
+		client := newTestClient(t, opts...)
+		entries := make([]BatchEntry, 2*writeBatchSize+5)
+		for i := range entries {
+			url := fmt.Sprintf("http://example.com/%d", i)
+			entries[i] = BatchEntry{
+				Key:   client.key(url),
+				Entry: &CacheEntry{Data: []byte(url), URL: url, FinalURL: url},
+				TTL:   time.Minute,
+			}
+		}
+		if err := client.cache.SetBatch(entries); err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range []int{0, writeBatchSize, len(entries) - 1} {
+			url := fmt.Sprintf("http://example.com/%d", i)
+			entry, _ := client.cache.GetEntry(client.key(url))
+			if entry == nil || string(entry.Data) != url {
+				t.Fatalf("options %d: entry %d = %+v", len(opts), i, entry)
+			}
+			if entry.ExpiresAt.Sub(entry.CrawledAt) != time.Minute {
+				t.Errorf("options %d: entry %d stored for %v, want 1m", len(opts), i, entry.ExpiresAt.Sub(entry.CrawledAt))
+			}
+		}
+	}
+}
+
+func benchmarkEntries(n int) []BatchEntry {
+	entries := make([]BatchEntry, n)
+	for i := range entries {
+		url := fmt.Sprintf("http://example.com/page/%d", i)
+		entries[i] = BatchEntry{
+			Key:   hashKey(url),
+			Entry: &CacheEntry{Data: make([]byte, 512), URL: url, FinalURL: url},
+			TTL:   time.Hour,
+		}
+	}
+	return entries
+}
+
+func newBenchmarkCache(b *testing.B) *Cache {
+	b.Helper()
+	client, err := NewClient(b.TempDir(), []CachePolicy{{Pattern: regexp.MustCompile(".*"), TTL: time.Hour}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(client.Close)
+	return client.cache
+}
+
+func BenchmarkSetEntry(b *testing.B) {
+	entries := benchmarkEntries(5000)
+	cache := newBenchmarkCache(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range entries {
+			cache.SetEntry(e.Key, e.Entry, e.TTL)
+		}
+	}
+}
+
+func BenchmarkSetBatch(b *testing.B) {
+	entries := benchmarkEntries(5000)
+	cache := newBenchmarkCache(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cache.SetBatch(entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPurgeExpired(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			entries := benchmarkEntries(5000)
+			for _, e := range entries {
+				e.Entry.TTL = time.Nanosecond
+			}
+			cache := newBenchmarkCache(b)
+			cache.MaintenanceWorkers = workers
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := cache.SetBatch(entries); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if n, _, err := cache.PurgeExpired("", 0); err != nil || n != len(entries) {
+					b.Fatalf("purged %d entries: %v", n, err)
+				}
+			}
+		})
+	}
+}